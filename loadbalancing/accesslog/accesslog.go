@@ -0,0 +1,185 @@
+// Package accesslog writes one line per proxied request, recording who asked
+// for what, how it went and which backend served it and why, so skewed
+// traffic can be explained from the log alone.
+//
+// Lines are JSON objects by default, or rendered from a text/template over
+// Entry, for example:
+//
+//	{{.ClientIP}} {{.Method}} {{.Path}} {{.Status}} {{.Backend}} "{{.Reason}}"
+//
+// Logs are usually written to a RotatingFile, which rotates them by size
+// and age.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Formats accepted by New besides a template.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// TextTemplate is the template of FormatText.
+const TextTemplate = `{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}} {{.Listener}} {{.ClientIP}} "{{.Method}} {{.Path}}" {{.Status}} {{.Bytes}} {{.Duration}} upstream={{.Upstream}} backend={{.Backend}} algorithm={{.Algorithm}} retries={{.Retries}} reason="{{.Reason}}"`
+
+// Entry describes one proxied request.
+type Entry struct {
+	Time      time.Time     // When the request arrived
+	Listener  string        // Name of the listener that received the request
+	ClientIP  string        // Client address the balancer saw
+	Method    string        // HTTP method
+	Path      string        // URL path of the request
+	Status    int           // Status sent to the client
+	Bytes     int64         // Response body bytes sent to the client
+	Duration  time.Duration // Time from arrival until the response was sent
+	Upstream  time.Duration // Time until the backend answered or failed, zero without a backend
+	Backend   string        // ID of the chosen backend, empty when none was available
+	Algorithm string        // Algorithm that chose the backend
+	Reason    string        // Why the algorithm chose the backend, e.g. "least connections=3"
+	Retries   int           // Further backends tried after the first one
+}
+
+// jsonEntry is the JSON form of an Entry.
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Listener   string  `json:"listener,omitempty"`
+	ClientIP   string  `json:"client_ip"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	UpstreamMS float64 `json:"upstream_ms"`
+	Backend    string  `json:"backend"`
+	Algorithm  string  `json:"algorithm"`
+	Reason     string  `json:"reason,omitempty"`
+	Retries    int     `json:"retries"`
+}
+
+// Sink receives the entry of every proxied request. Logger is the usual
+// one, other packages record entries in their own format.
+type Sink interface {
+	Log(entry Entry) error
+}
+
+// Tee returns a Sink passing every entry to each of sinks, ignoring nil ones.
+func Tee(sinks ...Sink) Sink {
+	var t tee
+	for _, s := range sinks {
+		if s != nil {
+			t = append(t, s)
+		}
+	}
+	return t
+}
+
+type tee []Sink
+
+func (t tee) Log(entry Entry) error {
+	var errs []error
+	for _, s := range t {
+		if err := s.Log(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Logger writes entries to a writer, one line each. It is safe for
+// concurrent use.
+type Logger struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	template *template.Template // nil writes JSON
+}
+
+// New creates a Logger writing to w.
+//
+// Parameters:
+//   - w: Where lines are written, closed by Close if it is an io.Closer
+//   - format: FormatJSON or empty for JSON lines, FormatText for TextTemplate, or a text/template over Entry
+//
+// Returns:
+//   - *Logger: A pointer to the new Logger instance
+//   - error: An error if the template does not parse
+func New(w io.Writer, format string) (*Logger, error) {
+	l := &Logger{w: w}
+	switch format {
+	case "", FormatJSON:
+		return l, nil
+	case FormatText:
+		format = TextTemplate
+	}
+
+	t, err := template.New("accesslog").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log template: %v", err)
+	}
+	// Fail now rather than on every request if the template names a field Entry lacks.
+	if err := t.Execute(io.Discard, Entry{}); err != nil {
+		return nil, fmt.Errorf("invalid access log template: %v", err)
+	}
+	l.template = t
+	return l, nil
+}
+
+// Log writes entry as one line.
+func (l *Logger) Log(entry Entry) error {
+	var line bytes.Buffer
+	if l.template != nil {
+		if err := l.template.Execute(&line, entry); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+			line.WriteByte('\n')
+		}
+	} else if err := json.NewEncoder(&line).Encode(toJSON(entry)); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.w.Write(line.Bytes())
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (l *Logger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if closer, ok := l.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func toJSON(entry Entry) jsonEntry {
+	return jsonEntry{
+		Time:       entry.Time.Format(time.RFC3339Nano),
+		Listener:   entry.Listener,
+		ClientIP:   entry.ClientIP,
+		Method:     entry.Method,
+		Path:       entry.Path,
+		Status:     entry.Status,
+		Bytes:      entry.Bytes,
+		DurationMS: milliseconds(entry.Duration),
+		UpstreamMS: milliseconds(entry.Upstream),
+		Backend:    entry.Backend,
+		Algorithm:  entry.Algorithm,
+		Reason:     entry.Reason,
+		Retries:    entry.Retries,
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
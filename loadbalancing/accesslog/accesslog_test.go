@@ -0,0 +1,194 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEntry() Entry {
+	return Entry{
+		Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Listener:  "web",
+		ClientIP:  "10.0.0.1",
+		Method:    "GET",
+		Path:      "/index.html",
+		Status:    200,
+		Bytes:     512,
+		Duration:  3 * time.Millisecond,
+		Upstream:  2500 * time.Microsecond,
+		Backend:   "a",
+		Algorithm: "least_connection",
+		Reason:    "least connections=3",
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := logger.Log(testEntry()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"time":        "2024-05-01T12:00:00Z",
+		"listener":    "web",
+		"client_ip":   "10.0.0.1",
+		"method":      "GET",
+		"path":        "/index.html",
+		"status":      float64(200),
+		"bytes":       float64(512),
+		"duration_ms": float64(3),
+		"upstream_ms": 2.5,
+		"backend":     "a",
+		"algorithm":   "least_connection",
+		"reason":      "least connections=3",
+		"retries":     float64(0),
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestLogTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, `{{.ClientIP}} {{.Status}} {{.Upstream}} {{.Backend}} "{{.Reason}}"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Log(testEntry())
+
+	if want := "10.0.0.1 200 2.5ms a \"least connections=3\"\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestTeeLogsToEverySink(t *testing.T) {
+	var first, second bytes.Buffer
+	a, _ := New(&first, "{{.Backend}}")
+	b, _ := New(&second, "{{.ClientIP}}")
+	if err := Tee(a, nil, b).Log(testEntry()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first.String() != "a\n" || second.String() != "10.0.0.1\n" {
+		t.Errorf("expected both sinks to log the entry, got %q and %q", first.String(), second.String())
+	}
+}
+
+func TestNewRejectsInvalidTemplates(t *testing.T) {
+	for _, format := range []string{"{{.ClientIP", "{{.NoSuchField}}"} {
+		if _, err := New(&bytes.Buffer{}, format); err == nil {
+			t.Errorf("expected error for %q, got nil", format)
+		}
+	}
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, Rotation{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "fourth\n" {
+		t.Errorf("expected the current file to hold the last line, got %q", data)
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups kept, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "second\n" {
+		t.Errorf("expected the oldest backup of \"first\" to be deleted, oldest kept holds %q", data)
+	}
+}
+
+func TestRotatingFileRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f, err := NewRotatingFile(path, Rotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	f.Write([]byte("old\n"))
+	now = now.Add(30 * time.Minute)
+	f.Write([]byte("still young\n"))
+	now = now.Add(30 * time.Minute)
+	f.Write([]byte("new\n"))
+
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("expected a new file after an hour, got %q", data)
+	}
+	backups, _ := f.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); !strings.HasPrefix(string(data), "old\nstill young\n") {
+		t.Errorf("expected the backup to hold the first hour, got %q", data)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, Rotation{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	// A non-empty directory at the backup name makes the rename fail.
+	blocked := path + "." + now.Format(backupTime)
+	if err := os.MkdirAll(filepath.Join(blocked, "dir"), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f.Write([]byte("first\n"))
+	if _, err := f.Write([]byte("second\n")); err == nil {
+		t.Error("expected the failed rotation to be reported")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "first\nsecond\n" {
+		t.Errorf("expected the current file to keep the lines, got %q", data)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := f.Write([]byte("third\n")); err != nil {
+		t.Fatalf("expected the next rotation to succeed, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "third\n" {
+		t.Errorf("expected a new file after rotating, got %q", data)
+	}
+}
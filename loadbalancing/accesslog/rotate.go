@@ -0,0 +1,186 @@
+package accesslog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTime is the layout of the timestamp appended to rotated files. It
+// sorts chronologically.
+const backupTime = "20060102T150405.000"
+
+// Rotation decides when a RotatingFile starts a new file and how many old
+// ones it keeps.
+type Rotation struct {
+	MaxSize    int64         // Rotate before a write would grow the file beyond this many bytes, 0 never
+	MaxAge     time.Duration // Rotate once the file has been written for this long, 0 never
+	MaxBackups int           // Rotated files kept, the oldest are deleted first, 0 keeps all
+}
+
+// RotatingFile is an io.WriteCloser appending to a file that it renames to
+// "<path>.<timestamp>" and replaces with an empty one as configured. The age
+// of a file counts from when it was opened, so appending to an existing file
+// after a restart starts its age over. It is safe for concurrent use.
+type RotatingFile struct {
+	path     string
+	rotation Rotation
+	now      func() time.Time
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingFile opens path for appending, creating it if needed.
+//
+// Parameters:
+//   - path: The file written to
+//   - rotation: When to rotate and how many rotated files to keep
+//
+// Returns:
+//   - *RotatingFile: A pointer to the new RotatingFile instance
+//   - error: An error if the file cannot be opened
+func NewRotatingFile(path string, rotation Rotation) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, rotation: rotation, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file, rotating it first if it is too old or p
+// would make it too large. p is never split across files. If rotating fails,
+// p is still appended to the current file and the rotation error returned;
+// the next write tries again.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.due(int64(len(p))) {
+		rotateErr = f.rotate()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, errors.Join(err, rotateErr)
+}
+
+// Rotate starts a new file at once, whatever its size and age.
+func (f *RotatingFile) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.rotate()
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// due reports whether the file must be rotated before writing n more bytes.
+// An empty file is never rotated, so writes larger than MaxSize still land.
+// The caller must hold f.mutex.
+func (f *RotatingFile) due(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.rotation.MaxSize > 0 && f.size+n > f.rotation.MaxSize {
+		return true
+	}
+	return f.rotation.MaxAge > 0 && f.now().Sub(f.opened) >= f.rotation.MaxAge
+}
+
+// open opens the file at f.path for appending. The caller must hold f.mutex.
+func (f *RotatingFile) open() error {
+	file, size, err := openAppend(f.path)
+	if err != nil {
+		return err
+	}
+	f.file, f.size, f.opened = file, size, f.now()
+	return nil
+}
+
+// rotate renames the current file aside, opens a new one and deletes the
+// backups beyond MaxBackups. The current file is only closed once the new one
+// is open, so a failed rotation leaves it in place. The caller must hold
+// f.mutex.
+func (f *RotatingFile) rotate() error {
+	if err := os.Rename(f.path, f.path+"."+f.now().Format(backupTime)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, size, err := openAppend(f.path)
+	if err != nil {
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.size, f.opened = file, size, f.now()
+	return f.prune()
+}
+
+// openAppend opens path for appending, creating it if needed, and returns its
+// current size.
+func openAppend(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// prune deletes the oldest backups beyond MaxBackups. The caller must hold f.mutex.
+func (f *RotatingFile) prune() error {
+	if f.rotation.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.rotation.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files of f.path, oldest first.
+func (f *RotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, f.path+".")
+		if _, err := time.Parse(backupTime, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
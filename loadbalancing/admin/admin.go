@@ -0,0 +1,290 @@
+// Package admin serves a JSON API for inspecting and changing the live state
+// of the pools of a running proxy, so operators can act during incidents
+// without a restart or a configuration reload:
+//
+//	GET    /pools                               every pool and its backends
+//	GET    /pools/{pool}                        one pool
+//	POST   /pools/{pool}/backends               add a backend: {"id", "address", "weight"}
+//	DELETE /pools/{pool}/backends/{id}          remove a backend, draining it
+//	PUT    /pools/{pool}/backends/{id}/weight   change its weight: {"weight"}
+//	POST   /pools/{pool}/backends/{id}/drain    same as DELETE: stop new picks and remove it once idle
+//	POST   /pools/{pool}/backends/{id}/check    run its health check now
+//
+// Changes are applied to the running configuration, as a reload changing it
+// would, so added backends are health checked and later reloads compare
+// against them. They are not written to the configuration file: reloading
+// the file undoes them.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/health"
+)
+
+// Pools is the live state the API works on. *server.Server implements it.
+type Pools interface {
+	// Pools returns the names of the running pools, sorted.
+	Pools() []string
+	// Balancer returns the balancer of the named pool, to read its state.
+	Balancer(pool string) (balancer.Balancer, error)
+	// AddBackend adds a backend to the named pool.
+	AddBackend(pool string, backend balancer.Backend) error
+	// RemoveBackend drains a backend of the named pool and removes it.
+	RemoveBackend(pool, id string) error
+	// SetWeight changes the weight of a backend of the named pool.
+	SetWeight(pool, id string, weight int) error
+	// Health returns the health checking state of the probed backends of the named pool.
+	Health(pool string) (map[string]health.Status, error)
+	// CheckNow probes a backend of the named pool at once.
+	CheckNow(pool, id string) error
+}
+
+// Pool is the JSON view of a pool.
+type Pool struct {
+	Name      string    `json:"name"`
+	Algorithm string    `json:"algorithm"`
+	Backends  []Backend `json:"backends"`
+}
+
+// Backend is the JSON view of a backend and its state.
+type Backend struct {
+	ID          string     `json:"id"`
+	Address     string     `json:"address"`
+	Weight      int        `json:"weight"`
+	Connections int        `json:"connections"`
+	Healthy     bool       `json:"healthy"`
+	Ejected     bool       `json:"ejected"`
+	Draining    bool       `json:"draining"`
+	LastCheck   *time.Time `json:"last_check,omitempty"` // Set for pools with health checks once probed
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// Handler serves the admin API.
+type Handler struct {
+	pools Pools
+	token string
+	mux   *http.ServeMux
+}
+
+// NewHandler creates a Handler for pools.
+//
+// Parameters:
+//   - pools: The running pools, usually a *server.Server
+//   - token: When not empty, every request must send "Authorization: Bearer <token>"
+//
+// Returns:
+//   - *Handler: A pointer to the new Handler instance
+func NewHandler(pools Pools, token string) *Handler {
+	h := &Handler{pools: pools, token: token, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /pools", h.listPools)
+	h.mux.HandleFunc("GET /pools/{pool}", h.getPool)
+	h.mux.HandleFunc("POST /pools/{pool}/backends", h.addBackend)
+	h.mux.HandleFunc("DELETE /pools/{pool}/backends/{id}", h.removeBackend)
+	h.mux.HandleFunc("PUT /pools/{pool}/backends/{id}/weight", h.setWeight)
+	h.mux.HandleFunc("POST /pools/{pool}/backends/{id}/drain", h.removeBackend)
+	h.mux.HandleFunc("POST /pools/{pool}/backends/{id}/check", h.check)
+	return h
+}
+
+// ServeHTTP authenticates the request and routes it.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		want := []byte("Bearer " + h.token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) listPools(w http.ResponseWriter, r *http.Request) {
+	names := h.pools.Pools()
+	pools := make([]Pool, 0, len(names))
+	for _, name := range names {
+		pool, err := h.pool(name)
+		if err != nil {
+			// The pool was removed by a reload since it was listed.
+			continue
+		}
+		pools = append(pools, pool)
+	}
+	writeJSON(w, http.StatusOK, pools)
+}
+
+func (h *Handler) getPool(w http.ResponseWriter, r *http.Request) {
+	pool, err := h.pool(r.PathValue("pool"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pool)
+}
+
+func (h *Handler) addBackend(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID      string `json:"id"`
+		Address string `json:"address"`
+		Weight  int    `json:"weight"`
+	}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Address == "" {
+		writeError(w, http.StatusBadRequest, errors.New("address is required"))
+		return
+	}
+	if req.Weight < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("weight must not be negative"))
+		return
+	}
+	backend := balancer.Backend{ID: req.ID, Address: req.Address, Weight: req.Weight}
+	if backend.ID == "" {
+		backend.ID = backend.Address
+	}
+	if backend.Weight == 0 {
+		backend.Weight = 1
+	}
+
+	h.apply(w, r, http.StatusCreated, func(pool string) error {
+		return h.pools.AddBackend(pool, backend)
+	})
+}
+
+// removeBackend serves both DELETE and drain: the backend stops getting new
+// picks and leaves once its open connections end.
+func (h *Handler) removeBackend(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, http.StatusOK, func(pool string) error {
+		return h.pools.RemoveBackend(pool, r.PathValue("id"))
+	})
+}
+
+func (h *Handler) setWeight(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Weight *int `json:"weight"`
+	}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Weight == nil || *req.Weight < 1 {
+		writeError(w, http.StatusBadRequest, errors.New("weight must be at least 1, drain the backend to stop its traffic"))
+		return
+	}
+
+	h.apply(w, r, http.StatusOK, func(pool string) error {
+		return h.pools.SetWeight(pool, r.PathValue("id"), *req.Weight)
+	})
+}
+
+func (h *Handler) check(w http.ResponseWriter, r *http.Request) {
+	name, id := r.PathValue("pool"), r.PathValue("id")
+	if err := h.pools.CheckNow(name, id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	log.Printf("admin: health check of %s/%s requested by %s", name, id, r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "check scheduled"})
+}
+
+// apply runs change on the pool in the request path, logs it and answers
+// with the state of the pool afterwards.
+func (h *Handler) apply(w http.ResponseWriter, r *http.Request, status int, change func(pool string) error) {
+	name := r.PathValue("pool")
+	if err := change(name); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	log.Printf("admin: %s %s by %s", r.Method, r.URL.Path, r.RemoteAddr)
+
+	pool, err := h.pool(name)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, status, pool)
+}
+
+// pool returns the JSON view of the named pool.
+func (h *Handler) pool(name string) (Pool, error) {
+	b, err := h.pools.Balancer(name)
+	if err != nil {
+		return Pool{}, err
+	}
+	checks, err := h.pools.Health(name)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	snapshot := b.Snapshot()
+	pool := Pool{Name: name, Algorithm: b.Name(), Backends: make([]Backend, 0, len(snapshot))}
+	for _, s := range snapshot {
+		backend := Backend{
+			ID:          s.ID,
+			Address:     s.Address,
+			Weight:      s.Weight,
+			Connections: s.Connections,
+			Healthy:     s.Healthy,
+			Ejected:     s.Ejected,
+			Draining:    s.Draining,
+		}
+		if check, ok := checks[s.ID]; ok && !check.LastCheck.IsZero() {
+			backend.LastCheck = &check.LastCheck
+			if check.LastError != nil {
+				backend.LastError = check.LastError.Error()
+			}
+		}
+		pool.Backends = append(pool.Backends, backend)
+	}
+	return pool, nil
+}
+
+// statusOf maps an error of a balancer or of Pools to an HTTP status.
+func statusOf(err error) int {
+	var (
+		poolNotFound    *lberror.PoolNotFoundError
+		backendNotFound *lberror.BackendNotFoundError
+		duplicate       *lberror.DuplicateBackendError
+		noChecks        *lberror.NoHealthChecksError
+	)
+	switch {
+	case errors.As(err, &poolNotFound), errors.As(err, &backendNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &duplicate), errors.As(err, &noChecks):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// decode reads the JSON body of r into v, rejecting unknown fields.
+func decode(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin: failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
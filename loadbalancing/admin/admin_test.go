@@ -0,0 +1,263 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/health"
+	"sysdesign/loadbalancing/server"
+)
+
+// fakePools serves a single pool without health checks.
+type fakePools struct {
+	balancer balancer.Balancer
+	checked  []string
+}
+
+func (f *fakePools) Pools() []string { return []string{"web"} }
+
+func (f *fakePools) Balancer(pool string) (balancer.Balancer, error) {
+	if pool != "web" {
+		return nil, &lberror.PoolNotFoundError{Name: pool}
+	}
+	return f.balancer, nil
+}
+
+func (f *fakePools) AddBackend(pool string, backend balancer.Backend) error {
+	return f.balancer.AddBackend(backend)
+}
+
+func (f *fakePools) RemoveBackend(pool, id string) error {
+	return f.balancer.Drain(id)
+}
+
+func (f *fakePools) SetWeight(pool, id string, weight int) error {
+	return f.balancer.SetWeight(id, weight)
+}
+
+func (f *fakePools) Health(pool string) (map[string]health.Status, error) {
+	return map[string]health.Status{}, nil
+}
+
+func (f *fakePools) CheckNow(pool, id string) error {
+	f.checked = append(f.checked, id)
+	return nil
+}
+
+func newTestHandler(t *testing.T, token string) (*Handler, *fakePools) {
+	t.Helper()
+	lb, err := algorithm.New("weighted_least_connection", []balancer.Backend{
+		{ID: "a", Address: "127.0.0.1:8081", Weight: 1},
+		{ID: "b", Address: "127.0.0.1:8082", Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pools := &fakePools{balancer: lb}
+	return NewHandler(pools, token), pools
+}
+
+// do sends a request to h and decodes the JSON answer into out when set.
+func do(t *testing.T, h http.Handler, method, path, body string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestListPools(t *testing.T) {
+	h, pools := newTestHandler(t, "")
+	backend, _ := pools.balancer.Pick(&balancer.Request{})
+
+	var got []Pool
+	if code := do(t, h, "GET", "/pools", "", &got); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(got) != 1 || got[0].Algorithm != "weighted_least_connection" || len(got[0].Backends) != 2 {
+		t.Fatalf("unexpected pools: %+v", got)
+	}
+	for _, b := range got[0].Backends {
+		want := 0
+		if b.ID == backend.ID {
+			want = 1
+		}
+		if b.Connections != want || !b.Healthy || b.Weight != 1 {
+			t.Errorf("unexpected state of %s: %+v", b.ID, b)
+		}
+	}
+
+	if code := do(t, h, "GET", "/pools/missing", "", nil); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown pool, got %d", code)
+	}
+}
+
+func TestMutateBackends(t *testing.T) {
+	h, pools := newTestHandler(t, "")
+
+	var pool Pool
+	if code := do(t, h, "POST", "/pools/web/backends", `{"id": "c", "address": "127.0.0.1:8083"}`, &pool); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if len(pool.Backends) != 3 {
+		t.Errorf("expected the backend to be added, got %+v", pool.Backends)
+	}
+	if code := do(t, h, "POST", "/pools/web/backends", `{"id": "c", "address": "127.0.0.1:8083"}`, nil); code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate backend, got %d", code)
+	}
+
+	if code := do(t, h, "PUT", "/pools/web/backends/c/weight", `{"weight": 5}`, nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := do(t, h, "PUT", "/pools/web/backends/c/weight", `{"weight": 0}`, nil); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a zero weight, got %d", code)
+	}
+	if code := do(t, h, "PUT", "/pools/web/backends/x/weight", `{"weight": 2}`, nil); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown backend, got %d", code)
+	}
+
+	// a keeps an open connection while draining.
+	picked, _ := pools.balancer.Pick(&balancer.Request{})
+	if code := do(t, h, "POST", "/pools/web/backends/"+picked.ID+"/drain", "", &pool); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	for _, b := range pool.Backends {
+		if b.ID == picked.ID && !b.Draining {
+			t.Errorf("expected %s to be draining, got %+v", b.ID, b)
+		}
+		if b.ID == "c" && b.Weight != 5 {
+			t.Errorf("expected c to have weight 5, got %+v", b)
+		}
+	}
+
+	if code := do(t, h, "DELETE", "/pools/web/backends/c", "", &pool); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	for _, b := range pool.Backends {
+		if b.ID == "c" {
+			t.Errorf("expected c to be removed, got %+v", pool.Backends)
+		}
+	}
+
+	if code := do(t, h, "POST", "/pools/web/backends/a/check", "", nil); code != http.StatusAccepted || len(pools.checked) != 1 {
+		t.Errorf("expected the check to be scheduled, got %d and %v", code, pools.checked)
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	h, _ := newTestHandler(t, "secret")
+
+	req := httptest.NewRequest("GET", "/pools", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong token, got %d", rec.Code)
+	}
+
+	if code := do(t, h, "GET", "/pools", "", nil); code != http.StatusOK {
+		t.Errorf("expected 200 with the token, got %d", code)
+	}
+}
+
+const serverConfig = `listeners:
+  - name: public
+    address: "127.0.0.1:0"
+    protocol: tcp
+    pool: web
+pools:
+  - name: web
+    algorithm: round_robin
+    health: {kind: tcp, interval: 1h}
+    backends:
+      - {id: a, address: "127.0.0.1:8081"}
+      - {id: b, address: "127.0.0.1:8082"}
+`
+
+func TestChangesFollowReloads(t *testing.T) {
+	cfg, err := config.Parse("lb.yaml", []byte(serverConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := server.New(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for deadline := time.Now().Add(time.Second); s.Addr("public") == nil; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+	}
+	h := NewHandler(s, "")
+
+	if code := do(t, h, "POST", "/pools/web/backends", `{"id": "c", "address": "127.0.0.1:8083"}`, nil); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if code := do(t, h, "DELETE", "/pools/web/backends/b", "", nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if ids := backendIDs(s.Config().Pool("web").Backends); ids != "a,c" {
+		t.Errorf("expected the running configuration to follow, got %s", ids)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, ok := must(s.Health("web"))["c"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected c to be health checked")
+		}
+	}
+
+	// Reloading the file brings b back and drains c.
+	cfg, _ = config.Parse("lb.yaml", []byte(serverConfig))
+	d, err := s.Reload(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.Pools) != 1 || strings.Join(d.Pools[0].AddedBackends, ",") != "b" || strings.Join(d.Pools[0].RemovedBackends, ",") != "c" {
+		t.Errorf("expected the reload to add b and remove c, got %v", d)
+	}
+	var pool Pool
+	do(t, h, "GET", "/pools/web", "", &pool)
+	var ids []string
+	for _, b := range pool.Backends {
+		ids = append(ids, b.ID)
+	}
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("expected a and b after the reload, got %v", ids)
+	}
+}
+
+func backendIDs(backends []config.Backend) string {
+	var ids []string
+	for _, b := range backends {
+		ids = append(ids, b.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+// Package algorithm builds balancer.Balancer implementations by name so that
+// callers can choose the load balancing algorithm from configuration.
+package algorithm
+
+import (
+	"fmt"
+	"sort"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/iphash"
+	leastconnection "sysdesign/loadbalancing/least_connection"
+	"sysdesign/loadbalancing/p2c"
+	peakewma "sysdesign/loadbalancing/peak_ewma"
+	"sysdesign/loadbalancing/roundrobin"
+	weightedleastconnection "sysdesign/loadbalancing/weighted_least_connection"
+	weightedroundrobin "sysdesign/loadbalancing/weighted_round_robin"
+)
+
+// constructors maps every algorithm name to a function returning an empty balancer.
+var constructors = map[string]func() balancer.Balancer{
+	roundrobin.Name: func() balancer.Balancer {
+		return &roundrobin.RoundRobin{}
+	},
+	weightedroundrobin.Name: func() balancer.Balancer {
+		return weightedroundrobin.WeightedRoundRobinBalancer(0)
+	},
+	leastconnection.Name: func() balancer.Balancer {
+		return leastconnection.LeastConnectionLoadBalancer(nil)
+	},
+	weightedleastconnection.Name: func() balancer.Balancer {
+		return weightedleastconnection.WeightedLeastConnectionLoadBalancer(nil)
+	},
+	p2c.Name: func() balancer.Balancer {
+		return p2c.P2CLoadBalancer(nil, nil, nil)
+	},
+	peakewma.Name: func() balancer.Balancer {
+		return peakewma.PeakEWMALoadBalancer(nil, peakewma.DefaultDecayTime)
+	},
+	iphash.Name: func() balancer.Balancer {
+		return iphash.IpHashLoadBalancer(nil)
+	},
+	iphash.ConsistentHashName: func() balancer.Balancer {
+		return iphash.ConsistentHashLoadBalancer(nil, iphash.DefaultReplicas)
+	},
+	iphash.MaglevName: func() balancer.Balancer {
+		return iphash.MaglevLoadBalancer(nil, iphash.DefaultTableSize)
+	},
+	iphash.RendezvousName: func() balancer.Balancer {
+		return iphash.RendezvousLoadBalancer(nil)
+	},
+}
+
+// hashing holds the algorithms that pick backends by Request.HashKey.
+var hashing = map[string]bool{
+	iphash.Name:               true,
+	iphash.ConsistentHashName: true,
+	iphash.MaglevName:         true,
+	iphash.RendezvousName:     true,
+}
+
+// New creates the balancer registered under name and adds the given backends to it.
+//
+// Parameters:
+//   - name: The algorithm name, one of Names()
+//   - backends: The initial backends of the pool
+//
+// Returns:
+//   - balancer.Balancer: The populated balancer
+//   - error: An error if the name is unknown or a backend cannot be added
+func New(name string, backends []balancer.Backend) (balancer.Balancer, error) {
+	constructor, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+
+	b := constructor()
+	for _, backend := range backends {
+		if err := b.AddBackend(backend); err != nil {
+			return nil, fmt.Errorf("failed to add backend to %s: %v", name, err)
+		}
+	}
+	return b, nil
+}
+
+// Names returns the sorted names of every available algorithm.
+func Names() []string {
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hashing reports whether the named algorithm picks backends by
+// Request.HashKey, so requests with the same key go to the same backend.
+func Hashing(name string) bool {
+	return hashing[name]
+}
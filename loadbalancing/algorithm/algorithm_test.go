@@ -0,0 +1,242 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+func testBackends() []balancer.Backend {
+	return []balancer.Backend{
+		{ID: "a", Address: "127.0.0.1:8001", Weight: 1},
+		{ID: "b", Address: "127.0.0.1:8002", Weight: 1},
+		{ID: "c", Address: "127.0.0.1:8003", Weight: 1},
+	}
+}
+
+func TestNewUnknownAlgorithm(t *testing.T) {
+	if _, err := New("does_not_exist", nil); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestEveryAlgorithmImplementsBalancer(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(name, testBackends())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if b.Name() != name {
+				t.Errorf("expected name %s, got %s", name, b.Name())
+			}
+
+			picked, err := b.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if picked.Address == "" {
+				t.Errorf("expected picked backend to carry its address")
+			}
+			if got := connections(b, picked.ID); got != 1 {
+				t.Errorf("expected 1 connection on %s after pick, got %d", picked.ID, got)
+			}
+
+			b.Release(picked)
+			if got := connections(b, picked.ID); got != 0 {
+				t.Errorf("expected 0 connections on %s after release, got %d", picked.ID, got)
+			}
+
+			var duplicate *lberror.DuplicateBackendError
+			if err := b.AddBackend(testBackends()[0]); !errors.As(err, &duplicate) {
+				t.Errorf("expected DuplicateBackendError, got %v", err)
+			}
+
+			if err := b.SetWeight("b", 4); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, status := range b.Snapshot() {
+				if status.ID == "b" && status.Weight != 4 {
+					t.Errorf("expected weight 4 for b, got %d", status.Weight)
+				}
+			}
+
+			for _, id := range []string{"a", "b"} {
+				if err := b.RemoveBackend(id); err != nil {
+					t.Fatalf("unexpected error removing %s: %v", id, err)
+				}
+			}
+			var notFound *lberror.BackendNotFoundError
+			if err := b.RemoveBackend("a"); !errors.As(err, &notFound) {
+				t.Errorf("expected BackendNotFoundError, got %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				picked, err := b.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if picked.ID != "c" {
+					t.Errorf("expected c as the only remaining backend, got %s", picked.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestEveryAlgorithmDrains(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(name, testBackends())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			held, err := b.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := b.Drain(held.ID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, status := range b.Snapshot() {
+				if status.ID == held.ID && (!status.Draining || status.Connections != 1) {
+					t.Errorf("expected %s draining with its connection, got %+v", held.ID, status)
+				}
+			}
+
+			for i := 0; i < 20; i++ {
+				picked, err := b.Pick(&balancer.Request{ClientIP: fmt.Sprintf("10.0.0.%d", i)})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if picked.ID == held.ID {
+					t.Fatalf("expected draining %s to take no new picks", held.ID)
+				}
+				b.Release(picked)
+			}
+
+			b.Release(held)
+			if got := connections(b, held.ID); got != -1 {
+				t.Errorf("expected %s removed after its last release, got %d connections", held.ID, got)
+			}
+
+			// A server without connections is removed at once.
+			idle := b.Snapshot()[0].ID
+			if err := b.Drain(idle); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := len(b.Snapshot()); got != 1 {
+				t.Errorf("expected 1 backend left, got %d", got)
+			}
+
+			var notFound *lberror.BackendNotFoundError
+			if err := b.Drain(idle); !errors.As(err, &notFound) {
+				t.Errorf("expected BackendNotFoundError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEveryAlgorithmUndrains(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(name, testBackends())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			held, err := b.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := b.Drain(held.ID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := b.Undrain(held.ID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, status := range b.Snapshot() {
+				if status.ID == held.ID && (status.Draining || status.Connections != 1) {
+					t.Errorf("expected %s back with its connection, got %+v", held.ID, status)
+				}
+			}
+
+			b.Release(held)
+			if got := connections(b, held.ID); got != 0 {
+				t.Errorf("expected %s kept after its last release, got %d connections", held.ID, got)
+			}
+			for _, other := range []string{"a", "b", "c"} {
+				if other != held.ID {
+					b.RemoveBackend(other)
+				}
+			}
+			picked, err := b.Pick(&balancer.Request{ClientIP: "10.0.0.2"})
+			if err != nil || picked.ID != held.ID {
+				t.Errorf("expected %s picked again, got %v, %v", held.ID, picked, err)
+			}
+
+			var notFound *lberror.BackendNotFoundError
+			if err := b.Undrain("missing"); !errors.As(err, &notFound) {
+				t.Errorf("expected BackendNotFoundError, got %v", err)
+			}
+		})
+	}
+}
+
+func connections(b balancer.Balancer, id string) int {
+	for _, status := range b.Snapshot() {
+		if status.ID == id {
+			return status.Connections
+		}
+	}
+	return -1
+}
+
+func TestEveryAlgorithmExplainsPicks(t *testing.T) {
+	want := map[string]string{
+		"round_robin":               "round robin position 0 of 3",
+		"least_connection":          "least connections=0",
+		"weighted_least_connection": "least connections/weight=0/1",
+		"weighted_round_robin":      "weight 1 of total 3",
+		"ip_hash":                   "hash bucket",
+		"consistent_hash":           "ring hash",
+		"maglev":                    "maglev slot",
+		"rendezvous":                "highest rendezvous score of 3 servers",
+		"p2c":                       "p2c load 0 vs 0",
+		"peak_ewma":                 "lowest peak ewma cost=0s",
+	}
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(name, testBackends())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := &balancer.Request{ClientIP: "10.0.0.1"}
+			if _, err := b.Pick(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.Reason != "" {
+				t.Errorf("expected no reason unless asked, got %q", req.Reason)
+			}
+
+			b, _ = New(name, testBackends())
+			req = &balancer.Request{ClientIP: "10.0.0.1", Explain: true}
+			if _, err := b.Pick(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			prefix, ok := want[name]
+			if !ok {
+				t.Fatalf("no expected reason for %s", name)
+			}
+			if !strings.HasPrefix(req.Reason, prefix) {
+				t.Errorf("expected reason starting with %q, got %q", prefix, req.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Package balancer defines the algorithm-agnostic contract shared by every
+// load balancing package in this module.
+//
+// Each algorithm keeps its own Server type and native API (NextServer,
+// GetNextServer, GetServer, ...), and additionally implements Balancer so that
+// callers such as a proxy can swap algorithms without touching call sites.
+package balancer
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Backend describes a server that can receive traffic, independently of the
+// algorithm that selects it.
+type Backend struct {
+	ID      string // Unique identifier for the backend
+	Address string // Network address (host:port) used to reach the backend
+	Weight  int    // Relative capacity, ignored by unweighted algorithms
+}
+
+// HostPort returns the host:port to connect to. Addresses given as URLs, such
+// as "http://localhost:32768", are reduced to their host.
+func (b *Backend) HostPort() string {
+	if !strings.Contains(b.Address, "://") {
+		return b.Address
+	}
+	u, err := url.Parse(b.Address)
+	if err != nil {
+		return b.Address
+	}
+	return u.Host
+}
+
+// URL returns the base URL of the backend. Addresses without a scheme, such
+// as "127.0.0.1:8080", are treated as plain HTTP.
+func (b *Backend) URL() (*url.URL, error) {
+	address := b.Address
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return url.Parse(address)
+}
+
+// BackendStatus is a point-in-time view of a backend inside a balancer.
+type BackendStatus struct {
+	Backend
+	Connections int  // Picks that have not been released yet
+	Healthy     bool // False while active health checks report the backend down
+	Ejected     bool // True while passive outlier detection keeps the backend out
+	Draining    bool // True while the backend waits for its connections to end before removal
+}
+
+// Request carries the per-request information an algorithm may use to pick a backend.
+type Request struct {
+	ClientIP string // Address of the client that originated the request
+	Key      string // Optional affinity key; hashing algorithms prefer it over ClientIP
+
+	// Explain asks Pick to describe its decision in Reason, e.g.
+	// "least connections=3" or "hash bucket 7 of 10". Leave it unset when
+	// nothing reads Reason, describing a pick costs an allocation.
+	Explain bool
+	Reason  string // Set by Pick when Explain is true
+}
+
+// Explained reports whether Pick should describe its decision in r.Reason.
+func (r *Request) Explained() bool {
+	return r != nil && r.Explain
+}
+
+// HashKey returns the value hashing algorithms should use for the request.
+// It is Key when set and ClientIP otherwise.
+func (r *Request) HashKey() string {
+	if r == nil {
+		return ""
+	}
+	if r.Key != "" {
+		return r.Key
+	}
+	return r.ClientIP
+}
+
+// Balancer is implemented by every load balancing algorithm.
+// Implementations must be safe for concurrent use.
+type Balancer interface {
+	// Name returns the identifier of the algorithm, e.g. "round_robin".
+	Name() string
+
+	// Pick selects a backend for the given request. The returned backend must
+	// be handed back through Release once the work it was picked for is done.
+	// Callers must not modify the returned value, and neither may the
+	// balancer: callers may hold it long after, so changes such as SetWeight
+	// store a modified copy instead.
+	Pick(req *Request) (*Backend, error)
+
+	// Release signals that a request or connection previously routed to the
+	// backend by Pick has finished.
+	Release(backend *Backend)
+
+	// AddBackend registers a new backend. It fails if the ID is already in use.
+	AddBackend(backend Backend) error
+
+	// RemoveBackend removes the backend with the given ID.
+	RemoveBackend(id string) error
+
+	// SetWeight changes the weight of the backend with the given ID.
+	SetWeight(id string, weight int) error
+
+	// SetHealthy marks the backend with the given ID as up or down. Backends
+	// start up; a backend that is down is never returned by Pick.
+	SetHealthy(id string, healthy bool) error
+
+	// SetEjected temporarily takes the backend with the given ID out of, or
+	// back into, rotation because of observed failures. It is tracked
+	// separately from health so either mechanism alone can keep a backend out.
+	SetEjected(id string, ejected bool) error
+
+	// Drain stops new picks of the backend with the given ID and removes it
+	// once every pick made before has been released, at once if there is none.
+	Drain(id string) error
+
+	// Undrain takes back the draining backend with the given ID, so it is
+	// picked again along with the picks it still holds. A backend that is not
+	// draining is left as it is.
+	Undrain(id string) error
+
+	// Snapshot returns the current state of every registered backend.
+	Snapshot() []BackendStatus
+}
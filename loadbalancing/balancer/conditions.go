@@ -0,0 +1,55 @@
+package balancer
+
+// Condition is a reason for a backend to be left out of picks.
+type Condition uint8
+
+const (
+	ConditionDown     Condition = 1 << iota // Failed active health checks
+	ConditionEjected                        // Ejected by passive outlier detection
+	ConditionDraining                       // Removed once its remaining picks are released
+)
+
+// Conditions records the conditions of backends by ID. A backend without any
+// condition is available. The zero value is ready to use; it is not safe for
+// concurrent use, algorithms guard it with their own mutex.
+type Conditions struct {
+	byID map[string]Condition
+}
+
+// Set turns the condition on or off for the backend.
+func (c *Conditions) Set(id string, condition Condition, on bool) {
+	if c.byID == nil {
+		c.byID = make(map[string]Condition)
+	}
+	if on {
+		c.byID[id] |= condition
+	} else {
+		c.byID[id] &^= condition
+	}
+	if c.byID[id] == 0 {
+		delete(c.byID, id)
+	}
+}
+
+// Has reports whether the backend has the condition.
+func (c *Conditions) Has(id string, condition Condition) bool {
+	return c.byID[id]&condition != 0
+}
+
+// Available reports whether the backend has no condition at all.
+func (c *Conditions) Available(id string) bool {
+	return c.byID[id] == 0
+}
+
+// Forget drops every condition of a backend that left the balancer.
+func (c *Conditions) Forget(id string) {
+	delete(c.byID, id)
+}
+
+// Status fills the condition fields of a BackendStatus.
+func (c *Conditions) Status(status BackendStatus) BackendStatus {
+	status.Healthy = !c.Has(status.ID, ConditionDown)
+	status.Ejected = c.Has(status.ID, ConditionEjected)
+	status.Draining = c.Has(status.ID, ConditionDraining)
+	return status
+}
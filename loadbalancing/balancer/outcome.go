@@ -0,0 +1,33 @@
+package balancer
+
+import "time"
+
+// Outcome is the observed result of a request or connection sent to a backend.
+type Outcome struct {
+	Backend *Backend
+	Err     error         // Error reaching the backend, nil if it answered
+	Status  int           // HTTP status code of the response, zero for TCP and UDP
+	Latency time.Duration // Time until the backend answered or the error occurred
+}
+
+// Failed reports whether the outcome counts as a backend failure: the backend
+// could not be reached or answered with a 5xx status.
+func (o Outcome) Failed() bool {
+	return o.Err != nil || o.Status >= 500
+}
+
+// Observer is notified of the outcome of every request or connection a proxy
+// sends to a backend. Implementations must be safe for concurrent use.
+type Observer interface {
+	Observe(outcome Outcome)
+}
+
+// Observers fans an outcome out to several observers.
+type Observers []Observer
+
+// Observe passes the outcome to every observer in order.
+func (o Observers) Observe(outcome Outcome) {
+	for _, observer := range o {
+		observer.Observe(outcome)
+	}
+}
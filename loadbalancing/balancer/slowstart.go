@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Ramp is the shape of the rise of a backend's effective weight during slow start.
+type Ramp uint8
+
+const (
+	RampLinear      Ramp = iota // Rises by the same amount over every part of the window
+	RampExponential             // Rises by the same ratio over every part of the window, staying low for longer
+)
+
+// DefaultMinFactor is the fraction of its weight a backend starts slow start with.
+const DefaultMinFactor = 0.1
+
+// SlowStart configures the ramp up of backends that were just added or came
+// back after being down or ejected: for Window their effective weight rises
+// from MinFactor times their weight to their full weight. The zero value
+// disables slow start.
+type SlowStart struct {
+	Window    time.Duration
+	Ramp      Ramp
+	MinFactor float64          // Fraction of the weight at the start, DefaultMinFactor unless in (0, 1]
+	Now       func() time.Time // Clock, time.Now if nil
+}
+
+// SlowStarter is implemented by algorithms that support slow start.
+type SlowStarter interface {
+	// SetSlowStart replaces the slow start configuration. Backends already
+	// ramping keep their start time and follow the new configuration.
+	SetSlowStart(config SlowStart)
+}
+
+// SlowStarts records when backends began their slow start, keyed by ID. The
+// zero value has slow start disabled; it is not safe for concurrent use,
+// algorithms guard it with their own mutex.
+type SlowStarts struct {
+	config SlowStart
+	since  map[string]time.Time
+}
+
+// Configure replaces the configuration. Disabling slow start ends every ramp.
+func (s *SlowStarts) Configure(config SlowStart) {
+	if config.MinFactor <= 0 || config.MinFactor > 1 {
+		config.MinFactor = DefaultMinFactor
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	s.config = config
+	if config.Window <= 0 {
+		s.since = nil
+	}
+}
+
+// Begin starts the slow start of the backend, or starts it over. It does
+// nothing while slow start is disabled.
+func (s *SlowStarts) Begin(id string) {
+	if s.config.Window <= 0 {
+		return
+	}
+	if s.since == nil {
+		s.since = make(map[string]time.Time)
+	}
+	s.since[id] = s.config.Now()
+}
+
+// Ramping reports whether any backend is in its slow start window.
+func (s *SlowStarts) Ramping() bool {
+	return len(s.since) > 0
+}
+
+// Factor returns the fraction of its weight the backend currently gets, 1
+// once its window is over or if it never began one. Backends whose window
+// is over are forgotten.
+func (s *SlowStarts) Factor(id string) float64 {
+	start, ok := s.since[id]
+	if !ok {
+		return 1
+	}
+	progress := float64(s.config.Now().Sub(start)) / float64(s.config.Window)
+	if progress >= 1 {
+		delete(s.since, id)
+		return 1
+	}
+	if progress < 0 {
+		progress = 0
+	}
+
+	min := s.config.MinFactor
+	if s.config.Ramp == RampExponential {
+		return math.Pow(min, 1-progress)
+	}
+	return min + (1-min)*progress
+}
+
+// Forget drops the slow start of a backend that left the balancer.
+func (s *SlowStarts) Forget(id string) {
+	delete(s.since, id)
+}
+
+// RampByName returns the ramp called name: linear or exponential.
+func RampByName(name string) (Ramp, error) {
+	switch name {
+	case "linear":
+		return RampLinear, nil
+	case "exponential":
+		return RampExponential, nil
+	}
+	return 0, errors.New("unknown slow start ramp " + name)
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStartRamps(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+
+	for _, tc := range []struct {
+		ramp Ramp
+		half float64
+	}{
+		{RampLinear, 0.55},
+		{RampExponential, math.Sqrt(0.1)},
+	} {
+		var s SlowStarts
+		s.Configure(SlowStart{Window: 10 * time.Second, Ramp: tc.ramp, Now: clock})
+		now = time.Unix(0, 0)
+		s.Begin("a")
+
+		if got := s.Factor("a"); math.Abs(got-DefaultMinFactor) > 1e-9 {
+			t.Errorf("ramp %d: expected to start at %v, got %v", tc.ramp, DefaultMinFactor, got)
+		}
+		now = now.Add(5 * time.Second)
+		if got := s.Factor("a"); math.Abs(got-tc.half) > 1e-9 {
+			t.Errorf("ramp %d: expected %v halfway, got %v", tc.ramp, tc.half, got)
+		}
+		if got := s.Factor("b"); got != 1 {
+			t.Errorf("ramp %d: expected a backend without slow start at 1, got %v", tc.ramp, got)
+		}
+
+		now = now.Add(5 * time.Second)
+		if got := s.Factor("a"); got != 1 || s.Ramping() {
+			t.Errorf("ramp %d: expected the ramp to end after the window, got %v", tc.ramp, got)
+		}
+	}
+}
+
+func TestSlowStartDisabled(t *testing.T) {
+	var s SlowStarts
+	s.Begin("a")
+	if s.Ramping() || s.Factor("a") != 1 {
+		t.Error("expected the zero value not to ramp")
+	}
+
+	s.Configure(SlowStart{Window: time.Minute})
+	s.Begin("a")
+	s.Configure(SlowStart{})
+	if s.Ramping() {
+		t.Error("expected disabling slow start to end every ramp")
+	}
+}
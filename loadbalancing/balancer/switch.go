@@ -0,0 +1,142 @@
+package balancer
+
+import "sync"
+
+// Switch is a Balancer that forwards to another one which can be replaced
+// while picks are in flight, for example when a configuration reload swaps
+// the algorithm of a pool. Every pick is released to the balancer that made
+// it, so the replaced balancer still sees its connections end. Outcomes go
+// to the observer installed together with the current balancer.
+type Switch struct {
+	mutex    sync.RWMutex
+	current  Balancer
+	observer Observer
+
+	picks  sync.Mutex
+	owners map[*Backend]*owner // Balancer of every backend with unreleased picks
+}
+
+// owner is the balancer that handed out a backend and how many of its picks
+// are not released yet.
+type owner struct {
+	balancer Balancer
+	picks    int
+}
+
+var (
+	_ Balancer = (*Switch)(nil)
+	_ Observer = (*Switch)(nil)
+)
+
+// NewSwitch returns a Switch forwarding to b, with outcomes going to o, which may be nil.
+func NewSwitch(b Balancer, o Observer) *Switch {
+	return &Switch{current: b, observer: o, owners: make(map[*Backend]*owner)}
+}
+
+// Swap makes b receive every later call, with outcomes going to o, which may
+// be nil. Picks made before are still released to the previous balancer.
+func (s *Switch) Swap(b Balancer, o Observer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.current, s.observer = b, o
+}
+
+// Current returns the balancer calls are forwarded to.
+func (s *Switch) Current() Balancer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+// Name returns the name of the current balancer.
+func (s *Switch) Name() string {
+	return s.Current().Name()
+}
+
+// Pick picks from the current balancer and remembers it as the owner of the backend.
+func (s *Switch) Pick(req *Request) (*Backend, error) {
+	b := s.Current()
+	backend, err := b.Pick(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.picks.Lock()
+	defer s.picks.Unlock()
+	o, ok := s.owners[backend]
+	if !ok {
+		o = &owner{balancer: b}
+		s.owners[backend] = o
+	}
+	o.picks++
+	return backend, nil
+}
+
+// Release hands the backend back to the balancer that picked it.
+func (s *Switch) Release(backend *Backend) {
+	s.picks.Lock()
+	o, ok := s.owners[backend]
+	if ok {
+		o.picks--
+		if o.picks == 0 {
+			delete(s.owners, backend)
+		}
+	}
+	s.picks.Unlock()
+
+	if ok {
+		o.balancer.Release(backend)
+	} else {
+		s.Current().Release(backend)
+	}
+}
+
+// Observe passes the outcome to the observer of the current balancer.
+func (s *Switch) Observe(outcome Outcome) {
+	s.mutex.RLock()
+	o := s.observer
+	s.mutex.RUnlock()
+	if o != nil {
+		o.Observe(outcome)
+	}
+}
+
+// AddBackend adds the backend to the current balancer.
+func (s *Switch) AddBackend(backend Backend) error {
+	return s.Current().AddBackend(backend)
+}
+
+// RemoveBackend removes the backend from the current balancer.
+func (s *Switch) RemoveBackend(id string) error {
+	return s.Current().RemoveBackend(id)
+}
+
+// SetWeight changes the weight of the backend in the current balancer.
+func (s *Switch) SetWeight(id string, weight int) error {
+	return s.Current().SetWeight(id, weight)
+}
+
+// SetHealthy marks the backend up or down in the current balancer.
+func (s *Switch) SetHealthy(id string, healthy bool) error {
+	return s.Current().SetHealthy(id, healthy)
+}
+
+// SetEjected ejects or readmits the backend in the current balancer.
+func (s *Switch) SetEjected(id string, ejected bool) error {
+	return s.Current().SetEjected(id, ejected)
+}
+
+// Drain drains the backend in the current balancer.
+func (s *Switch) Drain(id string) error {
+	return s.Current().Drain(id)
+}
+
+// Undrain takes the backend back in the current balancer.
+func (s *Switch) Undrain(id string) error {
+	return s.Current().Undrain(id)
+}
+
+// Snapshot returns the backends of the current balancer.
+func (s *Switch) Snapshot() []BackendStatus {
+	return s.Current().Snapshot()
+}
@@ -0,0 +1,63 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"sysdesign/loadbalancing/balancer"
+	leastconnection "sysdesign/loadbalancing/least_connection"
+	"sysdesign/loadbalancing/roundrobin"
+)
+
+func TestSwitchReleasesToPickingBalancer(t *testing.T) {
+	old := leastconnection.LeastConnectionLoadBalancer(nil)
+	old.AddBackend(balancer.Backend{ID: "a", Address: "a:80"})
+	s := balancer.NewSwitch(old, nil)
+
+	held, err := s.Pick(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	next := &roundrobin.RoundRobin{}
+	next.AddBackend(balancer.Backend{ID: "a", Address: "a:80"})
+	s.Swap(next, nil)
+	if s.Name() != roundrobin.Name {
+		t.Errorf("expected the new balancer to be current, got %s", s.Name())
+	}
+
+	picked, _ := s.Pick(nil)
+	if got := next.Snapshot()[0].Connections; got != 1 {
+		t.Errorf("expected the pick after the swap on the new balancer, got %d connections", got)
+	}
+
+	s.Release(held)
+	s.Release(picked)
+	if got := old.Snapshot()[0].Connections; got != 0 {
+		t.Errorf("expected the old pick released to the old balancer, got %d connections", got)
+	}
+	if got := next.Snapshot()[0].Connections; got != 0 {
+		t.Errorf("expected the new pick released to the new balancer, got %d connections", got)
+	}
+}
+
+type countingObserver struct {
+	outcomes int
+}
+
+func (o *countingObserver) Observe(balancer.Outcome) {
+	o.outcomes++
+}
+
+func TestSwitchObserver(t *testing.T) {
+	first, second := &countingObserver{}, &countingObserver{}
+	s := balancer.NewSwitch(&roundrobin.RoundRobin{}, first)
+	s.Observe(balancer.Outcome{})
+	s.Swap(&roundrobin.RoundRobin{}, second)
+	s.Observe(balancer.Outcome{})
+	s.Swap(&roundrobin.RoundRobin{}, nil)
+	s.Observe(balancer.Outcome{})
+
+	if first.outcomes != 1 || second.outcomes != 1 {
+		t.Errorf("expected one outcome each, got %d and %d", first.outcomes, second.outcomes)
+	}
+}
@@ -0,0 +1,118 @@
+// Command fakebackend starts fake backends on loopback to try the proxy
+// without Docker; see the fakebackend package. It prints the -backend flags
+// of the proxy for them and then takes commands on standard input:
+//
+//	crash b1      stop listening and drop open connections
+//	hang b2       accept connections but answer nothing
+//	recover b1    end a crash or hang
+//	stats         print the requests every backend got
+//
+// For example three backends answering within 5 to 20ms, 1% of them with
+// an error and at most 10 at once:
+//
+//	fakebackend -n 3 -latency 5ms-20ms -error-rate 0.01 -capacity 10
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sysdesign/loadbalancing/fakebackend"
+)
+
+func main() {
+	n := flag.Int("n", 3, "number of backends")
+	protocol := flag.String("protocol", fakebackend.HTTP, "protocol of the backends: http or tcp")
+	latency := flag.String("latency", "0s", "time to answer, fixed as 10ms or uniform as 5ms-20ms")
+	errorRate := flag.Float64("error-rate", 0, "share of requests failed on purpose")
+	capacity := flag.Int("capacity", 0, "requests every backend serves at once, 0 for no limit")
+	seed := flag.Uint64("seed", 1, "seed of the latency and error draws")
+	flag.Parse()
+
+	l, err := parseLatency(*latency)
+	if err != nil {
+		log.Fatalf("Invalid -latency %q: %v", *latency, err)
+	}
+	group, err := fakebackend.StartGroup(*n, fakebackend.Config{
+		Protocol: *protocol,
+		Profile:  fakebackend.Profile{Latency: l, ErrorRate: *errorRate, Capacity: *capacity},
+		Seed:     *seed,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start the backends: %v", err)
+	}
+	defer group.Close()
+
+	var flags []string
+	for _, b := range group.Backends() {
+		flags = append(flags, fmt.Sprintf("-backend %s=%s", b.ID, b.Address))
+	}
+	fmt.Printf("Started %d %s backends, proxy flags:\n%s\n", *n, *protocol, strings.Join(flags, " "))
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := run(group, fields); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// run executes one command read from standard input.
+func run(group fakebackend.Group, fields []string) error {
+	if fields[0] == "stats" {
+		for _, s := range group {
+			stats := s.Stats()
+			fmt.Printf("%s: %d requests, %d rejected, %d failed, %d active\n", s.ID(), stats.Requests, stats.Rejected, stats.Failed, stats.Active)
+		}
+		return nil
+	}
+	if len(fields) != 2 {
+		return errors.New("expected crash, hang or recover followed by a backend ID, or stats")
+	}
+	s := group.Server(fields[1])
+	if s == nil {
+		return fmt.Errorf("no backend %q", fields[1])
+	}
+	switch fields[0] {
+	case "crash":
+		s.Crash()
+	case "hang":
+		s.Hang()
+	case "recover":
+		return s.Recover()
+	default:
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return nil
+}
+
+// parseLatency parses a fixed latency such as 10ms or a uniform range such
+// as 5ms-20ms.
+func parseLatency(spec string) (fakebackend.Latency, error) {
+	if from, to, ok := strings.Cut(spec, "-"); ok {
+		lo, err := time.ParseDuration(from)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := time.ParseDuration(to)
+		if err != nil {
+			return nil, err
+		}
+		return fakebackend.Uniform{Min: lo, Max: hi}, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, err
+	}
+	return fakebackend.Constant(d), nil
+}
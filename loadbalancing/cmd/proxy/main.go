@@ -0,0 +1,380 @@
+// Command proxy runs a reverse proxy in front of a pool of backends, routing
+// every HTTP request, TCP connection or UDP flow through the selected load
+// balancing algorithm.
+//
+// Backends are given with repeated -backend flags in the form
+// [id=]address[@weight], for example:
+//
+//	proxy -algorithm weighted_round_robin -backend a=127.0.0.1:8081@3 -backend b=127.0.0.1:8082
+//
+// Alternatively -nginx N launches N nginx containers through the container
+// package and balances across them until the proxy is stopped. With -health
+// the backends are probed and taken out of rotation while they are down; it
+// defaults to an HTTP check when nginx containers are launched, so stopped
+// containers drop out automatically. With -outlier the outcome of proxied
+// traffic is watched as well and failing backends are ejected for a while.
+//
+// With -config the proxy is described by a YAML or JSON file instead, which
+// can declare several listeners and pools; see the config package:
+//
+//	proxy -config lb.yaml
+//
+// The file is reloaded on SIGHUP and whenever its content changes, without
+// dropping open connections; see the server package. An invalid file is
+// reported and the running configuration is kept.
+//
+// With -admin-listen a JSON API inspects and changes the live pools, for
+// example to drain a backend during an incident; see the admin package:
+//
+//	curl -X POST -H "Authorization: Bearer $PROXY_ADMIN_TOKEN" localhost:9901/pools/web/backends/a/drain
+//
+// With -metrics-listen Prometheus metrics of every pool and listener are
+// served on /metrics; see the metrics package. Every request is traced with
+// OpenTelemetry, from backend selection to the upstream call, and the trace
+// context is passed on to the backends; see the tracing package. Spans are
+// appended to traces.jsonl in the OTLP JSON format by default, so tracing
+// works without a collector; -trace-exporter none turns it off.
+//
+// With -access-log every HTTP request is logged with the backend that served
+// it, the algorithm and why it picked that backend, e.g. "least
+// connections=3"; see the accesslog package. The file rotates by size and age:
+//
+//	proxy -config lb.yaml -access-log access.log -access-log-max-size 50
+//
+// With -record-trace every HTTP request is recorded to a compact trace file,
+// which the replay command sends again through any algorithm; see the replay
+// package.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+	"sysdesign/loadbalancing/admin"
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	"sysdesign/loadbalancing/container"
+	"sysdesign/loadbalancing/metrics"
+	"sysdesign/loadbalancing/outlier"
+	"sysdesign/loadbalancing/replay"
+	"sysdesign/loadbalancing/server"
+	"sysdesign/loadbalancing/tracing"
+)
+
+// backendFlags collects repeated -backend values.
+type backendFlags []string
+
+func (f *backendFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *backendFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var backendSpecs backendFlags
+	configPath := flag.String("config", "", "YAML or JSON configuration file; when set, the listener, backend, algorithm and check flags are ignored")
+	listen := flag.String("listen", ":8080", "address the proxy listens on")
+	algo := flag.String("algorithm", "round_robin", "balancing algorithm, one of: "+strings.Join(algorithm.Names(), ", "))
+	nginx := flag.Int("nginx", 0, "number of nginx containers to launch as backends")
+	mode := flag.String("mode", "http", "proxy mode, http, tcp or udp")
+	trustForwarded := flag.Bool("trust-forwarded-for", false, "take the client IP from X-Forwarded-For")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close connections, udp flows or http keep-alive connections idle for this long, 0 uses the mode default")
+	healthKind := flag.String("health", "", "active health check, one of: tcp, http, grpc (default http with -nginx, none otherwise)")
+	healthTarget := flag.String("health-target", "/", "path probed by http checks or service probed by grpc checks")
+	healthInterval := flag.Duration("health-interval", 5*time.Second, "time between health checks of a backend")
+	outlierDetection := flag.Bool("outlier", false, "eject backends whose proxied requests or connections keep failing")
+	outlierErrors := flag.Int("outlier-consecutive-errors", outlier.DefaultConfig().ConsecutiveErrors, "consecutive failures that eject a backend")
+	outlierEjection := flag.Duration("outlier-ejection-time", outlier.DefaultConfig().BaseEjectionTime, "length of the first ejection of a backend")
+	prefixV4 := flag.Int("hash-prefix-v4", 0, "hash IPv4 clients by this prefix length with hashing algorithms, e.g. 24")
+	prefixV6 := flag.Int("hash-prefix-v6", 0, "hash IPv6 clients by this prefix length with hashing algorithms, e.g. 64")
+	p2cMetric := flag.String("p2c-metric", "", "load compared by the p2c algorithm: connections, outstanding or latency")
+	slowStart := flag.Duration("slow-start", 0, "ramp up backends that join or recover over this window, with weighted and least connection algorithms")
+	slowStartRamp := flag.String("slow-start-ramp", "linear", "shape of the slow start ramp: linear or exponential")
+	reloadInterval := flag.Duration("reload-interval", 2*time.Second, "check the -config file for changes this often and reload it, 0 only reloads on SIGHUP")
+	adminListen := flag.String("admin-listen", "", "address of the admin API, disabled when empty")
+	adminToken := flag.String("admin-token", os.Getenv("PROXY_ADMIN_TOKEN"), "bearer token required by the admin API, defaults to $PROXY_ADMIN_TOKEN")
+	metricsListen := flag.String("metrics-listen", "", "address serving Prometheus metrics on /metrics, disabled when empty")
+	traceExporter := flag.String("trace-exporter", tracing.ExporterOTLPFile, "where OpenTelemetry spans go: none, stdout or otlp-file")
+	traceFile := flag.String("trace-file", tracing.DefaultPath, "file the otlp-file trace exporter appends to")
+	traceSample := flag.Float64("trace-sample", 1, "share of new traces recorded, traces started by clients follow their sampling decision")
+	accessLogPath := flag.String("access-log", "", "file every http request is logged to, - for stdout, disabled when empty")
+	accessLogFormat := flag.String("access-log-format", accesslog.FormatJSON, "access log line format: json, text or a Go template over accesslog.Entry")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 100, "rotate the access log file before it grows beyond this many megabytes, 0 never")
+	accessLogMaxAge := flag.Duration("access-log-max-age", 24*time.Hour, "rotate the access log file once it is this old, 0 never")
+	accessLogBackups := flag.Int("access-log-backups", 7, "rotated access log files kept, 0 keeps all")
+	recordTrace := flag.String("record-trace", "", "file every http request is recorded to for replay, disabled when empty")
+	flag.Var(&backendSpecs, "backend", "backend as [id=]address[@weight], may be repeated")
+	flag.Parse()
+
+	var cfg *config.Config
+	if *configPath != "" {
+		loaded, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		cfg = loaded
+	} else {
+		pool := config.Pool{Name: "default", Algorithm: *algo, P2CMetric: *p2cMetric}
+		for _, spec := range backendSpecs {
+			backend, err := parseBackend(spec)
+			if err != nil {
+				log.Fatalf("Invalid backend %q: %v", spec, err)
+			}
+			pool.Backends = append(pool.Backends, config.Backend{ID: backend.ID, Address: backend.Address, Weight: backend.Weight})
+		}
+
+		if *nginx > 0 {
+			containers, cleanup, err := launchNginx(*nginx)
+			defer cleanup()
+			if err != nil {
+				log.Printf("Failed to launch nginx containers: %v", err)
+				return
+			}
+			for _, backend := range containers {
+				pool.Backends = append(pool.Backends, config.Backend{ID: backend.ID, Address: backend.Address, Weight: backend.Weight})
+			}
+		}
+
+		if len(pool.Backends) == 0 {
+			log.Fatal("No backends configured, use -config, -backend or -nginx")
+		}
+
+		if *healthKind == "" && *nginx > 0 {
+			*healthKind = "http"
+		}
+		if *healthKind != "" && *healthKind != "none" {
+			pool.Health = &config.HealthCheck{Kind: *healthKind, Target: *healthTarget, Interval: *healthInterval}
+		}
+		if *outlierDetection {
+			pool.Outlier = &config.Outlier{ConsecutiveErrors: *outlierErrors, EjectionTime: *outlierEjection}
+		}
+		if *slowStart > 0 {
+			pool.SlowStart = &config.SlowStart{Window: *slowStart, Ramp: *slowStartRamp}
+		}
+		if *prefixV4 > 0 || *prefixV6 > 0 {
+			pool.Hash = &config.HashSettings{PrefixV4: *prefixV4, PrefixV6: *prefixV6}
+		}
+
+		cfg = &config.Config{
+			Listeners: []config.Listener{{
+				Address:           *listen,
+				Protocol:          *mode,
+				Pool:              pool.Name,
+				TrustForwardedFor: *trustForwarded,
+				Timeouts:          config.Timeouts{Idle: *idleTimeout},
+			}},
+			Pools: []config.Pool{pool},
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Printf("Invalid flags:\n%v", err)
+			return
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    *traceExporter,
+		Path:        *traceFile,
+		ServiceName: "lbproxy",
+		SampleRatio: *traceSample,
+	})
+	if err != nil {
+		log.Printf("Failed to set up tracing: %v", err)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	srv := server.New(cfg)
+	if *configPath != "" {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+
+		var changes <-chan struct{}
+		if *reloadInterval > 0 {
+			changes = config.Watch(ctx, *configPath, *reloadInterval)
+		}
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hangups:
+				case <-changes:
+				}
+				reload(srv, *configPath)
+			}
+		}()
+	}
+
+	if *adminListen != "" {
+		go serveAPI(ctx, "admin API", *adminListen, admin.NewHandler(srv, *adminToken))
+	}
+	if *accessLogPath != "" {
+		accessLog, err := openAccessLog(*accessLogPath, *accessLogFormat, accesslog.Rotation{
+			MaxSize:    *accessLogMaxSize << 20,
+			MaxAge:     *accessLogMaxAge,
+			MaxBackups: *accessLogBackups,
+		})
+		if err != nil {
+			log.Printf("Failed to open the access log: %v", err)
+			return
+		}
+		defer accessLog.Close()
+		srv.AccessLog = accessLog
+	}
+	if *recordTrace != "" {
+		trace, err := replay.Create(*recordTrace)
+		if err != nil {
+			log.Printf("Failed to create the trace file: %v", err)
+			return
+		}
+		defer trace.Close()
+		srv.AccessLog = accesslog.Tee(srv.AccessLog, trace)
+	}
+	if *metricsListen != "" {
+		registry := metrics.NewRegistry()
+		srv.Metrics = registry
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", registry.Handler(srv))
+		go serveAPI(ctx, "metrics", *metricsListen, mux)
+	}
+
+	if err := srv.Run(ctx); err != nil {
+		log.Printf("Proxy stopped: %v", err)
+	}
+}
+
+// serveAPI serves the named API on addr until ctx is cancelled.
+func serveAPI(ctx context.Context, name, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Serving the %s on %s\n", name, addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("The %s stopped: %v", name, err)
+	}
+}
+
+// openAccessLog creates the access log writing to path, or to stdout if path is "-".
+func openAccessLog(path, format string, rotation accesslog.Rotation) (*accesslog.Logger, error) {
+	if path == "-" {
+		return accesslog.New(os.Stdout, format)
+	}
+	file, err := accesslog.NewRotatingFile(path, rotation)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := accesslog.New(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return logger, nil
+}
+
+// reload loads the configuration file again and applies it to srv. An
+// invalid file is reported and the running configuration is kept.
+func reload(srv *server.Server, path string) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Printf("Keeping the running configuration, %s is invalid:\n%v", path, err)
+		return
+	}
+	d, err := srv.Reload(cfg)
+	if err != nil {
+		log.Printf("Failed to reload %s: %v", path, err)
+		return
+	}
+	if d.Empty() {
+		return
+	}
+	log.Printf("Reloaded %s:\n%v", path, d)
+}
+
+// parseBackend parses a backend given as [id=]address[@weight].
+// The ID defaults to the address and the weight to 1.
+func parseBackend(spec string) (balancer.Backend, error) {
+	backend := balancer.Backend{Weight: 1}
+
+	if id, rest, ok := strings.Cut(spec, "="); ok {
+		backend.ID = id
+		spec = rest
+	}
+
+	if address, weight, ok := strings.Cut(spec, "@"); ok {
+		w, err := strconv.Atoi(weight)
+		if err != nil {
+			return backend, fmt.Errorf("invalid weight: %v", err)
+		}
+		backend.Weight = w
+		spec = address
+	}
+
+	if spec == "" {
+		return backend, errors.New("missing address")
+	}
+	backend.Address = spec
+	if backend.ID == "" {
+		backend.ID = spec
+	}
+	return backend, nil
+}
+
+// launchNginx starts count nginx containers and returns them as backends.
+// The returned cleanup function removes every container that was started,
+// even when launching failed part way.
+func launchNginx(count int) ([]balancer.Backend, func(), error) {
+	var ids []string
+	cleanup := func() {}
+
+	ncm, err := container.NewNgixContainerManager()
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	cleanup = func() {
+		for _, id := range ids {
+			if err := ncm.RemoveContainer(id); err != nil {
+				log.Printf("Failed to remove container %s: %v", id, err)
+			}
+		}
+	}
+
+	backends := make([]balancer.Backend, 0, count)
+	for i := 0; i < count; i++ {
+		info, err := ncm.CreateContainer()
+		if err != nil {
+			return nil, cleanup, err
+		}
+		ids = append(ids, info.ID)
+		backends = append(backends, balancer.Backend{ID: info.ID[:12], Address: info.URL, Weight: 1})
+	}
+	return backends, cleanup, nil
+}
@@ -0,0 +1,164 @@
+// Command replay sends traffic recorded with proxy -record-trace again
+// through one or more load balancing algorithms and compares how they
+// routed it: errors, latencies, how sticky each kept its clients and how
+// many requests went elsewhere than with the first algorithm; see the
+// replay package.
+//
+// By default the trace is replayed in real time against in-process fake
+// backends that answer every request after its recorded duration, with its
+// recorded status and size. -speed replays faster, -backend sends the
+// requests to real backends instead and -target to a single address such as
+// a running proxy:
+//
+//	replay -trace traffic.lbt -algorithms ip_hash,consistent_hash,least_connection -speed 10
+//
+// With -simulate the trace is replayed in virtual time by the simulator
+// instead, on -fake servers of -workers workers each, which takes seconds
+// and gives the same result every time.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/iphash"
+	leastconnection "sysdesign/loadbalancing/least_connection"
+	"sysdesign/loadbalancing/replay"
+	"sysdesign/loadbalancing/simulator"
+)
+
+// backendFlags collects repeated -backend values.
+type backendFlags []string
+
+func (f *backendFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *backendFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var backendSpecs backendFlags
+	tracePath := flag.String("trace", "", "trace file recorded with proxy -record-trace")
+	algorithms := flag.String("algorithms", strings.Join([]string{iphash.Name, iphash.ConsistentHashName, leastconnection.Name}, ","), "comma separated algorithms to compare, of: "+strings.Join(algorithm.Names(), ", "))
+	speed := flag.Float64("speed", 1, "replay this many times faster than recorded")
+	fake := flag.Int("fake", 4, "in-process fake backends, or simulated servers with -simulate, used without -backend")
+	target := flag.String("target", "", "send every request to this URL instead of balancing, e.g. a proxy run with -trust-forwarded-for")
+	simulate := flag.Bool("simulate", false, "replay in virtual time with the simulator instead of sending requests")
+	workers := flag.Int("workers", 8, "requests a simulated server serves at once")
+	flag.Var(&backendSpecs, "backend", "real backend as [id=]address[@weight], may be repeated")
+	flag.Parse()
+
+	if *tracePath == "" {
+		log.Fatal("No trace given, use -trace")
+	}
+	records, err := replay.ReadFile(*tracePath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *tracePath, err)
+	}
+	if len(records) == 0 {
+		log.Fatalf("%s holds no requests", *tracePath)
+	}
+	names := strings.Split(*algorithms, ",")
+
+	if *simulate {
+		servers := make([]simulator.Server, *fake)
+		for i := range servers {
+			servers[i] = simulator.Server{ID: fmt.Sprintf("b%d", i+1), Workers: *workers}
+		}
+		reports, err := simulator.Compare(simulator.Config{Servers: servers, Workload: replay.Workload(records)}, names...)
+		if err != nil {
+			log.Fatalf("Simulation failed: %v", err)
+		}
+		if err := simulator.WriteTable(os.Stdout, reports); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *target != "" {
+		result, err := (&replay.Replayer{Target: *target, Speed: *speed}).Replay(ctx, records)
+		if err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		if err := replay.WriteTable(os.Stdout, []*replay.Result{result}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var backends []balancer.Backend
+	for _, spec := range backendSpecs {
+		backend, err := parseBackend(spec)
+		if err != nil {
+			log.Fatalf("Invalid backend %q: %v", spec, err)
+		}
+		backends = append(backends, backend)
+	}
+	if len(backends) == 0 {
+		fakes, err := replay.StartFakeBackends(*fake)
+		if err != nil {
+			log.Fatalf("Failed to start fake backends: %v", err)
+		}
+		defer fakes.Close()
+		backends = fakes.Backends()
+	}
+
+	var results []*replay.Result
+	for _, name := range names {
+		b, err := algorithm.New(name, backends)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Replaying %d requests through %s\n", len(records), name)
+		result, err := (&replay.Replayer{Balancer: b, Speed: *speed}).Replay(ctx, records)
+		if err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		results = append(results, result)
+	}
+	fmt.Println()
+	if err := replay.WriteTable(os.Stdout, results); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseBackend parses a backend given as [id=]address[@weight].
+func parseBackend(spec string) (balancer.Backend, error) {
+	backend := balancer.Backend{Weight: 1}
+	if id, rest, ok := strings.Cut(spec, "="); ok {
+		backend.ID = id
+		spec = rest
+	}
+	if address, weight, ok := strings.Cut(spec, "@"); ok {
+		w, err := strconv.Atoi(weight)
+		if err != nil {
+			return backend, fmt.Errorf("invalid weight: %v", err)
+		}
+		backend.Weight = w
+		spec = address
+	}
+	if spec == "" {
+		return backend, errors.New("missing address")
+	}
+	backend.Address = spec
+	if backend.ID == "" {
+		backend.ID = spec
+	}
+	return backend, nil
+}
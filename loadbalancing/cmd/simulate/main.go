@@ -0,0 +1,168 @@
+// Command simulate compares load balancing algorithms on a synthetic
+// workload and prints their queueing delays, drop rates, fairness and the
+// utilization of every server; see the simulator package.
+//
+// Servers are given with repeated -server flags in the form
+// [id=]speed[xworkers], for example four workers at twice the base speed:
+//
+//	simulate -server a=1x4 -server b=1x4 -server c=2x4 -server d=4x4 -rate 2400
+//
+// Without -server the four servers above are simulated.
+//
+// Besides the table printed, -html writes a self-contained page with SVG
+// charts of the latency distributions and of the load and connections of
+// every server over time, and -json, -series-csv and -latency-csv export the
+// underlying series:
+//
+//	simulate -html report.html -series-csv series.csv
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/simulator"
+)
+
+// serverFlags collects repeated -server values.
+type serverFlags []string
+
+func (f *serverFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *serverFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var serverSpecs serverFlags
+	algorithms := flag.String("algorithms", strings.Join(simulator.Algorithms, ","), "comma separated algorithms to compare, of: "+strings.Join(algorithm.Names(), ", "))
+	requests := flag.Int("requests", 200000, "number of requests to simulate")
+	arrivals := flag.String("arrivals", "poisson", "arrival process: poisson or bursty")
+	rate := flag.Float64("rate", 2400, "requests per second, outside bursts with -arrivals bursty")
+	burstRate := flag.Float64("burst-rate", 4000, "requests per second during bursts")
+	quiet := flag.Duration("quiet", 2*time.Second, "mean length of the quiet periods between bursts")
+	burst := flag.Duration("burst", 500*time.Millisecond, "mean length of a burst")
+	service := flag.String("service", "pareto", "service time distribution: exponential or pareto")
+	mean := flag.Duration("mean", 10*time.Millisecond, "mean service time on a server of speed 1")
+	alpha := flag.Float64("alpha", 1.5, "tail index of pareto service times, heavier below 2")
+	clients := flag.Int("clients", 1000, "distinct client IPs")
+	skew := flag.Float64("client-skew", 0, "zipf exponent of client popularity, above 1; 0 for equally active clients")
+	queue := flag.Int("queue", 50, "requests a server queues before dropping new ones, 0 for no limit")
+	seed := flag.Uint64("seed", 1, "seed of the workload")
+	htmlPath := flag.String("html", "", "write an HTML report with SVG charts to this file")
+	jsonPath := flag.String("json", "", "write the reports, series included, as JSON to this file")
+	seriesPath := flag.String("series-csv", "", "write the load and connections of every server over time as CSV to this file")
+	latencyPath := flag.String("latency-csv", "", "write the latency distributions as CSV to this file")
+	flag.Var(&serverSpecs, "server", "server as [id=]speed[xworkers], may be repeated")
+	flag.Parse()
+
+	if len(serverSpecs) == 0 {
+		serverSpecs = serverFlags{"a=1x4", "b=1x4", "c=2x4", "d=4x4"}
+	}
+	var servers []simulator.Server
+	for i, spec := range serverSpecs {
+		s, err := parseServer(spec)
+		if err != nil {
+			log.Fatalf("Invalid -server %q: %v", spec, err)
+		}
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("s%d", i+1)
+		}
+		s.QueueLimit = *queue
+		servers = append(servers, s)
+	}
+
+	workload := simulator.Workload{Requests: *requests, Clients: *clients, ClientSkew: *skew}
+	switch *arrivals {
+	case "poisson":
+		workload.Arrivals = simulator.Poisson{Rate: *rate}
+	case "bursty":
+		workload.Arrivals = simulator.Bursty{Rate: *rate, BurstRate: *burstRate, Quiet: *quiet, Burst: *burst}
+	default:
+		log.Fatalf("Unknown arrival process %q, expected poisson or bursty", *arrivals)
+	}
+	switch *service {
+	case "exponential":
+		workload.Service = simulator.Exponential{Mean: *mean}
+	case "pareto":
+		workload.Service = simulator.Pareto{Mean: *mean, Alpha: *alpha}
+	default:
+		log.Fatalf("Unknown service time distribution %q, expected exponential or pareto", *service)
+	}
+
+	reports, err := simulator.Compare(simulator.Config{Servers: servers, Workload: workload, Seed: *seed}, strings.Split(*algorithms, ",")...)
+	if err != nil {
+		log.Fatalf("Simulation failed: %v", err)
+	}
+	if err := simulator.WriteTable(os.Stdout, reports); err != nil {
+		log.Fatal(err)
+	}
+
+	title := fmt.Sprintf("%d %s arrivals at %g/s, %s service times averaging %v, %d servers",
+		*requests, *arrivals, *rate, *service, *mean, len(servers))
+	outputs := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{*htmlPath, func(w io.Writer) error { return simulator.WriteHTML(w, title, reports) }},
+		{*jsonPath, func(w io.Writer) error { return simulator.WriteJSON(w, reports) }},
+		{*seriesPath, func(w io.Writer) error { return simulator.WriteSeriesCSV(w, reports) }},
+		{*latencyPath, func(w io.Writer) error { return simulator.WriteLatencyCSV(w, reports) }},
+	}
+	for _, output := range outputs {
+		if output.path == "" {
+			continue
+		}
+		if err := writeFile(output.path, output.write); err != nil {
+			log.Fatalf("Failed to write %s: %v", output.path, err)
+		}
+		fmt.Printf("Wrote %s\n", output.path)
+	}
+}
+
+// writeFile creates path and fills it through write.
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// parseServer parses a server given as [id=]speed[xworkers].
+func parseServer(spec string) (simulator.Server, error) {
+	server := simulator.Server{Workers: 1}
+	if id, rest, ok := strings.Cut(spec, "="); ok {
+		server.ID = id
+		spec = rest
+	}
+	if speed, workers, ok := strings.Cut(spec, "x"); ok {
+		n, err := strconv.Atoi(workers)
+		if err != nil || n < 1 {
+			return server, errors.New("workers must be a positive integer")
+		}
+		server.Workers = n
+		spec = speed
+	}
+	speed, err := strconv.ParseFloat(spec, 64)
+	if err != nil || speed <= 0 {
+		return server, errors.New("speed must be a positive number")
+	}
+	server.Speed = speed
+	return server, nil
+}
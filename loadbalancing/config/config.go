@@ -0,0 +1,151 @@
+// Package config loads the declarative configuration of the proxy: the
+// listeners it accepts traffic on and the backend pools, algorithms, health
+// checks and timeouts behind them. Files are YAML or JSON; JSON is read as
+// the YAML subset it is, so both report problems with line numbers.
+//
+// A minimal file:
+//
+//	listeners:
+//	  - address: ":8080"
+//	    pool: web
+//	pools:
+//	  - name: web
+//	    algorithm: round_robin
+//	    backends:
+//	      - address: 127.0.0.1:8081
+//	      - address: 127.0.0.1:8082
+package config
+
+import (
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/p2c"
+)
+
+// Config is the root of a configuration file.
+type Config struct {
+	Listeners []Listener `yaml:"listeners"`
+	Pools     []Pool     `yaml:"pools"`
+}
+
+// Listener is an address the proxy accepts traffic on and the pool it
+// forwards the traffic to.
+type Listener struct {
+	Name              string   `yaml:"name"`                // Defaults to the address
+	Address           string   `yaml:"address"`             // host:port to listen on, e.g. ":8080"
+	Protocol          string   `yaml:"protocol"`            // http, tcp or udp, http if empty
+	Pool              string   `yaml:"pool"`                // Name of the pool traffic goes to
+	TrustForwardedFor bool     `yaml:"trust_forwarded_for"` // http only, take the client IP from X-Forwarded-For
+	Timeouts          Timeouts `yaml:"timeouts"`
+}
+
+// Timeouts of a listener. Zero fields keep the proxy defaults.
+type Timeouts struct {
+	Dial   time.Duration `yaml:"dial"`   // tcp, connecting to a backend
+	Idle   time.Duration `yaml:"idle"`   // Closing connections, flows or http keep-alive connections without traffic
+	Header time.Duration `yaml:"header"` // http, reading the headers of a request
+}
+
+// Pool is a set of backends balanced by one algorithm.
+type Pool struct {
+	Name      string        `yaml:"name"`
+	Algorithm string        `yaml:"algorithm"` // One of algorithm.Names()
+	Backends  []Backend     `yaml:"backends"`
+	Health    *HealthCheck  `yaml:"health"`     // Active health checks, none if omitted
+	Outlier   *Outlier      `yaml:"outlier"`    // Passive outlier detection, none if omitted
+	SlowStart *SlowStart    `yaml:"slow_start"` // Weighted and least connection algorithms only
+	Hash      *HashSettings `yaml:"hash"`       // Client address hashing algorithms only
+	P2CMetric string        `yaml:"p2c_metric"` // p2c only: connections, outstanding or latency
+}
+
+// Backend is a statically configured server of a pool.
+type Backend struct {
+	ID      string `yaml:"id"` // Defaults to the address
+	Address string `yaml:"address"`
+	Weight  int    `yaml:"weight"` // 1 if omitted or 0
+}
+
+// HealthCheck configures active health checks of a pool.
+type HealthCheck struct {
+	Kind     string        `yaml:"kind"`   // tcp, http or grpc
+	Target   string        `yaml:"target"` // Path probed by http checks, service probed by grpc checks
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Rise     int           `yaml:"rise"`   // Consecutive successes that bring a down backend up
+	Fall     int           `yaml:"fall"`   // Consecutive failures that take an up backend down
+	Status   int           `yaml:"status"` // http only, expected status code, any 2xx or 3xx if omitted
+	Body     string        `yaml:"body"`   // http only, text the response body must contain
+}
+
+// Outlier configures passive outlier detection of a pool. Zero fields keep
+// the outlier package defaults.
+type Outlier struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors"`
+	EjectionTime       time.Duration `yaml:"ejection_time"`
+	MaxEjectionTime    time.Duration `yaml:"max_ejection_time"`
+	MaxEjectionPercent int           `yaml:"max_ejection_percent"`
+}
+
+// SlowStart configures the ramp up of backends that join or recover.
+type SlowStart struct {
+	Window time.Duration `yaml:"window"`
+	Ramp   string        `yaml:"ramp"` // linear or exponential, linear if empty
+}
+
+// HashSettings configures how hashing algorithms key clients.
+type HashSettings struct {
+	PrefixV4 int `yaml:"prefix_v4"` // Hash IPv4 clients by this prefix length, 0 for the full address
+	PrefixV6 int `yaml:"prefix_v6"` // Hash IPv6 clients by this prefix length, 0 for the full address
+}
+
+// Pool returns the pool with the given name, or nil.
+func (c *Config) Pool(name string) *Pool {
+	for i := range c.Pools {
+		if c.Pools[i].Name == name {
+			return &c.Pools[i]
+		}
+	}
+	return nil
+}
+
+// BalancerBackends returns the backends of the pool as balancer backends.
+func (p *Pool) BalancerBackends() []balancer.Backend {
+	backends := make([]balancer.Backend, 0, len(p.Backends))
+	for _, b := range p.Backends {
+		backends = append(backends, balancer.Backend{ID: b.ID, Address: b.Address, Weight: b.Weight})
+	}
+	return backends
+}
+
+// applyDefaults fills omitted fields that have a default. It runs after
+// validation, so defaults never hide a problem in the file.
+func (c *Config) applyDefaults() {
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if l.Protocol == "" {
+			l.Protocol = "http"
+		}
+		if l.Name == "" {
+			l.Name = l.Address
+		}
+	}
+	for i := range c.Pools {
+		p := &c.Pools[i]
+		for j := range p.Backends {
+			b := &p.Backends[j]
+			if b.ID == "" {
+				b.ID = b.Address
+			}
+			if b.Weight == 0 {
+				b.Weight = 1
+			}
+		}
+		if p.SlowStart != nil && p.SlowStart.Ramp == "" {
+			p.SlowStart.Ramp = "linear"
+		}
+		if p.Algorithm == p2c.Name && p.P2CMetric == "" {
+			p.P2CMetric = "connections"
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load("example.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Listeners) != 2 || len(cfg.Pools) != 2 {
+		t.Fatalf("expected 2 listeners and 2 pools, got %d and %d", len(cfg.Listeners), len(cfg.Pools))
+	}
+	if got := cfg.Listeners[1].Timeouts.Idle; got != 5*time.Minute {
+		t.Errorf("expected an idle timeout of 5m, got %v", got)
+	}
+	if got := cfg.Listeners[0].Timeouts.Header; got != 5*time.Second {
+		t.Errorf("expected a header timeout of 5s, got %v", got)
+	}
+
+	web := cfg.Pool("web")
+	if web == nil {
+		t.Fatal("expected the web pool")
+	}
+	if web.Backends[1].Weight != 1 {
+		t.Errorf("expected an omitted weight to default to 1, got %d", web.Backends[1].Weight)
+	}
+	if web.Health.Interval != 5*time.Second || web.Health.Status != 200 || web.SlowStart.Ramp != "exponential" {
+		t.Errorf("unexpected web settings: %+v %+v", web.Health, web.SlowStart)
+	}
+
+	cache := cfg.Pool("cache")
+	if got := cache.BalancerBackends()[0].ID; got != "127.0.0.1:6381" {
+		t.Errorf("expected the ID to default to the address, got %s", got)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	data := "{\n\t\"listeners\": [{\"address\": \":8080\", \"pool\": \"web\"}],\n\t\"pools\": [{\n\t\t\"name\": \"web\",\n\t\t\"algorithm\": \"round_robin\",\n\t\t\"backends\": [{\"address\": \"127.0.0.1:8081\", \"weight\": -1}]\n\t}]\n}\n"
+
+	_, err := Parse("lb.json", []byte(data))
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", err)
+	}
+	if errs[0].Line != 6 || errs[0].Path != "pools[0].backends[0].weight" {
+		t.Errorf("expected the weight on line 6, got %+v", errs[0])
+	}
+}
+
+func TestParseReportsLines(t *testing.T) {
+	data := `listeners:
+  - address: ":8080"
+    protocol: quic
+    pool: missing
+  - address: ":8081"
+    protocol: tcp
+    pool: web
+    timeouts:
+      header: 1s
+pools:
+  - name: web
+    algorithm: round_robin
+    backends:
+      - address: 127.0.0.1:8081
+      - address: 127.0.0.1:8081
+    slow_start:
+      window: 10s
+    p2c_metric: latency
+`
+	_, err := Parse("lb.yaml", []byte(data))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+
+	want := []string{
+		`lb.yaml:3: listeners[0].protocol: unknown protocol "quic", expected http, tcp or udp`,
+		`lb.yaml:4: listeners[0].pool: unknown pool "missing"`,
+		`lb.yaml:9: listeners[1].timeouts.header: only http listeners read request headers`,
+		`lb.yaml:15: pools[0].backends[1]: duplicate backend "127.0.0.1:8081"`,
+		`lb.yaml:17: pools[0].slow_start: algorithm round_robin does not support slow start`,
+		`lb.yaml:18: pools[0].p2c_metric: only the p2c algorithm uses a metric`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i := range want {
+		if errs[i].Error() != want[i] {
+			t.Errorf("error %d:\nexpected %s\ngot      %s", i, want[i], errs[i].Error())
+		}
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	data := `listeners:
+  - address: ":8080"
+    pool: web
+pools:
+  - name: web
+    algoritm: round_robin
+`
+	_, err := Parse("", []byte(data))
+	if err == nil || !strings.Contains(err.Error(), "6: field algoritm not found") {
+		t.Errorf("expected the misspelled field on line 6, got %v", err)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse("", []byte("listeners:\n  - address: \":8080\n"))
+	var errs Errors
+	if !errors.As(err, &errs) || errs[0].Line == 0 {
+		t.Errorf("expected a syntax error with a line, got %v", err)
+	}
+}
+
+func TestValidateWithoutFile(t *testing.T) {
+	cfg := &Config{
+		Listeners: []Listener{{Address: ":8080", Pool: "default"}},
+		Pools:     []Pool{{Name: "default", Algorithm: "p2c", Backends: []Backend{{Address: "127.0.0.1:8081"}}}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Listeners[0].Protocol != "http" || cfg.Pools[0].P2CMetric != "connections" {
+		t.Errorf("expected defaults to be applied, got %+v %+v", cfg.Listeners[0], cfg.Pools[0])
+	}
+
+	cfg.Pools[0].Health = &HealthCheck{Kind: "tcp", Body: "ok"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "only http checks read a body") {
+		t.Errorf("expected a body on a tcp check to be rejected, got %v", err)
+	}
+	cfg.Pools[0].Health = nil
+
+	cfg.Pools[0].Algorithm = "random"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `unknown algorithm "random"`) {
+		t.Errorf("expected an unknown algorithm error, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change is how a pool or listener differs between two configurations.
+type Change uint8
+
+const (
+	Added    Change = iota + 1 // Only in the new configuration
+	Removed                    // Only in the old configuration
+	Updated                    // In both, changed in place so running state is kept
+	Replaced                   // In both, rebuilt so running state starts over
+)
+
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Updated:
+		return "updated"
+	case Replaced:
+		return "replaced"
+	}
+	return fmt.Sprintf("Change(%d)", c)
+}
+
+// PoolDiff is the change of one pool, matched by name.
+type PoolDiff struct {
+	Name   string
+	Change Change
+
+	// The fields below are set for Updated pools only.
+	AddedBackends      []string // IDs of new backends, including ones whose address changed
+	RemovedBackends    []string // IDs of backends to drain, including ones whose address changed
+	ReweightedBackends []string // IDs of backends whose weight changed
+	ChecksChanged      bool     // Health check or outlier detection settings differ
+	SettingsChanged    bool     // Slow start, hash or p2c metric settings differ
+}
+
+// ListenerDiff is the change of one listener, matched by name. Updated
+// listeners keep their socket and only changed their pool or whether they
+// trust X-Forwarded-For; Replaced ones changed address, protocol or timeouts.
+type ListenerDiff struct {
+	Name   string
+	Change Change
+}
+
+// Diff is every difference between two configurations, sorted by name.
+type Diff struct {
+	Pools     []PoolDiff
+	Listeners []ListenerDiff
+}
+
+// Empty reports whether the configurations are equivalent.
+func (d Diff) Empty() bool {
+	return len(d.Pools) == 0 && len(d.Listeners) == 0
+}
+
+// String summarizes the diff on one line per pool or listener.
+func (d Diff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var lines []string
+	for _, p := range d.Pools {
+		line := fmt.Sprintf("pool %s %s", p.Name, p.Change)
+		var details []string
+		if len(p.AddedBackends) > 0 {
+			details = append(details, "added "+strings.Join(p.AddedBackends, ", "))
+		}
+		if len(p.RemovedBackends) > 0 {
+			details = append(details, "draining "+strings.Join(p.RemovedBackends, ", "))
+		}
+		if len(p.ReweightedBackends) > 0 {
+			details = append(details, "reweighted "+strings.Join(p.ReweightedBackends, ", "))
+		}
+		if p.ChecksChanged {
+			details = append(details, "checks restarted")
+		}
+		if p.SettingsChanged {
+			details = append(details, "settings changed")
+		}
+		if len(details) > 0 {
+			line += ": " + strings.Join(details, "; ")
+		}
+		lines = append(lines, line)
+	}
+	for _, l := range d.Listeners {
+		lines = append(lines, fmt.Sprintf("listener %s %s", l.Name, l.Change))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Compare returns what has to change to go from the validated configuration
+// old to the validated configuration new. A pool is replaced when its
+// algorithm changes; every other change of a pool is applied in place.
+func Compare(old, new *Config) Diff {
+	var d Diff
+
+	oldPools := make(map[string]*Pool, len(old.Pools))
+	for i := range old.Pools {
+		oldPools[old.Pools[i].Name] = &old.Pools[i]
+	}
+	for i := range new.Pools {
+		p := &new.Pools[i]
+		o, ok := oldPools[p.Name]
+		delete(oldPools, p.Name)
+		switch {
+		case !ok:
+			d.Pools = append(d.Pools, PoolDiff{Name: p.Name, Change: Added})
+		case o.Algorithm != p.Algorithm:
+			d.Pools = append(d.Pools, PoolDiff{Name: p.Name, Change: Replaced})
+		default:
+			if diff, changed := comparePool(o, p); changed {
+				d.Pools = append(d.Pools, diff)
+			}
+		}
+	}
+	for name := range oldPools {
+		d.Pools = append(d.Pools, PoolDiff{Name: name, Change: Removed})
+	}
+
+	oldListeners := make(map[string]Listener, len(old.Listeners))
+	for _, l := range old.Listeners {
+		oldListeners[l.Name] = l
+	}
+	for _, l := range new.Listeners {
+		o, ok := oldListeners[l.Name]
+		delete(oldListeners, l.Name)
+		switch {
+		case !ok:
+			d.Listeners = append(d.Listeners, ListenerDiff{Name: l.Name, Change: Added})
+		case o.Address != l.Address || o.Protocol != l.Protocol || o.Timeouts != l.Timeouts:
+			d.Listeners = append(d.Listeners, ListenerDiff{Name: l.Name, Change: Replaced})
+		case o.Pool != l.Pool || o.TrustForwardedFor != l.TrustForwardedFor:
+			d.Listeners = append(d.Listeners, ListenerDiff{Name: l.Name, Change: Updated})
+		}
+	}
+	for name := range oldListeners {
+		d.Listeners = append(d.Listeners, ListenerDiff{Name: name, Change: Removed})
+	}
+
+	sort.Slice(d.Pools, func(i, j int) bool { return d.Pools[i].Name < d.Pools[j].Name })
+	sort.Slice(d.Listeners, func(i, j int) bool { return d.Listeners[i].Name < d.Listeners[j].Name })
+	return d
+}
+
+// comparePool returns the in place changes between two versions of a pool
+// with the same algorithm and whether there are any.
+func comparePool(old, new *Pool) (PoolDiff, bool) {
+	d := PoolDiff{Name: new.Name, Change: Updated}
+
+	oldBackends := make(map[string]Backend, len(old.Backends))
+	for _, b := range old.Backends {
+		oldBackends[b.ID] = b
+	}
+	for _, b := range new.Backends {
+		o, ok := oldBackends[b.ID]
+		delete(oldBackends, b.ID)
+		switch {
+		case !ok:
+			d.AddedBackends = append(d.AddedBackends, b.ID)
+		case o.Address != b.Address:
+			d.RemovedBackends = append(d.RemovedBackends, b.ID)
+			d.AddedBackends = append(d.AddedBackends, b.ID)
+		case o.Weight != b.Weight:
+			d.ReweightedBackends = append(d.ReweightedBackends, b.ID)
+		}
+	}
+	for id := range oldBackends {
+		d.RemovedBackends = append(d.RemovedBackends, id)
+	}
+	sort.Strings(d.AddedBackends)
+	sort.Strings(d.RemovedBackends)
+	sort.Strings(d.ReweightedBackends)
+
+	d.ChecksChanged = !reflect.DeepEqual(old.Health, new.Health) || !reflect.DeepEqual(old.Outlier, new.Outlier)
+	d.SettingsChanged = !reflect.DeepEqual(old.SlowStart, new.SlowStart) || !reflect.DeepEqual(old.Hash, new.Hash) || old.P2CMetric != new.P2CMetric
+
+	changed := len(d.AddedBackends) > 0 || len(d.RemovedBackends) > 0 || len(d.ReweightedBackends) > 0 || d.ChecksChanged || d.SettingsChanged
+	return d, changed
+}
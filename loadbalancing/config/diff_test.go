@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+const baseConfig = `listeners:
+  - name: public
+    address: ":8080"
+    pool: web
+  - name: admin
+    address: ":9090"
+    pool: web
+pools:
+  - name: web
+    algorithm: least_connection
+    backends:
+      - {id: a, address: "127.0.0.1:8081"}
+      - {id: b, address: "127.0.0.1:8082"}
+      - {id: c, address: "127.0.0.1:8083"}
+  - name: cache
+    algorithm: ip_hash
+    backends:
+      - address: "127.0.0.1:6379"
+`
+
+const changedConfig = `listeners:
+  - name: public
+    address: ":8080"
+    pool: api
+  - name: metrics
+    address: ":9100"
+    pool: web
+pools:
+  - name: web
+    algorithm: least_connection
+    backends:
+      - {id: a, address: "127.0.0.1:8081", weight: 3}
+      - {id: b, address: "127.0.0.1:9082"}
+      - {id: d, address: "127.0.0.1:8084"}
+    health:
+      kind: tcp
+  - name: api
+    algorithm: round_robin
+    backends:
+      - address: "127.0.0.1:7000"
+`
+
+func TestCompare(t *testing.T) {
+	old, err := Parse("old.yaml", []byte(baseConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	new, err := Parse("new.yaml", []byte(changedConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d := Compare(old, new)
+	wantPools := []PoolDiff{
+		{Name: "api", Change: Added},
+		{Name: "cache", Change: Removed},
+		{
+			Name:               "web",
+			Change:             Updated,
+			AddedBackends:      []string{"b", "d"},
+			RemovedBackends:    []string{"b", "c"},
+			ReweightedBackends: []string{"a"},
+			ChecksChanged:      true,
+		},
+	}
+	if !reflect.DeepEqual(d.Pools, wantPools) {
+		t.Errorf("unexpected pool changes:\n got %+v\nwant %+v", d.Pools, wantPools)
+	}
+	wantListeners := []ListenerDiff{
+		{Name: "admin", Change: Removed},
+		{Name: "metrics", Change: Added},
+		{Name: "public", Change: Updated},
+	}
+	if !reflect.DeepEqual(d.Listeners, wantListeners) {
+		t.Errorf("unexpected listener changes:\n got %+v\nwant %+v", d.Listeners, wantListeners)
+	}
+
+	if d := Compare(old, old); !d.Empty() {
+		t.Errorf("expected no changes comparing a configuration with itself, got %v", d)
+	}
+}
+
+func TestCompareAlgorithmReplacesPool(t *testing.T) {
+	old, err := Parse("old.yaml", []byte(baseConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	new, _ := Parse("new.yaml", []byte(baseConfig))
+	new.Pools[0].Algorithm = "p2c"
+	new.Listeners[1].Address = ":9091"
+
+	d := Compare(old, new)
+	if !reflect.DeepEqual(d.Pools, []PoolDiff{{Name: "web", Change: Replaced}}) {
+		t.Errorf("expected the web pool to be replaced, got %+v", d.Pools)
+	}
+	if len(d.Listeners) != 1 || d.Listeners[0] != (ListenerDiff{Name: "admin", Change: Replaced}) {
+		t.Errorf("expected the admin listener to be replaced, got %+v", d.Listeners)
+	}
+}
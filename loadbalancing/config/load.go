@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem found in a configuration file.
+type Error struct {
+	File    string // Name of the file, empty if the configuration was not read from a file
+	Line    int    // 1-based line of the problem, 0 if unknown
+	Path    string // Field the problem is about, e.g. pools[0].backends[1].weight
+	Message string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		b.WriteString(strconv.Itoa(e.Line))
+		b.WriteString(":")
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// Errors is every problem found in a configuration file, in file order.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Load reads, validates and completes the configuration file at path.
+//
+// Parameters:
+//   - path: The YAML or JSON file to read
+//
+// Returns:
+//   - *Config: The configuration with defaults applied
+//   - error: An Errors value listing every problem with its line, or the error reading the file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse validates and completes the configuration in data. The name is only
+// used in error messages and may be empty.
+func Parse(name string, data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlErrors(name, err)
+	}
+	if len(root.Content) == 0 {
+		return nil, Errors{{File: name, Message: "configuration is empty"}}
+	}
+
+	// Decode strictly, so misspelled fields are reported rather than ignored.
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, yamlErrors(name, err)
+	}
+
+	v := validator{file: name, root: root.Content[0]}
+	v.validate(&cfg)
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Line < v.errs[j].Line })
+		return nil, v.errs
+	}
+	cfg.applyDefaults()
+	return &cfg, nil
+}
+
+// yamlLine matches the position yaml.v3 puts in front of its messages.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors converts a syntax or type error of yaml.v3 into Errors.
+func yamlErrors(file string, err error) Errors {
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	errs := make(Errors, 0, len(messages))
+	for _, message := range messages {
+		e := &Error{File: file, Message: strings.TrimPrefix(message, "yaml: ")}
+		if m := yamlLine.FindStringSubmatch(message); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = m[2]
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// locate returns the node at path below root, where strings select mapping
+// keys and ints select sequence items. When the path leaves the document the
+// deepest node found is returned, so a missing field is reported at the
+// mapping that lacks it.
+func locate(root *yaml.Node, path ...any) *yaml.Node {
+	node := root
+	for _, step := range path {
+		next := child(node, step)
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// child returns the value of key or the item at index of node, or nil.
+func child(node *yaml.Node, step any) *yaml.Node {
+	switch step := step.(type) {
+	case string:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == step {
+				return node.Content[i+1]
+			}
+		}
+	case int:
+		if node.Kind == yaml.SequenceNode && step < len(node.Content) {
+			return node.Content[step]
+		}
+	}
+	return nil
+}
+
+// formatPath renders a path the way it is written in error messages.
+func formatPath(path []any) string {
+	var b strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(step)
+		case int:
+			fmt.Fprintf(&b, "[%d]", step)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/p2c"
+)
+
+// validator collects the problems of a decoded configuration together with
+// the line of the node each problem is about.
+type validator struct {
+	file string
+	root *yaml.Node
+	errs Errors
+}
+
+// errorf records a problem with the field at path.
+func (v *validator) errorf(path []any, format string, args ...any) {
+	err := &Error{
+		File:    v.file,
+		Path:    formatPath(path),
+		Message: fmt.Sprintf(format, args...),
+	}
+	if v.root != nil {
+		err.Line = locate(v.root, path...).Line
+	}
+	v.errs = append(v.errs, err)
+}
+
+// at returns path extended by steps, never sharing the backing array of path.
+func at(path []any, steps ...any) []any {
+	return append(append([]any(nil), path...), steps...)
+}
+
+// Validate checks a configuration built in code rather than read from a
+// file, for example from command line flags, and fills in the defaults.
+// Problems are reported as Errors without line numbers.
+func (c *Config) Validate() error {
+	var v validator
+	v.validate(c)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	c.applyDefaults()
+	return nil
+}
+
+// validate checks every listener and pool of cfg.
+func (v *validator) validate(cfg *Config) {
+	if len(cfg.Listeners) == 0 {
+		v.errorf(nil, "at least one listener is required")
+	}
+	if len(cfg.Pools) == 0 {
+		v.errorf(nil, "at least one pool is required")
+	}
+
+	pools := make(map[string]bool, len(cfg.Pools))
+	for i := range cfg.Pools {
+		path := []any{"pools", i}
+		name := cfg.Pools[i].Name
+		if name != "" && pools[name] {
+			v.errorf(at(path, "name"), "duplicate pool %q", name)
+		}
+		pools[name] = true
+		v.validatePool(path, &cfg.Pools[i])
+	}
+
+	addresses := make(map[string]bool, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		path := []any{"listeners", i}
+		protocol := l.Protocol
+		if protocol == "" {
+			protocol = "http"
+		}
+		key := protocol + " " + l.Address
+		if l.Address != "" && addresses[key] {
+			v.errorf(at(path, "address"), "address %s is already used by another listener", l.Address)
+		}
+		addresses[key] = true
+		v.validateListener(path, l, pools)
+	}
+}
+
+// validateListener checks a listener and that its pool exists.
+func (v *validator) validateListener(path []any, l Listener, pools map[string]bool) {
+	if l.Address == "" {
+		v.errorf(at(path, "address"), "address is required")
+	} else if _, _, err := net.SplitHostPort(l.Address); err != nil {
+		v.errorf(at(path, "address"), "invalid address %q, expected host:port", l.Address)
+	}
+
+	switch l.Protocol {
+	case "", "http", "tcp", "udp":
+	default:
+		v.errorf(at(path, "protocol"), "unknown protocol %q, expected http, tcp or udp", l.Protocol)
+	}
+	if l.TrustForwardedFor && l.Protocol != "" && l.Protocol != "http" {
+		v.errorf(at(path, "trust_forwarded_for"), "only http listeners read X-Forwarded-For")
+	}
+
+	switch {
+	case l.Pool == "":
+		v.errorf(at(path, "pool"), "pool is required")
+	case !pools[l.Pool]:
+		v.errorf(at(path, "pool"), "unknown pool %q", l.Pool)
+	}
+
+	if l.Timeouts.Dial < 0 {
+		v.errorf(at(path, "timeouts", "dial"), "must not be negative")
+	}
+	if l.Timeouts.Dial > 0 && l.Protocol != "tcp" {
+		v.errorf(at(path, "timeouts", "dial"), "only tcp listeners dial backends per connection")
+	}
+	if l.Timeouts.Idle < 0 {
+		v.errorf(at(path, "timeouts", "idle"), "must not be negative")
+	}
+	if l.Timeouts.Header < 0 {
+		v.errorf(at(path, "timeouts", "header"), "must not be negative")
+	}
+	if l.Timeouts.Header > 0 && l.Protocol != "" && l.Protocol != "http" {
+		v.errorf(at(path, "timeouts", "header"), "only http listeners read request headers")
+	}
+}
+
+// validatePool checks a pool, its backends and the settings of its algorithm.
+func (v *validator) validatePool(path []any, p *Pool) {
+	if p.Name == "" {
+		v.errorf(at(path, "name"), "name is required")
+	}
+
+	var lb balancer.Balancer
+	if p.Algorithm == "" {
+		v.errorf(at(path, "algorithm"), "algorithm is required, one of: %s", strings.Join(algorithm.Names(), ", "))
+	} else if b, err := algorithm.New(p.Algorithm, nil); err != nil {
+		v.errorf(at(path, "algorithm"), "unknown algorithm %q, expected one of: %s", p.Algorithm, strings.Join(algorithm.Names(), ", "))
+	} else {
+		lb = b
+	}
+
+	if len(p.Backends) == 0 {
+		v.errorf(at(path, "backends"), "at least one backend is required")
+	}
+	ids := make(map[string]bool, len(p.Backends))
+	for i, b := range p.Backends {
+		backendPath := at(path, "backends", i)
+		if b.Address == "" {
+			v.errorf(at(backendPath, "address"), "address is required")
+		}
+		if b.Weight < 0 {
+			v.errorf(at(backendPath, "weight"), "must not be negative")
+		}
+		id := b.ID
+		if id == "" {
+			id = b.Address
+		}
+		if id != "" && ids[id] {
+			v.errorf(backendPath, "duplicate backend %q", id)
+		}
+		ids[id] = true
+	}
+
+	if h := p.Health; h != nil {
+		healthPath := at(path, "health")
+		switch h.Kind {
+		case "tcp", "http", "grpc":
+		case "":
+			v.errorf(at(healthPath, "kind"), "kind is required, one of: tcp, http, grpc")
+		default:
+			v.errorf(at(healthPath, "kind"), "unknown health check %q, expected tcp, http or grpc", h.Kind)
+		}
+		if h.Interval < 0 {
+			v.errorf(at(healthPath, "interval"), "must not be negative")
+		}
+		if h.Timeout < 0 {
+			v.errorf(at(healthPath, "timeout"), "must not be negative")
+		}
+		if h.Rise < 0 {
+			v.errorf(at(healthPath, "rise"), "must not be negative")
+		}
+		if h.Fall < 0 {
+			v.errorf(at(healthPath, "fall"), "must not be negative")
+		}
+		if h.Interval > 0 && h.Timeout > h.Interval {
+			v.errorf(at(healthPath, "timeout"), "must not exceed the interval")
+		}
+		if h.Status != 0 && (h.Status < 100 || h.Status > 599) {
+			v.errorf(at(healthPath, "status"), "invalid status code %d", h.Status)
+		}
+		if h.Kind != "http" {
+			if h.Status != 0 {
+				v.errorf(at(healthPath, "status"), "only http checks have a status")
+			}
+			if h.Body != "" {
+				v.errorf(at(healthPath, "body"), "only http checks read a body")
+			}
+		}
+	}
+
+	if o := p.Outlier; o != nil {
+		outlierPath := at(path, "outlier")
+		if o.ConsecutiveErrors < 0 {
+			v.errorf(at(outlierPath, "consecutive_errors"), "must not be negative")
+		}
+		if o.EjectionTime < 0 {
+			v.errorf(at(outlierPath, "ejection_time"), "must not be negative")
+		}
+		if o.MaxEjectionTime < 0 {
+			v.errorf(at(outlierPath, "max_ejection_time"), "must not be negative")
+		}
+		if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+			v.errorf(at(outlierPath, "max_ejection_percent"), "must be between 0 and 100")
+		}
+	}
+
+	if s := p.SlowStart; s != nil {
+		slowPath := at(path, "slow_start")
+		if s.Window <= 0 {
+			v.errorf(at(slowPath, "window"), "must be positive")
+		}
+		if s.Ramp != "" {
+			if _, err := balancer.RampByName(s.Ramp); err != nil {
+				v.errorf(at(slowPath, "ramp"), "unknown ramp %q, expected linear or exponential", s.Ramp)
+			}
+		}
+		if _, ok := lb.(balancer.SlowStarter); lb != nil && !ok {
+			v.errorf(slowPath, "algorithm %s does not support slow start", p.Algorithm)
+		}
+	}
+
+	if h := p.Hash; h != nil {
+		hashPath := at(path, "hash")
+		if h.PrefixV4 < 0 || h.PrefixV4 > 32 {
+			v.errorf(at(hashPath, "prefix_v4"), "must be between 0 and 32")
+		}
+		if h.PrefixV6 < 0 || h.PrefixV6 > 128 {
+			v.errorf(at(hashPath, "prefix_v6"), "must be between 0 and 128")
+		}
+		if _, ok := lb.(interface{ SetPrefixLengths(v4, v6 int) error }); lb != nil && !ok {
+			v.errorf(hashPath, "algorithm %s does not hash client addresses", p.Algorithm)
+		}
+	}
+
+	if p.P2CMetric != "" {
+		if p.Algorithm != p2c.Name {
+			v.errorf(at(path, "p2c_metric"), "only the %s algorithm uses a metric", p2c.Name)
+		} else if _, err := p2c.MetricByName(p.P2CMetric); err != nil {
+			v.errorf(at(path, "p2c_metric"), "unknown metric %q, expected connections, outstanding or latency", p.P2CMetric)
+		}
+	}
+}
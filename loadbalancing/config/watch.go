@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Watch polls the file at path every interval and sends on the returned
+// channel whenever its content changed, until ctx is cancelled. Polling the
+// content rather than the modification time also catches editors and
+// deployment tools that replace the file through a rename. A file that
+// cannot be read is treated as unchanged, so it is reported again once it is
+// readable and differs from the last content seen.
+func Watch(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	last := fileSum(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			sum := fileSum(path)
+			if sum == nil || bytes.Equal(sum, last) {
+				continue
+			}
+			last = sum
+			// A pending notification already covers this change.
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
+
+// fileSum returns the SHA-256 of the file at path, or nil if it cannot be read.
+func fileSum(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,267 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Labels the DockerRuntime puts on its containers, to list them apart from
+// the other containers of the host and to find their published port.
+const (
+	managedLabel = "sysdesign.loadbalancing.managed"
+	portLabel    = "sysdesign.loadbalancing.port"
+)
+
+// DockerRuntime runs containers through the Docker daemon.
+type DockerRuntime struct {
+	docker *client.Client
+}
+
+// NewDockerRuntime creates a DockerRuntime using the system's Docker
+// environment.
+//
+// Returns:
+//   - *DockerRuntime: A pointer to the new DockerRuntime instance
+//   - error: An error if the Docker client creation fails
+func NewDockerRuntime() (*DockerRuntime, error) {
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %v", err)
+	}
+	return &DockerRuntime{docker: dockerClient}, nil
+}
+
+// Create pulls the image of spec if it is missing and creates its container.
+func (d *DockerRuntime) Create(ctx context.Context, spec Spec) (string, error) {
+	if err := d.ensureImageExists(ctx, spec.Image); err != nil {
+		return "", err
+	}
+
+	config, hostConfig := d.prepareContainerConfig(spec)
+	fmt.Println("Creating container...")
+	resp, err := d.docker.ContainerCreate(ctx, config, hostConfig, nil, nil, spec.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %v", err)
+	}
+	fmt.Printf("Container created with ID: %s\n", resp.ID)
+	return resp.ID, nil
+}
+
+// Start starts the container with the given ID.
+func (d *DockerRuntime) Start(ctx context.Context, id string) error {
+	if err := d.docker.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return d.wrap(id, "start", err)
+	}
+	return nil
+}
+
+// Stop stops the container with the given ID.
+func (d *DockerRuntime) Stop(ctx context.Context, id string) error {
+	if err := d.docker.ContainerStop(ctx, id, container.StopOptions{}); err != nil {
+		return d.wrap(id, "stop", err)
+	}
+	return nil
+}
+
+// Remove removes the container with the given ID.
+func (d *DockerRuntime) Remove(ctx context.Context, id string) error {
+	if err := d.docker.ContainerRemove(ctx, id, container.RemoveOptions{}); err != nil {
+		return d.wrap(id, "remove", err)
+	}
+	return nil
+}
+
+// Inspect returns the state of the container with the given ID.
+func (d *DockerRuntime) Inspect(ctx context.Context, id string) (*Instance, error) {
+	info, err := d.docker.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, d.wrap(id, "inspect", err)
+	}
+	return toInstance(info), nil
+}
+
+// List returns the containers created through a DockerRuntime, running or not.
+func (d *DockerRuntime) List(ctx context.Context) ([]Instance, error) {
+	containers, err := d.docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	instances := make([]Instance, 0, len(containers))
+	for _, c := range containers {
+		instance, err := d.Inspect(ctx, c.ID)
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		instances = append(instances, *instance)
+	}
+	return instances, nil
+}
+
+// Events streams the changes of the containers created through a
+// DockerRuntime. The channel is closed when ctx is cancelled or the stream
+// from the daemon fails.
+func (d *DockerRuntime) Events(ctx context.Context) (<-chan Event, error) {
+	messages, errs := d.docker.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)), filters.Arg("label", managedLabel)),
+	})
+
+	out := make(chan Event, 64)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case m := <-messages:
+				select {
+				case out <- Event{ID: m.Actor.ID, Action: Action(m.Action), Time: time.Unix(0, m.TimeNano)}:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-errs:
+				if ctx.Err() == nil {
+					log.Printf("container: event stream failed: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Close closes the connection to the Docker daemon.
+func (d *DockerRuntime) Close() error {
+	return d.docker.Close()
+}
+
+// wrap reports a failed operation on the container id, as a
+// ContainerNotFoundError if Docker does not know it.
+func (d *DockerRuntime) wrap(id, operation string, err error) error {
+	if client.IsErrNotFound(err) {
+		return &lberror.ContainerNotFoundError{ID: id}
+	}
+	return fmt.Errorf("failed to %s container: %v", operation, err)
+}
+
+// ensureImageExists checks if the specified Docker image exists locally,
+// and if not, pulls it from Docker Hub.
+//
+// Parameters:
+//   - ctx: The context for the Docker API calls
+//   - imageName: The name of the Docker image to check/pull
+//
+// Returns:
+//   - error: An error if checking for the image or pulling it fails
+func (d *DockerRuntime) ensureImageExists(ctx context.Context, imageName string) error {
+	fmt.Printf("Checking if image %s exists locally...\n", imageName)
+	_, _, err := d.docker.ImageInspectWithRaw(ctx, imageName)
+	if err == nil {
+		fmt.Printf("Image %s found locally.\n", imageName)
+		return nil // Image exists
+	}
+
+	fmt.Printf("Image %s not found locally. Pulling from Docker Hub...\n", imageName)
+	reader, err := d.docker.ImagePull(ctx, imageName, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	defer reader.Close()
+
+	// Print pull progress
+	decoder := json.NewDecoder(reader)
+	for {
+		var message map[string]interface{}
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("failed to decode pull message: %v", err)
+		}
+		if status, ok := message["status"]; ok {
+			fmt.Println(status)
+		}
+	}
+
+	fmt.Printf("Image %s pulled successfully.\n", imageName)
+	return nil
+}
+
+// prepareContainerConfig creates and returns the configuration of the
+// container for spec, publishing its port on a port Docker assigns.
+//
+// Parameters:
+//   - spec: The image, port and labels of the container
+//
+// Returns:
+//   - *container.Config: The container configuration
+//   - *container.HostConfig: The host configuration for the container
+func (d *DockerRuntime) prepareContainerConfig(spec Spec) (*container.Config, *container.HostConfig) {
+	fmt.Println("Preparing container configuration...")
+	port := nat.Port(fmt.Sprintf("%d/tcp", spec.port()))
+	labels := maps.Clone(spec.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[managedLabel] = "true"
+	labels[portLabel] = strconv.Itoa(spec.port())
+
+	config := &container.Config{
+		Image:        spec.Image,
+		Labels:       labels,
+		ExposedPorts: nat.PortSet{port: struct{}{}},
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: nat.PortMap{
+			port: []nat.PortBinding{
+				{
+					HostIP:   "0.0.0.0",
+					HostPort: "0", // Let Docker assign a port
+				},
+			},
+		},
+	}
+	return config, hostConfig
+}
+
+// toInstance converts the result of a Docker inspection.
+func toInstance(info types.ContainerJSON) *Instance {
+	instance := &Instance{ID: info.ID, Name: strings.TrimPrefix(info.Name, "/")}
+	if info.Config != nil {
+		instance.Image = info.Config.Image
+		instance.Labels = maps.Clone(info.Config.Labels)
+		delete(instance.Labels, managedLabel)
+		delete(instance.Labels, portLabel)
+	}
+	if info.State != nil {
+		instance.State = State(info.State.Status)
+	}
+	instance.Created, _ = time.Parse(time.RFC3339Nano, info.Created)
+
+	if instance.State == StateRunning && info.NetworkSettings != nil && info.Config != nil {
+		port := nat.Port(info.Config.Labels[portLabel] + "/tcp")
+		if bindings := info.NetworkSettings.Ports[port]; len(bindings) > 0 {
+			instance.Address = "localhost:" + bindings[0].HostPort
+		}
+	}
+	return instance
+}
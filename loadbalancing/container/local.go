@@ -0,0 +1,246 @@
+package container
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/fakebackend"
+)
+
+// LocalRuntime runs every container as a fake backend in the current
+// process, see the fakebackend package, so orchestration built on a Runtime
+// can be unit tested without Docker. A container keeps its address across
+// restarts. It is safe for concurrent use.
+type LocalRuntime struct {
+	template fakebackend.Config
+
+	mutex       sync.Mutex
+	containers  map[string]*localContainer
+	order       []string // IDs in order of creation
+	subscribers map[chan Event]struct{}
+}
+
+// localContainer is a container of a LocalRuntime.
+type localContainer struct {
+	Instance
+	address string              // Kept after the first start
+	server  *fakebackend.Server // nil unless running
+}
+
+// NewLocalRuntime creates a LocalRuntime.
+//
+// Parameters:
+//   - template: The configuration of the fake backend of every container; ID and Address are set per container
+//
+// Returns:
+//   - *LocalRuntime: A pointer to the new LocalRuntime instance
+func NewLocalRuntime(template fakebackend.Config) *LocalRuntime {
+	return &LocalRuntime{
+		template:    template,
+		containers:  make(map[string]*localContainer),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Create registers a container for spec, named after its ID if spec has no name.
+func (r *LocalRuntime) Create(ctx context.Context, spec Spec) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name := spec.Name
+	if name == "" {
+		name = id[:12]
+	}
+	for _, c := range r.containers {
+		if c.Name == name {
+			return "", fmt.Errorf("failed to create container: name %q is already in use", name)
+		}
+	}
+	r.containers[id] = &localContainer{Instance: Instance{
+		ID:      id,
+		Name:    name,
+		Image:   spec.Image,
+		State:   StateCreated,
+		Labels:  maps.Clone(spec.Labels),
+		Created: time.Now(),
+	}}
+	r.order = append(r.order, id)
+	r.emit(id, ActionCreate)
+	return id, nil
+}
+
+// Start starts the fake backend of the container. Starting a running
+// container does nothing.
+func (r *LocalRuntime) Start(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, err := r.get(id)
+	if err != nil || c.server != nil {
+		return err
+	}
+
+	cfg := r.template
+	cfg.ID = c.Name
+	cfg.Address = c.address
+	server, err := fakebackend.Start(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
+	}
+	c.server = server
+	c.address = server.Address()
+	c.State = StateRunning
+	c.Address = c.address
+	r.emit(id, ActionStart)
+	return nil
+}
+
+// Stop stops the fake backend of the container, dropping its connections.
+// Stopping a stopped container does nothing.
+func (r *LocalRuntime) Stop(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, err := r.get(id)
+	if err != nil || c.server == nil {
+		return err
+	}
+	r.exit(c)
+	r.emit(id, ActionStop)
+	return nil
+}
+
+// Kill makes the container exit as if its process crashed: subscribers see
+// it die without being stopped.
+func (r *LocalRuntime) Kill(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, err := r.get(id)
+	if err != nil || c.server == nil {
+		return err
+	}
+	r.exit(c)
+	return nil
+}
+
+// exit closes the fake backend of the running container c. r.mutex must be held.
+func (r *LocalRuntime) exit(c *localContainer) {
+	c.server.Close()
+	c.server = nil
+	c.State = StateExited
+	c.Address = ""
+	r.emit(c.ID, ActionDie)
+}
+
+// Remove deletes a container that is not running.
+func (r *LocalRuntime) Remove(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, err := r.get(id)
+	if err != nil {
+		return err
+	}
+	if c.server != nil {
+		return fmt.Errorf("failed to remove container: %s is running, stop it first", id)
+	}
+	delete(r.containers, id)
+	r.order = slices.DeleteFunc(r.order, func(other string) bool { return other == id })
+	r.emit(id, ActionDestroy)
+	return nil
+}
+
+// Inspect returns the state of the container.
+func (r *LocalRuntime) Inspect(ctx context.Context, id string) (*Instance, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	instance := c.Instance
+	instance.Labels = maps.Clone(c.Labels)
+	return &instance, nil
+}
+
+// List returns every container in the order they were created.
+func (r *LocalRuntime) List(ctx context.Context) ([]Instance, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	instances := make([]Instance, 0, len(r.order))
+	for _, id := range r.order {
+		instance := r.containers[id].Instance
+		instance.Labels = maps.Clone(instance.Labels)
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// Events streams the changes of every container until ctx is cancelled.
+// Events are dropped, and logged, for subscribers that fall more than a
+// buffer behind.
+func (r *LocalRuntime) Events(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, 64)
+	r.mutex.Lock()
+	r.subscribers[ch] = struct{}{}
+	r.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.subscribers, ch)
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// Server returns the fake backend of a running container, to script its
+// profile or make it hang, or nil.
+func (r *LocalRuntime) Server(id string) *fakebackend.Server {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c, ok := r.containers[id]; ok {
+		return c.server
+	}
+	return nil
+}
+
+// get returns the container id. r.mutex must be held.
+func (r *LocalRuntime) get(id string) (*localContainer, error) {
+	c, ok := r.containers[id]
+	if !ok {
+		return nil, &lberror.ContainerNotFoundError{ID: id}
+	}
+	return c, nil
+}
+
+// emit sends an event to every subscriber. r.mutex must be held.
+func (r *LocalRuntime) emit(id string, action Action) {
+	e := Event{ID: id, Action: action, Time: time.Now()}
+	for ch := range r.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("container: dropped %s event of %s for a slow subscriber", action, id)
+		}
+	}
+}
+
+// newID returns a random container ID shaped like Docker's.
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,119 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/fakebackend"
+)
+
+var _ Runtime = (*LocalRuntime)(nil)
+var _ Runtime = (*DockerRuntime)(nil)
+
+func TestLocalRuntimeLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewLocalRuntime(fakebackend.Config{})
+	events, _ := r.Events(ctx)
+
+	id, err := r.Create(ctx, Spec{Name: "web", Labels: map[string]string{"pool": "a"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Create(ctx, Spec{Name: "web"}); err == nil {
+		t.Error("expected a duplicate name to be rejected")
+	}
+	if err := r.Start(ctx, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	instance, _ := r.Inspect(ctx, id)
+	if instance.State != StateRunning || instance.Labels["pool"] != "a" {
+		t.Fatalf("expected a running container with its labels, got %+v", instance)
+	}
+	resp, err := http.Get("http://" + instance.Address)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "web\n" {
+		t.Errorf("expected the container to answer with its name, got %q", body)
+	}
+
+	if err := r.Remove(ctx, id); err == nil {
+		t.Error("expected removing a running container to fail")
+	}
+	if err := r.Stop(ctx, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stopped, _ := r.Inspect(ctx, id); stopped.State != StateExited || stopped.Address != "" {
+		t.Errorf("expected an exited container without address, got %+v", stopped)
+	}
+	r.Start(ctx, id)
+	if restarted, _ := r.Inspect(ctx, id); restarted.Address != instance.Address {
+		t.Errorf("expected the address kept across restarts, got %s then %s", instance.Address, restarted.Address)
+	}
+	r.Kill(id)
+	if err := r.Remove(ctx, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var notFound *lberror.ContainerNotFoundError
+	if _, err := r.Inspect(ctx, id); !errors.As(err, &notFound) {
+		t.Errorf("expected ContainerNotFoundError, got %v", err)
+	}
+	if list, _ := r.List(ctx); len(list) != 0 {
+		t.Errorf("expected no containers left, got %v", list)
+	}
+
+	want := []Action{ActionCreate, ActionStart, ActionDie, ActionStop, ActionStart, ActionDie, ActionDestroy}
+	for i, action := range want {
+		select {
+		case e := <-events:
+			if e.ID != id || e.Action != action {
+				t.Errorf("event %d: expected %s of %s, got %s of %s", i, action, id, e.Action, e.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected event %d, %s", i, action)
+		}
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestLocalRuntimeListsInCreationOrder(t *testing.T) {
+	ctx := context.Background()
+	r := NewLocalRuntime(fakebackend.Config{Protocol: fakebackend.TCP})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, _ := r.Create(ctx, Spec{})
+		ids = append(ids, id)
+	}
+	r.Start(ctx, ids[1])
+	defer r.Stop(ctx, ids[1])
+
+	list, err := r.List(ctx)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("expected 3 containers, got %d and %v", len(list), err)
+	}
+	for i, instance := range list {
+		if instance.ID != ids[i] {
+			t.Errorf("expected %s at %d, got %s", ids[i], i, instance.ID)
+		}
+		if instance.Name != ids[i][:12] {
+			t.Errorf("expected a name from the ID, got %q", instance.Name)
+		}
+	}
+	if list[1].State != StateRunning || list[0].State != StateCreated {
+		t.Errorf("expected only the second container running, got %s and %s", list[0].State, list[1].State)
+	}
+	if r.Server(ids[1]) == nil || r.Server(ids[0]) != nil {
+		t.Error("expected a fake backend for the running container only")
+	}
+}
@@ -0,0 +1,59 @@
+package container
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"sysdesign/loadbalancing/fakebackend"
+)
+
+func TestManagerCreatesAndRemovesContainers(t *testing.T) {
+	runtime := NewLocalRuntime(fakebackend.Config{})
+	ncm := NewManager(runtime)
+
+	info, err := ncm.CreateContainer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Port == 0 || info.URL == "" {
+		t.Fatalf("expected a published port and URL, got %+v", info)
+	}
+	resp, err := http.Get(info.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if instance, _ := runtime.Inspect(context.Background(), info.ID); instance.Image != nginxImage {
+		t.Errorf("expected a container of %s, got %s", nginxImage, instance.Image)
+	}
+
+	if err := ncm.RemoveContainer(info.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list, _ := runtime.List(context.Background()); len(list) != 0 {
+		t.Errorf("expected the container removed, got %v", list)
+	}
+	if err := ncm.RemoveContainer(info.ID); err == nil {
+		t.Error("expected removing an unknown container to fail")
+	}
+}
+
+// failingRuntime fails to start containers.
+type failingRuntime struct {
+	*LocalRuntime
+}
+
+func (failingRuntime) Start(context.Context, string) error {
+	return context.DeadlineExceeded
+}
+
+func TestManagerRemovesContainersThatFailToStart(t *testing.T) {
+	runtime := failingRuntime{NewLocalRuntime(fakebackend.Config{})}
+	if _, err := NewManager(runtime).CreateContainer(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if list, _ := runtime.List(context.Background()); len(list) != 0 {
+		t.Errorf("expected the created container removed, got %v", list)
+	}
+}
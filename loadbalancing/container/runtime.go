@@ -0,0 +1,81 @@
+package container
+
+import (
+	"context"
+	"time"
+)
+
+// Runtime runs backends as containers, processes or goroutines. The
+// DockerRuntime runs real containers, the LocalRuntime runs fake backends in
+// the current process so orchestration can be tested without Docker.
+type Runtime interface {
+	// Create prepares a container for spec without starting it and returns its ID.
+	Create(ctx context.Context, spec Spec) (string, error)
+	// Start starts a created or stopped container.
+	Start(ctx context.Context, id string) error
+	// Stop stops a running container.
+	Stop(ctx context.Context, id string) error
+	// Remove deletes a stopped container.
+	Remove(ctx context.Context, id string) error
+	// Inspect returns the current state of a container.
+	Inspect(ctx context.Context, id string) (*Instance, error)
+	// List returns every container created through the runtime.
+	List(ctx context.Context) ([]Instance, error)
+	// Events streams the changes of those containers until ctx is cancelled,
+	// when the channel is closed.
+	Events(ctx context.Context) (<-chan Event, error)
+}
+
+// Spec describes a container to create.
+type Spec struct {
+	Name   string            // Name of the container, generated if empty
+	Image  string            // Image to run, ignored by LocalRuntime
+	Port   int               // Port served inside the container, published on a free host port; 80 if zero
+	Labels map[string]string // Labels attached to the container
+}
+
+// port returns the port served inside the container.
+func (s Spec) port() int {
+	if s.Port <= 0 {
+		return 80
+	}
+	return s.Port
+}
+
+// State is the state of a container, named as Docker names it.
+type State string
+
+const (
+	StateCreated State = "created"
+	StateRunning State = "running"
+	StateExited  State = "exited"
+)
+
+// Instance is a point-in-time view of a container.
+type Instance struct {
+	ID      string
+	Name    string
+	Image   string
+	State   State
+	Address string // host:port the published port is reached on, empty unless running
+	Labels  map[string]string
+	Created time.Time
+}
+
+// Action is what happened to a container in an Event, named as Docker names it.
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionStart   Action = "start"
+	ActionDie     Action = "die" // The container stopped, asked to or not
+	ActionStop    Action = "stop"
+	ActionDestroy Action = "destroy"
+)
+
+// Event reports a change of a container.
+type Event struct {
+	ID     string
+	Action Action
+	Time   time.Time
+}
@@ -1,8 +1,70 @@
 package error
 
+import "fmt"
+
 type NoServersError struct {
 }
 
 func (e *NoServersError) Error() string {
 	return "No servers found as their count is zero"
 }
+
+// BackendNotFoundError is returned when an operation refers to a backend ID
+// that is not registered with the balancer.
+type BackendNotFoundError struct {
+	ID string
+}
+
+func (e *BackendNotFoundError) Error() string {
+	return fmt.Sprintf("backend %q not found", e.ID)
+}
+
+// DuplicateBackendError is returned when a backend is added with an ID that
+// is already registered with the balancer.
+type DuplicateBackendError struct {
+	ID string
+}
+
+func (e *DuplicateBackendError) Error() string {
+	return fmt.Sprintf("backend %q already exists", e.ID)
+}
+
+// InvalidAddressError is returned when a client address used for hashing
+// cannot be parsed as an IP address or host:port pair.
+type InvalidAddressError struct {
+	Address string
+}
+
+func (e *InvalidAddressError) Error() string {
+	return fmt.Sprintf("invalid client address %q", e.Address)
+}
+
+// PoolNotFoundError is returned when an operation refers to a pool that is
+// not running.
+type PoolNotFoundError struct {
+	Name string
+}
+
+func (e *PoolNotFoundError) Error() string {
+	return fmt.Sprintf("pool %q not found", e.Name)
+}
+
+// NoHealthChecksError is returned when a health check is requested for a
+// pool that has none configured.
+type NoHealthChecksError struct {
+	Pool string
+}
+
+func (e *NoHealthChecksError) Error() string {
+	return fmt.Sprintf("pool %q has no health checks", e.Pool)
+}
+
+// ContainerNotFoundError is returned when a container runtime is asked about
+// a container it does not know.
+type ContainerNotFoundError struct {
+	ID string
+}
+
+func (e *ContainerNotFoundError) Error() string {
+	return fmt.Sprintf("container %q not found", e.ID)
+}
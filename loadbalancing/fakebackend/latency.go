@@ -0,0 +1,62 @@
+package fakebackend
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Latency is the distribution of the time a server takes to answer.
+// simulator.Exponential and simulator.Pareto fit as well, for light and
+// heavy tails.
+type Latency interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+// Constant latency is always the same.
+type Constant time.Duration
+
+func (c Constant) Sample(*rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+// Uniform latency is spread evenly between Min and Max.
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (u Uniform) Sample(r *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(r.Int64N(int64(u.Max-u.Min)))
+}
+
+// Mix draws from one of its modes, chosen by their weights, to script a
+// distribution such as 99% around 5ms and 1% stuck for a second:
+//
+//	Mix{{Weight: 99, Latency: Uniform{4 * time.Millisecond, 6 * time.Millisecond}}, {Weight: 1, Latency: Constant(time.Second)}}
+type Mix []Mode
+
+// Mode is one part of a Mix.
+type Mode struct {
+	Weight  float64
+	Latency Latency
+}
+
+func (m Mix) Sample(r *rand.Rand) time.Duration {
+	total := 0.0
+	for _, mode := range m {
+		total += mode.Weight
+	}
+	x := r.Float64() * total
+	for _, mode := range m {
+		if x < mode.Weight {
+			return mode.Latency.Sample(r)
+		}
+		x -= mode.Weight
+	}
+	if len(m) == 0 {
+		return 0
+	}
+	return m[len(m)-1].Latency.Sample(r)
+}
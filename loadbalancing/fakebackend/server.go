@@ -0,0 +1,407 @@
+// Package fakebackend starts HTTP and TCP servers on loopback that behave
+// like backends with a scripted profile: a latency distribution, an error
+// rate and a capacity limit, changed at any time with SetProfile. Servers
+// crash, hang and recover on command, so the proxy, health checks and
+// algorithms can be tested end to end without Docker.
+//
+// An HTTP server answers with its ID, or through Config.Handler, once the
+// latency passed. A TCP server echoes what it receives after the latency,
+// one request being one connection.
+package fakebackend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// Protocols a Server speaks.
+const (
+	HTTP = "http"
+	TCP  = "tcp"
+)
+
+// Profile scripts how a server answers.
+type Profile struct {
+	Latency   Latency // Time taken before answering, none if nil
+	ErrorRate float64 // Share of requests failed, answered 500 over HTTP or closed without answer over TCP
+	Capacity  int     // Requests served at once, more are answered 503 or closed right away; 0 for no limit
+}
+
+// Config describes a Server.
+type Config struct {
+	ID       string       // Backend ID, "b1" if empty
+	Protocol string       // HTTP or TCP, HTTP if empty
+	Address  string       // Address to listen on, a free loopback port if empty
+	Profile  Profile      // Initial profile
+	Handler  http.Handler // Answers HTTP requests the profile lets through, with the ID if nil
+	Seed     uint64       // Seeds the latency and error draws
+}
+
+// Stats counts what a server did.
+type Stats struct {
+	Requests int64 // Requests, or TCP connections, received
+	Rejected int64 // Turned away for lack of capacity
+	Failed   int64 // Failed on purpose by the error rate
+	Active   int64 // Being served, waiting or hanging now
+}
+
+// Server is a fake backend. It is safe for concurrent use.
+type Server struct {
+	cfg     Config
+	address string // Stays the same across crashes
+
+	mutex    sync.Mutex
+	profile  Profile
+	rand     *rand.Rand
+	listener net.Listener // nil while crashed or closed
+	http     *http.Server
+	conns    map[net.Conn]context.CancelFunc // Open TCP connections
+	hung     chan struct{}                   // Closed by Recover, nil unless hanging
+	closed   bool
+	stats    Stats
+	wg       sync.WaitGroup // TCP connections being served
+}
+
+// Start starts a server.
+//
+// Parameters:
+//   - cfg: The ID, protocol, address and initial profile of the server
+//
+// Returns:
+//   - *Server: The running server, to be closed once done
+//   - error: An error if the protocol is unknown or the address cannot be listened on
+func Start(cfg Config) (*Server, error) {
+	if cfg.ID == "" {
+		cfg.ID = "b1"
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = HTTP
+	}
+	if cfg.Protocol != HTTP && cfg.Protocol != TCP {
+		return nil, fmt.Errorf("unknown protocol %q, expected http or tcp", cfg.Protocol)
+	}
+	if cfg.Address == "" {
+		cfg.Address = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		address: l.Addr().String(),
+		profile: cfg.Profile,
+		rand:    rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)),
+		conns:   make(map[net.Conn]context.CancelFunc),
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.serve(l)
+	return s, nil
+}
+
+// serve starts serving on l. s.mutex must be held.
+func (s *Server) serve(l net.Listener) {
+	s.listener = l
+	if s.cfg.Protocol == TCP {
+		go s.acceptTCP(l)
+		return
+	}
+	s.http = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go s.http.Serve(l)
+}
+
+// ID returns the backend ID of the server.
+func (s *Server) ID() string {
+	return s.cfg.ID
+}
+
+// Address returns the host:port the server listens on.
+func (s *Server) Address() string {
+	return s.address
+}
+
+// Backend returns the server as a backend of weight 1.
+func (s *Server) Backend() balancer.Backend {
+	return balancer.Backend{ID: s.cfg.ID, Address: s.address, Weight: 1}
+}
+
+// SetProfile changes how the server answers new requests.
+func (s *Server) SetProfile(p Profile) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.profile = p
+}
+
+// Stats returns what the server did so far.
+func (s *Server) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
+
+// Crash stops listening and drops every open connection, as a killed
+// process would. New connections are refused until Recover.
+func (s *Server) Crash() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.crash()
+}
+
+// crash stops serving. s.mutex must be held.
+func (s *Server) crash() {
+	if s.listener == nil {
+		return
+	}
+	if s.http != nil {
+		s.http.Close()
+		s.http = nil
+	} else {
+		s.listener.Close()
+	}
+	for conn, cancel := range s.conns {
+		cancel()
+		conn.Close()
+	}
+	s.listener = nil
+}
+
+// Hang keeps accepting connections but answers nothing until Recover, as a
+// deadlocked process would. Requests already past the hang are answered.
+func (s *Server) Hang() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.hung == nil {
+		s.hung = make(chan struct{})
+	}
+}
+
+// Recover ends a hang, answering the requests held by it, and listens again
+// on the same address after a crash.
+func (s *Server) Recover() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.hung != nil {
+		close(s.hung)
+		s.hung = nil
+	}
+	if s.listener != nil || s.closed {
+		return nil
+	}
+	l, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.serve(l)
+	return nil
+}
+
+// Close stops the server for good, dropping open connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.crash()
+	if s.hung != nil {
+		close(s.hung)
+		s.hung = nil
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// verdict is what the profile decided for a request.
+type verdict int
+
+const (
+	serve   verdict = iota
+	reject          // Over capacity
+	fail            // Failed by the error rate
+	abandon         // The client or a crash ended it first
+)
+
+// begin applies the profile to a new request: it checks the capacity, waits
+// out a hang and the latency, and draws whether the request fails. done
+// ends the wait early. Unless the request is rejected, end must be called
+// once it is answered.
+func (s *Server) begin(done <-chan struct{}) verdict {
+	s.mutex.Lock()
+	s.stats.Requests++
+	p := s.profile
+	if p.Capacity > 0 && s.stats.Active >= int64(p.Capacity) {
+		s.stats.Rejected++
+		s.mutex.Unlock()
+		return reject
+	}
+	s.stats.Active++
+	hung := s.hung
+	var delay time.Duration
+	if p.Latency != nil {
+		delay = p.Latency.Sample(s.rand)
+	}
+	failed := p.ErrorRate > 0 && s.rand.Float64() < p.ErrorRate
+	if failed {
+		s.stats.Failed++
+	}
+	s.mutex.Unlock()
+
+	if hung != nil {
+		select {
+		case <-hung:
+		case <-done:
+			return abandon
+		}
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+			return abandon
+		}
+	}
+	if failed {
+		return fail
+	}
+	return serve
+}
+
+// end counts a request as answered.
+func (s *Server) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Active--
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	v := s.begin(r.Context().Done())
+	if v != reject {
+		defer s.end()
+	}
+	switch v {
+	case reject:
+		http.Error(w, "over capacity", http.StatusServiceUnavailable)
+	case fail:
+		http.Error(w, "scripted failure", http.StatusInternalServerError)
+	case serve:
+		if s.cfg.Handler != nil {
+			s.cfg.Handler.ServeHTTP(w, r)
+			return
+		}
+		fmt.Fprintln(w, s.cfg.ID)
+	}
+}
+
+// acceptTCP serves the connections of l until it is closed.
+func (s *Server) acceptTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.mutex.Lock()
+		if s.listener != l {
+			// Crashed between Accept and now.
+			s.mutex.Unlock()
+			cancel()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = cancel
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveTCP(ctx, conn)
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			cancel()
+			conn.Close()
+		}()
+	}
+}
+
+// serveTCP echoes what conn sends once the profile lets it through.
+func (s *Server) serveTCP(ctx context.Context, conn net.Conn) {
+	v := s.begin(ctx.Done())
+	if v == reject {
+		return
+	}
+	defer s.end()
+	if v == serve {
+		io.Copy(conn, conn)
+	}
+}
+
+// Group is a set of servers started together.
+type Group []*Server
+
+// StartGroup starts n servers on free loopback ports.
+//
+// Parameters:
+//   - n: The number of servers
+//   - cfg: The configuration of every server; IDs are cfg.ID, "b" if empty, followed by 1 to n, and seeds differ
+//
+// Returns:
+//   - Group: The running servers, to be closed once done
+//   - error: An error if a server failed to start; the others are closed
+func StartGroup(n int, cfg Config) (Group, error) {
+	prefix := cfg.ID
+	if prefix == "" {
+		prefix = "b"
+	}
+	seed := cfg.Seed
+	var g Group
+	for i := 1; i <= n; i++ {
+		cfg.ID = fmt.Sprintf("%s%d", prefix, i)
+		cfg.Address = ""
+		cfg.Seed = seed + uint64(i)
+		s, err := Start(cfg)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g = append(g, s)
+	}
+	return g, nil
+}
+
+// Backends returns the servers as backends of weight 1.
+func (g Group) Backends() []balancer.Backend {
+	backends := make([]balancer.Backend, len(g))
+	for i, s := range g {
+		backends[i] = s.Backend()
+	}
+	return backends
+}
+
+// Server returns the server with the given ID, or nil.
+func (g Group) Server(id string) *Server {
+	for _, s := range g {
+		if s.cfg.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Close closes every server.
+func (g Group) Close() error {
+	for _, s := range g {
+		s.Close()
+	}
+	return nil
+}
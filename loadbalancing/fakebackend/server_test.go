@@ -0,0 +1,260 @@
+package fakebackend
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/health"
+	"sysdesign/loadbalancing/proxy"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServerAnswersWithItsID(t *testing.T) {
+	s, err := Start(Config{ID: "a", Profile: Profile{Latency: Constant(20 * time.Millisecond)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	status, body := get(t, "http://"+s.Address()+"/")
+	if status != http.StatusOK || body != "a\n" {
+		t.Errorf("expected 200 with the ID, got %d %q", status, body)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the latency of the profile, answered after %v", elapsed)
+	}
+	if stats := s.Stats(); stats.Requests != 1 || stats.Active != 0 {
+		t.Errorf("expected one finished request, got %+v", stats)
+	}
+}
+
+func TestServerFailsAtItsErrorRate(t *testing.T) {
+	s, _ := Start(Config{Profile: Profile{ErrorRate: 0.3}, Seed: 3})
+	defer s.Close()
+
+	failed := 0
+	for i := 0; i < 500; i++ {
+		if status, _ := get(t, "http://"+s.Address()); status == http.StatusInternalServerError {
+			failed++
+		}
+	}
+	if failed < 110 || failed > 190 {
+		t.Errorf("expected about 150 of 500 requests to fail, got %d", failed)
+	}
+	if stats := s.Stats(); stats.Failed != int64(failed) {
+		t.Errorf("expected %d failures counted, got %d", failed, stats.Failed)
+	}
+}
+
+func TestServerRejectsBeyondCapacity(t *testing.T) {
+	s, _ := Start(Config{Profile: Profile{Capacity: 2, Latency: Constant(100 * time.Millisecond)}})
+	defer s.Close()
+
+	statuses := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i], _ = get(t, "http://"+s.Address())
+		}(i)
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			ok++
+		} else if status != http.StatusServiceUnavailable {
+			t.Errorf("expected 200 or 503, got %d", status)
+		}
+	}
+	if ok != 2 {
+		t.Errorf("expected 2 requests served at once, got %d of %v", ok, statuses)
+	}
+}
+
+func TestServerCrashesAndRecovers(t *testing.T) {
+	s, _ := Start(Config{Profile: Profile{Latency: Constant(time.Second)}})
+	defer s.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := http.Get("http://" + s.Address())
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.Crash()
+
+	if err := <-errs; err == nil {
+		t.Error("expected the request in flight to fail with the crash")
+	}
+	if _, err := net.Dial("tcp", s.Address()); err == nil {
+		t.Error("expected new connections to be refused while crashed")
+	}
+
+	if err := s.Recover(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.SetProfile(Profile{})
+	if status, _ := get(t, "http://"+s.Address()); status != http.StatusOK {
+		t.Errorf("expected 200 on the same address after recovering, got %d", status)
+	}
+}
+
+func TestServerHangsUntilRecovered(t *testing.T) {
+	s, _ := Start(Config{})
+	defer s.Close()
+	s.Hang()
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	if _, err := client.Get("http://" + s.Address()); err == nil {
+		t.Error("expected a hanging server to time out")
+	}
+
+	answered := make(chan int, 1)
+	go func() {
+		status, _ := get(t, "http://"+s.Address())
+		answered <- status
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-answered:
+		t.Fatal("expected no answer while hanging")
+	default:
+	}
+	s.Recover()
+	if status := <-answered; status != http.StatusOK {
+		t.Errorf("expected the held request answered after recovering, got %d", status)
+	}
+}
+
+func TestTCPServerEchoes(t *testing.T) {
+	s, err := Start(Config{Protocol: TCP, Profile: Profile{Capacity: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("expected the line echoed, got %q and %v", line, err)
+	}
+
+	// The first connection holds the only slot, the second is closed.
+	second, _ := net.Dial("tcp", s.Address())
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected a connection beyond capacity to be closed, got %v", err)
+	}
+}
+
+func TestMixFollowsItsWeights(t *testing.T) {
+	mix := Mix{{Weight: 9, Latency: Constant(time.Millisecond)}, {Weight: 1, Latency: Uniform{Min: time.Second, Max: 2 * time.Second}}}
+	r := rand.New(rand.NewPCG(1, 2))
+	slow := 0
+	for i := 0; i < 10000; i++ {
+		d := mix.Sample(r)
+		if d >= time.Second {
+			slow++
+			if d >= 2*time.Second {
+				t.Fatalf("expected a uniform latency below 2s, got %v", d)
+			}
+		}
+	}
+	if slow < 900 || slow > 1100 {
+		t.Errorf("expected about 1000 slow samples, got %d", slow)
+	}
+}
+
+func TestProxyAndHealthChecksEndToEnd(t *testing.T) {
+	group, err := StartGroup(3, Config{Profile: Profile{Latency: Uniform{Max: 2 * time.Millisecond}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer group.Close()
+
+	b, _ := algorithm.New("round_robin", group.Backends())
+	checker := health.NewHealthChecker(b, health.HTTPProbe{Client: &http.Client{Timeout: 50 * time.Millisecond}}, health.Config{
+		Interval: 20 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+		Rise:     1,
+		Fall:     1,
+	})
+	events := checker.Subscribe()
+	checker.Start(context.Background())
+	defer checker.Stop()
+	front := httptest.NewServer(proxy.NewHTTPProxy(b))
+	defer front.Close()
+
+	group.Server("b2").Hang()
+	waitFor(t, events, "b2", false)
+
+	served := map[string]int{}
+	for i := 0; i < 30; i++ {
+		status, body := get(t, front.URL)
+		if status != http.StatusOK {
+			t.Fatalf("expected the proxy to avoid the hanging backend, got %d", status)
+		}
+		served[strings.TrimSpace(body)]++
+	}
+	if served["b2"] != 0 || served["b1"] != 15 || served["b3"] != 15 {
+		t.Errorf("expected b1 and b3 to share the traffic, got %v", served)
+	}
+
+	group.Server("b2").Recover()
+	waitFor(t, events, "b2", true)
+	healthy := 0
+	for _, status := range b.Snapshot() {
+		if status.Healthy {
+			healthy++
+		}
+	}
+	if healthy != 3 {
+		t.Errorf("expected every backend healthy again, got %d", healthy)
+	}
+}
+
+// waitFor waits for the health event taking id up or down.
+func waitFor(t *testing.T, events <-chan health.Event, id string, healthy bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.BackendID == id && e.Healthy == healthy {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("expected %s to become healthy=%v", id, healthy)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package health
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Config controls how often backends are probed and when their state flips.
+type Config struct {
+	Interval time.Duration // Time between two probes of the same backend
+	Timeout  time.Duration // Deadline of a single probe
+	Rise     int           // Consecutive successes that bring a down backend up
+	Fall     int           // Consecutive failures that take an up backend down
+	Jitter   float64       // Fraction of Interval each wait is randomly shortened or lengthened by
+}
+
+// DefaultConfig returns the configuration used for zero fields of a Config.
+func DefaultConfig() Config {
+	return Config{
+		Interval: 5 * time.Second,
+		Timeout:  2 * time.Second,
+		Rise:     2,
+		Fall:     3,
+		Jitter:   0.1,
+	}
+}
+
+// withDefaults fills the zero fields of c from DefaultConfig.
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.Rise <= 0 {
+		c.Rise = d.Rise
+	}
+	if c.Fall <= 0 {
+		c.Fall = d.Fall
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		c.Jitter = d.Jitter
+	}
+	return c
+}
+
+// Event reports that a backend changed state.
+type Event struct {
+	BackendID string
+	Healthy   bool
+	Err       error // Error of the probe that took the backend down, nil when it came up
+	Time      time.Time
+}
+
+// Status is the health checking state of one backend.
+type Status struct {
+	Healthy   bool
+	Successes int       // Consecutive successful probes
+	Failures  int       // Consecutive failed probes
+	LastError error     // Error of the most recent probe, nil if it succeeded
+	LastCheck time.Time // When the most recent probe finished
+}
+
+// target is a backend being probed by its own goroutine.
+type target struct {
+	backend balancer.Backend
+	status  Status
+	cancel  context.CancelFunc
+	recheck chan struct{}
+}
+
+// HealthChecker probes every backend of a balancer on its own schedule and
+// marks backends down after Fall consecutive failures and up again after Rise
+// consecutive successes. Backends added to or removed from the balancer are
+// picked up on the next interval.
+type HealthChecker struct {
+	balancer balancer.Balancer
+	probe    Probe
+	config   Config
+
+	mutex       sync.Mutex
+	targets     map[string]*target
+	subscribers []chan Event
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	resync      chan struct{} // Asks for a sync before the next interval
+}
+
+// NewHealthChecker creates a HealthChecker for the backends of b.
+//
+// Parameters:
+//   - b: The balancer whose backends are probed and marked up or down
+//   - probe: The check run against every backend
+//   - config: Probe scheduling and thresholds, zero fields use DefaultConfig
+//
+// Returns:
+//   - *HealthChecker: A pointer to the new HealthChecker, call Start to begin probing
+func NewHealthChecker(b balancer.Balancer, probe Probe, config Config) *HealthChecker {
+	return &HealthChecker{
+		balancer: b,
+		probe:    probe,
+		config:   config.withDefaults(),
+		targets:  make(map[string]*target),
+		resync:   make(chan struct{}, 1),
+	}
+}
+
+// Start begins probing in the background until ctx is cancelled or Stop is called.
+func (hc *HealthChecker) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	hc.mutex.Lock()
+	hc.cancel = cancel
+	hc.mutex.Unlock()
+
+	hc.sync(ctx)
+	hc.wg.Add(1)
+	go func() {
+		defer hc.wg.Done()
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hc.sync(ctx)
+			case <-hc.resync:
+				hc.sync(ctx)
+			}
+		}
+	}()
+}
+
+// Stop ends probing, waits for running probes and closes every subscription.
+func (hc *HealthChecker) Stop() {
+	hc.mutex.Lock()
+	if hc.cancel != nil {
+		hc.cancel()
+	}
+	hc.mutex.Unlock()
+
+	hc.wg.Wait()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	for _, ch := range hc.subscribers {
+		close(ch)
+	}
+	hc.subscribers = nil
+}
+
+// Subscribe returns a channel receiving every state change. Events are
+// dropped, and logged, for subscribers that fall more than a buffer behind.
+func (hc *HealthChecker) Subscribe() <-chan Event {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	ch := make(chan Event, 64)
+	hc.subscribers = append(hc.subscribers, ch)
+	return ch
+}
+
+// Sync picks up the backends added to or removed from the balancer at once
+// instead of on the next interval.
+func (hc *HealthChecker) Sync() {
+	select {
+	case hc.resync <- struct{}{}:
+	default: // A sync is already pending
+	}
+}
+
+// CheckNow makes the backend's next probe run immediately instead of waiting
+// for its interval.
+func (hc *HealthChecker) CheckNow(id string) error {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	t, ok := hc.targets[id]
+	if !ok {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	select {
+	case t.recheck <- struct{}{}:
+	default: // A recheck is already pending
+	}
+	return nil
+}
+
+// Statuses returns the health checking state of every probed backend.
+func (hc *HealthChecker) Statuses() map[string]Status {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	statuses := make(map[string]Status, len(hc.targets))
+	for id, t := range hc.targets {
+		statuses[id] = t.status
+	}
+	return statuses
+}
+
+// sync starts probing new backends of the balancer and stops probing removed ones.
+func (hc *HealthChecker) sync(ctx context.Context) {
+	snapshot := hc.balancer.Snapshot()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	current := make(map[string]bool, len(snapshot))
+	for _, status := range snapshot {
+		current[status.ID] = true
+		if t, ok := hc.targets[status.ID]; ok {
+			if t.backend.Address == status.Address {
+				continue
+			}
+			// The backend was replaced under the same ID, probe the new address.
+			t.cancel()
+		}
+
+		targetCtx, cancel := context.WithCancel(ctx)
+		t := &target{
+			backend: status.Backend,
+			status:  Status{Healthy: status.Healthy},
+			cancel:  cancel,
+			recheck: make(chan struct{}, 1),
+		}
+		hc.targets[status.ID] = t
+		hc.wg.Add(1)
+		go hc.run(targetCtx, t)
+	}
+
+	for id, t := range hc.targets {
+		if !current[id] {
+			t.cancel()
+			delete(hc.targets, id)
+		}
+	}
+}
+
+// run probes one backend until ctx is cancelled. The first probe is delayed
+// by a random fraction of the interval so backends are not probed in lockstep.
+func (hc *HealthChecker) run(ctx context.Context, t *target) {
+	defer hc.wg.Done()
+
+	timer := time.NewTimer(time.Duration(rand.Float64() * float64(hc.config.Interval)))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-t.recheck:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, hc.config.Timeout)
+		err := hc.probe.Check(probeCtx, &t.backend)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		hc.record(t, err)
+		timer.Reset(hc.nextDelay())
+	}
+}
+
+// record applies the result of a probe to the backend's counters and flips
+// its state in the balancer once a threshold is crossed.
+func (hc *HealthChecker) record(t *target, err error) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	s := &t.status
+	s.LastError = err
+	s.LastCheck = time.Now()
+	if err == nil {
+		s.Successes++
+		s.Failures = 0
+	} else {
+		s.Failures++
+		s.Successes = 0
+	}
+
+	switch {
+	case s.Healthy && s.Failures >= hc.config.Fall:
+		s.Healthy = false
+	case !s.Healthy && s.Successes >= hc.config.Rise:
+		s.Healthy = true
+	default:
+		return
+	}
+
+	if setErr := hc.balancer.SetHealthy(t.backend.ID, s.Healthy); setErr != nil {
+		log.Printf("health: failed to mark backend %s: %v", t.backend.ID, setErr)
+		return
+	}
+
+	event := Event{BackendID: t.backend.ID, Healthy: s.Healthy, Err: err, Time: s.LastCheck}
+	for _, ch := range hc.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("health: subscriber too slow, dropped event for backend %s", t.backend.ID)
+		}
+	}
+}
+
+// nextDelay returns the interval shifted by a random amount within the jitter.
+func (hc *HealthChecker) nextDelay() time.Duration {
+	jitter := (rand.Float64()*2 - 1) * hc.config.Jitter
+	return time.Duration(float64(hc.config.Interval) * (1 + jitter))
+}
@@ -0,0 +1,212 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+)
+
+// switchProbe fails for the backends listed in failing.
+type switchProbe struct {
+	mutex   sync.Mutex
+	failing map[string]bool
+}
+
+func (p *switchProbe) set(id string, failing bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failing[id] = failing
+}
+
+func (p *switchProbe) Check(ctx context.Context, backend *balancer.Backend) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.failing[backend.ID] {
+		return errors.New("probe failed")
+	}
+	return nil
+}
+
+func waitForEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no health event received")
+		return Event{}
+	}
+}
+
+func TestHealthCheckerEjectsAndRestores(t *testing.T) {
+	for _, name := range algorithm.Names() {
+		t.Run(name, func(t *testing.T) {
+			lb, err := algorithm.New(name, []balancer.Backend{
+				{ID: "a", Address: "a:80", Weight: 1},
+				{ID: "b", Address: "b:80", Weight: 1},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			probe := &switchProbe{failing: map[string]bool{"a": true}}
+			hc := NewHealthChecker(lb, probe, Config{Interval: 5 * time.Millisecond, Rise: 2, Fall: 2})
+			events := hc.Subscribe()
+			hc.Start(context.Background())
+			defer hc.Stop()
+
+			event := waitForEvent(t, events)
+			if event.BackendID != "a" || event.Healthy || event.Err == nil {
+				t.Fatalf("expected a to go down with an error, got %+v", event)
+			}
+			for i := 0; i < 10; i++ {
+				picked, err := lb.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if picked.ID != "b" {
+					t.Fatalf("expected the unhealthy backend to be skipped, got %s", picked.ID)
+				}
+				lb.Release(picked)
+			}
+
+			probe.set("a", false)
+			event = waitForEvent(t, events)
+			if event.BackendID != "a" || !event.Healthy {
+				t.Fatalf("expected a to come back up, got %+v", event)
+			}
+			for _, status := range lb.Snapshot() {
+				if !status.Healthy {
+					t.Errorf("expected %s to be healthy", status.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCheckerAllDown(t *testing.T) {
+	lb, _ := algorithm.New("round_robin", []balancer.Backend{{ID: "a", Address: "a:80"}})
+	probe := &switchProbe{failing: map[string]bool{"a": true}}
+	hc := NewHealthChecker(lb, probe, Config{Interval: 5 * time.Millisecond, Fall: 1})
+	events := hc.Subscribe()
+	hc.Start(context.Background())
+	defer hc.Stop()
+
+	waitForEvent(t, events)
+	if _, err := lb.Pick(nil); err == nil {
+		t.Error("expected an error when every backend is down")
+	}
+	if status := hc.Statuses()["a"]; status.Healthy || status.Failures == 0 || status.LastError == nil {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthCheckerCheckNow(t *testing.T) {
+	lb, _ := algorithm.New("round_robin", []balancer.Backend{{ID: "a", Address: "a:80"}})
+	probe := &switchProbe{failing: map[string]bool{"a": true}}
+	hc := NewHealthChecker(lb, probe, Config{Interval: time.Hour, Fall: 1})
+	events := hc.Subscribe()
+	hc.Start(context.Background())
+	defer hc.Stop()
+
+	if err := hc.CheckNow("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event := waitForEvent(t, events); event.Healthy {
+		t.Errorf("expected a to go down, got %+v", event)
+	}
+	if err := hc.CheckNow("missing"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+
+	backend := &balancer.Backend{ID: "a", Address: addr}
+	if err := (TCPProbe{}).Check(context.Background(), backend); err != nil {
+		t.Errorf("expected listening backend to be healthy, got %v", err)
+	}
+
+	l.Close()
+	if err := (TCPProbe{}).Check(context.Background(), backend); err == nil {
+		t.Error("expected closed backend to be unhealthy")
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("status: ok"))
+	}))
+	defer server.Close()
+	backend := &balancer.Backend{ID: "a", Address: server.URL}
+
+	tests := []struct {
+		name    string
+		probe   HTTPProbe
+		healthy bool
+	}{
+		{"matching path", HTTPProbe{Path: "/healthz"}, true},
+		{"wrong path", HTTPProbe{Path: "/missing"}, false},
+		{"expected status", HTTPProbe{Path: "/missing", Status: http.StatusNotFound}, true},
+		{"body match", HTTPProbe{Path: "/healthz", BodyContains: "ok"}, true},
+		{"body mismatch", HTTPProbe{Path: "/healthz", BodyContains: "degraded"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.probe.Check(context.Background(), backend)
+			if tt.healthy && err != nil {
+				t.Errorf("expected healthy, got %v", err)
+			}
+			if !tt.healthy && err == nil {
+				t.Error("expected unhealthy, got nil")
+			}
+		})
+	}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(l)
+	defer server.Stop()
+
+	backend := &balancer.Backend{ID: "a", Address: l.Addr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	if err := (GRPCProbe{Service: "orders"}).Check(ctx, backend); err != nil {
+		t.Errorf("expected serving service to be healthy, got %v", err)
+	}
+
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := (GRPCProbe{Service: "orders"}).Check(ctx, backend); err == nil {
+		t.Error("expected not serving service to be unhealthy")
+	}
+}
@@ -0,0 +1,135 @@
+// Package health actively probes backends and marks them up or down in a
+// balancer.Balancer, so that every algorithm stops picking backends that no
+// longer answer and picks them again once they recover.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// Probe checks a single backend once. A nil error means the backend is healthy.
+// Implementations must honour the deadline of ctx.
+type Probe interface {
+	Check(ctx context.Context, backend *balancer.Backend) error
+}
+
+// TCPProbe considers a backend healthy when a TCP connection can be opened.
+type TCPProbe struct{}
+
+// Check dials the backend and closes the connection right away.
+func (TCPProbe) Check(ctx context.Context, backend *balancer.Backend) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", backend.HostPort())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HTTPProbe considers a backend healthy when a GET request to Path answers
+// with the expected status and, optionally, a body containing BodyContains.
+type HTTPProbe struct {
+	Path         string       // Request path, "/" when empty
+	Status       int          // Expected status code, any 2xx or 3xx when zero
+	BodyContains string       // Substring the body must contain, ignored when empty
+	Client       *http.Client // Client used for the request, http.DefaultClient when nil
+}
+
+// maxProbeBody bounds how much of a response body is read when matching it.
+const maxProbeBody = 64 * 1024
+
+// Check sends the probe request and validates the response.
+func (p HTTPProbe) Check(ctx context.Context, backend *balancer.Backend) error {
+	target, err := backend.URL()
+	if err != nil {
+		return err
+	}
+	target.Path = p.Path
+	if target.Path == "" {
+		target.Path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if p.Status != 0 && resp.StatusCode != p.Status {
+		return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, p.Status)
+	}
+	if p.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if p.BodyContains == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(body), p.BodyContains) {
+		return fmt.Errorf("response body does not contain %q", p.BodyContains)
+	}
+	return nil
+}
+
+// GRPCProbe uses the standard gRPC health checking protocol
+// (grpc.health.v1.Health/Check) over a plaintext connection.
+type GRPCProbe struct {
+	Service string // Service name to ask about, the whole server when empty
+}
+
+// Check asks the backend for the serving status of the service.
+func (p GRPCProbe) Check(ctx context.Context, backend *balancer.Backend) error {
+	conn, err := grpc.NewClient(backend.HostPort(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: p.Service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q is %s", p.Service, resp.GetStatus())
+	}
+	return nil
+}
+
+// NewProbe returns the probe registered under kind: "tcp", "http" or "grpc".
+// For "http" the target is the request path, for "grpc" the service name.
+func NewProbe(kind, target string) (Probe, error) {
+	switch kind {
+	case "tcp":
+		return TCPProbe{}, nil
+	case "http":
+		return HTTPProbe{Path: target}, nil
+	case "grpc":
+		return GRPCProbe{Service: target}, nil
+	default:
+		return nil, fmt.Errorf("unknown health check %q", kind)
+	}
+}
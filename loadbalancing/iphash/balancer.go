@@ -0,0 +1,197 @@
+package iphash
+
+import (
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the IP hash algorithm in configuration.
+const Name = "ip_hash"
+
+// Names of the table based modes in configuration.
+const (
+	ConsistentHashName = "consistent_hash"
+	MaglevName         = "maglev"
+	RendezvousName     = "rendezvous"
+)
+
+var _ balancer.Balancer = (*IPHash)(nil)
+
+// Name returns the algorithm identifier.
+func (ip *IPHash) Name() string {
+	if ip.name != "" {
+		return ip.name
+	}
+	return Name
+}
+
+// Pick returns the server the request hashes to. An explicit Key, such as a
+// UDP flow's "ip:port", is hashed as is; otherwise the client IP is used.
+func (ip *IPHash) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	var hash uint32
+	var err error
+	if req != nil && req.Key != "" {
+		hash = hashKey(req.Key)
+	} else if hash, err = hashIp(req.HashKey(), ip.prefixV4, ip.prefixV6); err != nil {
+		return nil, err
+	}
+	server, err := ip.serverFor(hash)
+	if err != nil {
+		return nil, err
+	}
+	if req.Explained() {
+		req.Reason = ip.describe(hash)
+	}
+
+	if ip.active == nil {
+		ip.active = make(map[string]int)
+	}
+	ip.active[server.ID]++
+	return ip.backendFor(server), nil
+}
+
+// Release marks a pick of the backend as finished. A draining server is
+// removed with its last pick.
+func (ip *IPHash) Release(backend *balancer.Backend) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if ip.active[backend.ID] > 0 {
+		ip.active[backend.ID]--
+	}
+	if ip.active[backend.ID] == 0 && ip.conditions.Has(backend.ID, balancer.ConditionDraining) {
+		ip.removeServer(backend.ID)
+	}
+}
+
+// AddBackend registers the backend as a server keyed by its ID.
+func (ip *IPHash) AddBackend(backend balancer.Backend) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if ip.indexOf(backend.ID) >= 0 {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+
+	if ip.backends == nil {
+		ip.backends = make(map[string]*balancer.Backend)
+	}
+	ip.backends[backend.ID] = &backend
+	ip.changeMembership(func() {
+		ip.servers = append(ip.servers, Server{ID: backend.ID, Weight: backend.Weight})
+	})
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID through RemoveServer.
+func (ip *IPHash) RemoveBackend(id string) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if err := ip.removeServer(id); err != nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// SetWeight records the new weight. In table based modes the weight sets the
+// server's share of the keys, otherwise it is ignored when picking.
+func (ip *IPHash) SetWeight(id string, weight int) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if !ip.updateWeight(id, weight) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain stops sending clients to the server and removes it once its last
+// pick is released.
+func (ip *IPHash) Drain(id string) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if !ip.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain sends the clients of the draining server back to it.
+func (ip *IPHash) Undrain(id string) error {
+	return ip.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetHealthy marks the server as up or down. Clients hashing to a server that
+// is down are served by the next healthy server until it recovers.
+func (ip *IPHash) SetHealthy(id string, healthy bool) error {
+	return ip.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server out of or back into rotation. Its clients are
+// served by the next available server meanwhile.
+func (ip *IPHash) SetEjected(id string, ejected bool) error {
+	return ip.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (ip *IPHash) setCondition(id string, condition balancer.Condition, on bool) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if ip.indexOf(id) < 0 {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	ip.conditions.Set(id, condition, on)
+	return nil
+}
+
+// Snapshot returns every server in the order they were added, which is hash
+// bucket order unless in a table based mode.
+func (ip *IPHash) Snapshot() []balancer.BackendStatus {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(ip.servers))
+	for _, server := range ip.servers {
+		statuses = append(statuses, ip.conditions.Status(balancer.BackendStatus{
+			Backend:     *ip.backendFor(server),
+			Connections: ip.active[server.ID],
+		}))
+	}
+	return statuses
+}
+
+// indexOf returns the position of the server with the given ID, or -1.
+// The caller must hold ip.mutex.
+func (ip *IPHash) indexOf(id string) int {
+	for i, server := range ip.servers {
+		if server.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// backendFor returns the backend registered for server. Servers added through
+// AddServer have no backend yet, so one is created using the server ID as the
+// address. The caller must hold ip.mutex.
+func (ip *IPHash) backendFor(server Server) *balancer.Backend {
+	if b, ok := ip.backends[server.ID]; ok {
+		return b
+	}
+	if ip.backends == nil {
+		ip.backends = make(map[string]*balancer.Backend)
+	}
+	weight := server.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	b := &balancer.Backend{ID: server.ID, Address: server.ID, Weight: weight}
+	ip.backends[server.ID] = b
+	return b
+}
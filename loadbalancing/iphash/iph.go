@@ -2,20 +2,45 @@ package iphash
 
 import (
 	"errors"
+	"fmt"
 	"hash/fnv"
-	"net"
+	"net/netip"
 	"sync"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
 )
 
 // Server represents a server with a unique ID.
 type Server struct {
-	ID string
+	ID     string
+	Weight int // Share of the keys in table based modes, 0 counts as 1
+}
+
+// table maps a hash onto the ID of a server, skipping servers for which
+// available returns false. Tables are never modified once built.
+type table interface {
+	lookup(hash uint32, available func(id string) bool) (string, bool)
+	// describe tells where hash falls in the table, e.g. "maglev slot 12 of 65537".
+	describe(hash uint32) string
 }
 
 // IPHash is a load balancer that distributes requests based on the client's IP address.
 type IPHash struct {
 	servers []Server
 	mutex   sync.RWMutex
+
+	name  string                       // Algorithm name reported by Name, empty for plain IP hash
+	build func(servers []Server) table // Builds the lookup table, nil selects hash modulo the server count
+	table table                        // Lookup table, rebuilt on every membership change
+	moved *change                      // Last membership change, nil before the first
+
+	prefixV4 int // Leading bits of IPv4 addresses that are hashed, 0 hashes the whole address
+	prefixV6 int // Leading bits of IPv6 addresses that are hashed, 0 hashes the whole address
+
+	backends   map[string]*balancer.Backend // Backend details keyed by server ID
+	active     map[string]int               // Picks not yet released, per server ID
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
 }
 
 // IpHashLoadBalancer initializes a new IPHash load balancer with the given servers.
@@ -23,11 +48,73 @@ func IpHashLoadBalancer(servers []Server) *IPHash {
 	return &IPHash{servers: servers}
 }
 
+// ConsistentHashLoadBalancer initializes an IPHash load balancer that maps
+// clients onto a consistent hash ring instead of hash modulo the server count.
+// Adding or removing a server then only moves the clients of the ring arcs
+// that server gains or loses, about 1/n of them, instead of nearly all.
+//
+// Parameters:
+//   - servers: The initial servers, each placed on the ring replicas*Weight times
+//   - replicas: Virtual nodes per unit of weight, DefaultReplicas if not positive
+//
+// Returns:
+//   - *IPHash: A pointer to the new IPHash in consistent hash mode
+func ConsistentHashLoadBalancer(servers []Server, replicas int) *IPHash {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return newTableBalancer(ConsistentHashName, servers, func(servers []Server) table {
+		return newRing(servers, replicas)
+	})
+}
+
+// MaglevLoadBalancer initializes an IPHash load balancer that maps clients
+// through a Maglev lookup table. Lookups cost O(1) and servers receive shares
+// of the table within a fraction of a percent of their weight.
+//
+// Parameters:
+//   - servers: The initial servers, weighted by Weight
+//   - tableSize: Slots in the lookup table, rounded up to a prime, DefaultTableSize if not positive
+//
+// Returns:
+//   - *IPHash: A pointer to the new IPHash in Maglev mode
+func MaglevLoadBalancer(servers []Server, tableSize int) *IPHash {
+	if tableSize <= 0 {
+		tableSize = DefaultTableSize
+	}
+	tableSize = nextPrime(tableSize)
+	return newTableBalancer(MaglevName, servers, func(servers []Server) table {
+		return newMaglev(servers, tableSize)
+	})
+}
+
+// RendezvousLoadBalancer initializes an IPHash load balancer that maps clients
+// by highest random weight hashing. It needs no table and moves the fewest
+// keys on membership changes, at the cost of lookups linear in the servers.
+func RendezvousLoadBalancer(servers []Server) *IPHash {
+	return newTableBalancer(RendezvousName, servers, func(servers []Server) table {
+		return newRendezvous(servers)
+	})
+}
+
+// newTableBalancer initializes an IPHash load balancer that maps hashes onto
+// servers through the table returned by build.
+func newTableBalancer(name string, servers []Server, build func(servers []Server) table) *IPHash {
+	return &IPHash{
+		servers: servers,
+		name:    name,
+		build:   build,
+		table:   build(servers),
+	}
+}
+
 // AddServer adds a new server to the load balancer.
 func (ip *IPHash) AddServer(server *Server) {
 	ip.mutex.Lock()
 	defer ip.mutex.Unlock()
-	ip.servers = append(ip.servers, *server)
+	ip.changeMembership(func() {
+		ip.servers = append(ip.servers, *server)
+	})
 }
 
 // RemoveServer removes a server from the load balancer by its ID.
@@ -35,35 +122,273 @@ func (ip *IPHash) AddServer(server *Server) {
 func (ip *IPHash) RemoveServer(Id string) error {
 	ip.mutex.Lock()
 	defer ip.mutex.Unlock()
+	return ip.removeServer(Id)
+}
 
+// removeServer deletes the server with the given ID and everything recorded
+// about it. The caller must hold ip.mutex.
+func (ip *IPHash) removeServer(Id string) error {
 	for i, server := range ip.servers {
 		if server.ID == Id {
-			ip.servers = append(ip.servers[:i], ip.servers[i+1:]...)
+			ip.changeMembership(func() {
+				ip.servers = append(ip.servers[:i], ip.servers[i+1:]...)
+			})
+			delete(ip.backends, Id)
+			delete(ip.active, Id)
+			ip.conditions.Forget(Id)
 			return nil
 		}
 	}
 	return errors.New("server not found")
 }
 
-// GetServer returns the server assigned to the given client IP address.
-// Returns an error if no servers are available.
+// UpdateWeight changes the weight of the server with the given ID. In table
+// based modes the weight sets the server's share of the keys, otherwise it is
+// ignored when picking. Returns an error if the server is not found.
+func (ip *IPHash) UpdateWeight(id string, weight int) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	if !ip.updateWeight(id, weight) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer stops sending clients to the server with the given ID and
+// removes it once every pick of it made through Pick has been released, at
+// once if there is none. Its clients move as if it were down meanwhile.
+// Returns an error if the server is not found.
+func (ip *IPHash) DrainServer(id string) error {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	if !ip.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// updateWeight applies weight to the server, rebuilding the lookup table in
+// table based modes. It reports whether the server was found.
+// The caller must hold ip.mutex.
+func (ip *IPHash) updateWeight(id string, weight int) bool {
+	i := ip.indexOf(id)
+	if i < 0 {
+		return false
+	}
+
+	updated := *ip.backendFor(ip.servers[i])
+	updated.Weight = weight
+	ip.backends[id] = &updated
+	if ip.build != nil {
+		ip.changeMembership(func() {
+			ip.servers[i].Weight = weight
+		})
+	} else {
+		ip.servers[i].Weight = weight
+	}
+	return true
+}
+
+// drainServer marks the server as draining and removes it if it has no picks
+// left. It reports whether the server was found. The caller must hold ip.mutex.
+func (ip *IPHash) drainServer(id string) bool {
+	if ip.indexOf(id) < 0 {
+		return false
+	}
+	ip.conditions.Set(id, balancer.ConditionDraining, true)
+	if ip.active[id] == 0 {
+		ip.removeServer(id)
+	}
+	return true
+}
+
+// SetPrefixLengths makes clients sharing the leading v4 bits of an IPv4
+// address, or v6 bits of an IPv6 address, hash alike, so clients behind the
+// same NAT or in the same subnet, e.g. /24 and /64, stay on one server.
+// Zero hashes the whole address.
+//
+// Parameters:
+//   - v4: Prefix length for IPv4 clients, 0 to 32
+//   - v6: Prefix length for IPv6 clients, 0 to 128
+//
+// Returns:
+//   - error: An error if a length is out of range
+func (ip *IPHash) SetPrefixLengths(v4, v6 int) error {
+	if v4 < 0 || v4 > 32 {
+		return fmt.Errorf("invalid IPv4 prefix length %d", v4)
+	}
+	if v6 < 0 || v6 > 128 {
+		return fmt.Errorf("invalid IPv6 prefix length %d", v6)
+	}
+
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	ip.prefixV4, ip.prefixV6 = v4, v6
+	return nil
+}
+
+// GetServer returns the server assigned to the given client IP address, which
+// may be IPv4, IPv6 or either with a port, as in "[2001:db8::1]:443".
+// Returns an InvalidAddressError if the address cannot be parsed, or an error
+// if no servers are available.
 func (ip *IPHash) GetServer(clientIP string) (Server, error) {
 	ip.mutex.Lock()
 	defer ip.mutex.Unlock()
+	return ip.getServer(clientIP)
+}
+
+// getServer maps clientIP onto a server. The caller must hold ip.mutex.
+func (ip *IPHash) getServer(clientIP string) (Server, error) {
+	hash, err := hashIp(clientIP, ip.prefixV4, ip.prefixV6)
+	if err != nil {
+		return Server{}, err
+	}
+	return ip.serverFor(hash)
+}
+
+// MovedFraction returns the share of clients that were mapped to a different
+// server by the most recent AddServer, RemoveServer or weight change. It is
+// estimated on the first call after the change, without blocking picks.
+func (ip *IPHash) MovedFraction() float64 {
+	ip.mutex.RLock()
+	moved := ip.moved
+	ip.mutex.RUnlock()
+	if moved == nil {
+		return 0
+	}
+	return moved.fraction()
+}
+
+// change is a membership change, whose share of moved keys is estimated when
+// first asked for.
+type change struct {
+	before, after func(hash uint32) string
+	once          sync.Once
+	moved         float64
+}
+
+// fraction returns the share of keys whose owner differs after the change.
+func (c *change) fraction() float64 {
+	c.once.Do(func() {
+		c.moved = movedFraction(c.before, c.after)
+	})
+	return c.moved
+}
+
+// changeMembership applies a change to the server list, rebuilds the lookup
+// table in table based modes and records the owners of keys before and
+// after it for MovedFraction. The caller must hold ip.mutex.
+func (ip *IPHash) changeMembership(apply func()) {
+	before := ip.owners()
+	apply()
+	if ip.build != nil {
+		ip.table = ip.build(ip.servers)
+	}
+	ip.moved = &change{before: before, after: ip.owners()}
+}
+
+// owners returns a function mapping a hash to the ID of the server owning it
+// in the current membership, ignoring conditions. It keeps working after the
+// membership changes. The caller must hold ip.mutex.
+func (ip *IPHash) owners() func(hash uint32) string {
+	if ip.table != nil {
+		t := ip.table
+		return func(hash uint32) string {
+			id, _ := t.lookup(hash, func(string) bool { return true })
+			return id
+		}
+	}
+
+	servers := append([]Server(nil), ip.servers...)
+	return func(hash uint32) string {
+		if len(servers) == 0 {
+			return ""
+		}
+		return servers[hash%uint32(len(servers))].ID
+	}
+}
 
+// serverFor returns the server owning the given hash. When that server is
+// down the next healthy server in order takes over, so only the clients of
+// the failed server move. In table based modes the table decides which
+// server takes over instead. The caller must hold ip.mutex.
+func (ip *IPHash) serverFor(hash uint32) (Server, error) {
 	var server Server
 	if len(ip.servers) == 0 {
 		return server, errors.New("no server exists")
 	}
 
-	hash := hashIp(clientIP)
-	index := hash % uint32(len(ip.servers))
-	return ip.servers[index], nil
+	if ip.table != nil {
+		id, ok := ip.table.lookup(hash, ip.conditions.Available)
+		if !ok {
+			return Server{}, errors.New("no healthy server exists")
+		}
+		return ip.servers[ip.indexOf(id)], nil
+	}
+
+	index := int(hash % uint32(len(ip.servers)))
+	for i := 0; i < len(ip.servers); i++ {
+		server = ip.servers[(index+i)%len(ip.servers)]
+		if ip.conditions.Available(server.ID) {
+			return server, nil
+		}
+	}
+	return Server{}, errors.New("no healthy server exists")
+}
+
+// describe tells where hash falls, the table slot in table based modes and
+// the bucket otherwise. The caller must hold ip.mutex.
+func (ip *IPHash) describe(hash uint32) string {
+	if ip.table != nil {
+		return ip.table.describe(hash)
+	}
+	return fmt.Sprintf("hash bucket %d of %d", hash%uint32(len(ip.servers)), len(ip.servers))
+}
+
+// hashIp generates a hash value for the given IP address, optionally with a
+// port. IPv4-mapped IPv6 addresses hash like the IPv4 address they carry and
+// only the leading prefixV4 or prefixV6 bits are hashed when set.
+func hashIp(ip string, prefixV4, prefixV6 int) (uint32, error) {
+	addr, err := parseClientIP(ip)
+	if err != nil {
+		return 0, err
+	}
+
+	bits := prefixV6
+	if addr.Is4() {
+		bits = prefixV4
+	}
+	if bits > 0 {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return 0, &lberror.InvalidAddressError{Address: ip}
+		}
+		addr = prefix.Addr()
+	}
+
+	hash := fnv.New32a()
+	hash.Write(addr.AsSlice())
+	return hash.Sum32(), nil
+}
+
+// parseClientIP parses an IP address or host:port pair into an address
+// without zone, with IPv4-mapped IPv6 addresses unmapped to IPv4.
+func parseClientIP(ip string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		addrPort, portErr := netip.ParseAddrPort(ip)
+		if portErr != nil {
+			return netip.Addr{}, &lberror.InvalidAddressError{Address: ip}
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.WithZone("").Unmap(), nil
 }
 
-// hashIp generates a hash value for the given IP address.
-func hashIp(ip string) uint32 {
+// hashKey generates a hash value for an arbitrary affinity key, such as a
+// client "ip:port" pair identifying a UDP flow.
+func hashKey(key string) uint32 {
 	hash := fnv.New32a()
-	hash.Write(net.ParseIP(ip).To4())
+	hash.Write([]byte(key))
 	return hash.Sum32()
 }
@@ -0,0 +1,100 @@
+package iphash
+
+import (
+	"errors"
+	"testing"
+
+	lberror "sysdesign/loadbalancing/error"
+)
+
+func TestHashIpAddressForms(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"IPv4 with port", "192.0.2.1", "192.0.2.1:8080"},
+		{"IPv6 with port", "2001:db8::1", "[2001:db8::1]:443"},
+		{"IPv4-mapped IPv6", "192.0.2.1", "::ffff:192.0.2.1"},
+		{"IPv4-mapped IPv6 with port", "192.0.2.1", "[::ffff:192.0.2.1]:80"},
+		{"IPv6 zone", "fe80::1", "fe80::1%eth0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := hashIp(tt.a, 0, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := hashIp(tt.b, 0, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if a != b {
+				t.Errorf("expected %s and %s to hash alike", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestHashIpDistinguishesIPv6Clients(t *testing.T) {
+	seen := make(map[uint32]string)
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2", "2001:db8:1::1", "fd00::1", "::1"} {
+		hash, err := hashIp(ip, 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if other, ok := seen[hash]; ok {
+			t.Errorf("expected %s and %s to hash differently", ip, other)
+		}
+		seen[hash] = ip
+	}
+}
+
+func TestHashIpPrefix(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"192.0.2.1", "192.0.2.200", true},
+		{"192.0.2.1", "192.0.3.1", false},
+		{"2001:db8:0:1::1", "2001:db8:0:1:ffff::9", true},
+		{"2001:db8:0:1::1", "2001:db8:0:2::1", false},
+		{"::ffff:192.0.2.1", "192.0.2.99:53", true},
+	}
+	for _, tt := range tests {
+		a, _ := hashIp(tt.a, 24, 64)
+		b, _ := hashIp(tt.b, 24, 64)
+		if (a == b) != tt.same {
+			t.Errorf("%s and %s: expected same hash %v", tt.a, tt.b, tt.same)
+		}
+	}
+}
+
+func TestGetServerInvalidAddress(t *testing.T) {
+	ip := IpHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}})
+	for _, address := range []string{"", "not-an-ip", "300.1.1.1", "192.0.2.1:port", "[2001:db8::1"} {
+		var invalid *lberror.InvalidAddressError
+		if _, err := ip.GetServer(address); !errors.As(err, &invalid) {
+			t.Errorf("%q: expected InvalidAddressError, got %v", address, err)
+		}
+	}
+}
+
+func TestSetPrefixLengths(t *testing.T) {
+	ip := IpHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}})
+	if err := ip.SetPrefixLengths(33, 64); err == nil {
+		t.Error("expected an error for an IPv4 prefix above 32")
+	}
+	if err := ip.SetPrefixLengths(24, 129); err == nil {
+		t.Error("expected an error for an IPv6 prefix above 128")
+	}
+	if err := ip.SetPrefixLengths(24, 64); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first, _ := ip.GetServer("198.51.100.1")
+	for _, address := range []string{"198.51.100.2", "198.51.100.77:1234", "::ffff:198.51.100.254"} {
+		if server, _ := ip.GetServer(address); server.ID != first.ID {
+			t.Errorf("expected %s to share the /24 server %s, got %s", address, first.ID, server.ID)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package iphash
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// DefaultTableSize is the default Maglev lookup table size. It must be prime
+// and should be well above 100 times the number of servers for an even spread.
+const DefaultTableSize = 65537
+
+// maglev is a Maglev lookup table. Every server walks the slots in its own
+// pseudo-random permutation and the servers take turns claiming the next free
+// slot of their permutation, as often per turn as their weight. This spreads
+// slots almost exactly by weight, and a membership change mostly reassigns
+// the slots of the server that joined or left.
+type maglev struct {
+	ids   []string
+	slots []int // Index into ids per slot
+}
+
+// newMaglev fills a lookup table of the given prime size for servers.
+func newMaglev(servers []Server, size int) *maglev {
+	m := &maglev{}
+	if len(servers) == 0 {
+		return m
+	}
+
+	offsets := make([]int, len(servers))
+	skips := make([]int, len(servers))
+	next := make([]int, len(servers))
+	for i, server := range servers {
+		m.ids = append(m.ids, server.ID)
+		h := hash64(server.ID)
+		offsets[i] = int(mix64(h) % uint64(size))
+		skips[i] = int(mix64(h^0x9e3779b97f4a7c15)%uint64(size-1)) + 1
+	}
+
+	m.slots = make([]int, size)
+	for i := range m.slots {
+		m.slots[i] = -1
+	}
+	for filled := 0; filled < size; {
+		for i, server := range servers {
+			weight := server.Weight
+			if weight <= 0 {
+				weight = 1
+			}
+			for turn := 0; turn < weight && filled < size; turn++ {
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for m.slots[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+				m.slots[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return m
+}
+
+// lookup returns the server of the slot hash falls into. When that server is
+// not available the following slots are tried in order, which spreads the
+// keys of an unavailable server over the others by their share of the table.
+func (m *maglev) lookup(hash uint32, available func(id string) bool) (string, bool) {
+	if len(m.slots) == 0 {
+		return "", false
+	}
+
+	start := int(hash % uint32(len(m.slots)))
+	for i := 0; i < len(m.slots); i++ {
+		id := m.ids[m.slots[(start+i)%len(m.slots)]]
+		if available(id) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// describe names the slot hash falls into.
+func (m *maglev) describe(hash uint32) string {
+	if len(m.slots) == 0 {
+		return "maglev table empty"
+	}
+	return fmt.Sprintf("maglev slot %d of %d", hash%uint32(len(m.slots)), len(m.slots))
+}
+
+// nextPrime returns the smallest prime not below n.
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := 2; d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// hash64 generates a 64 bit hash value for a server ID.
+func hash64(id string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	return hash.Sum64()
+}
+
+// mix64 spreads the bits of a 64 bit value, the splitmix64 finalizer.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
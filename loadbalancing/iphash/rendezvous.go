@@ -0,0 +1,58 @@
+package iphash
+
+import (
+	"fmt"
+	"math"
+)
+
+// rendezvous implements highest random weight hashing. Every server scores
+// every key and the key goes to the available server with the highest score,
+// so a membership change only moves the keys the joining or leaving server
+// wins. Lookups cost O(n) in the number of servers.
+type rendezvous struct {
+	ids     []string
+	seeds   []uint64
+	weights []float64
+}
+
+// newRendezvous prepares the per server seeds and weights for scoring.
+func newRendezvous(servers []Server) *rendezvous {
+	r := &rendezvous{}
+	for _, server := range servers {
+		weight := server.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		r.ids = append(r.ids, server.ID)
+		r.seeds = append(r.seeds, hash64(server.ID))
+		r.weights = append(r.weights, float64(weight))
+	}
+	return r
+}
+
+// lookup returns the available server with the highest score for hash. The
+// score is weight / -ln(u) with u uniform in (0, 1), which makes the chance
+// of winning proportional to the weight.
+func (r *rendezvous) lookup(hash uint32, available func(id string) bool) (string, bool) {
+	best, bestScore := -1, 0.0
+	for i, id := range r.ids {
+		if !available(id) {
+			continue
+		}
+		h := mix64(r.seeds[i] ^ uint64(hash)*0x9e3779b97f4a7c15)
+		u := (float64(h>>11) + 0.5) / (1 << 53)
+		score := r.weights[i] / -math.Log(u)
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return r.ids[best], true
+}
+
+// describe tells how many servers competed for hash.
+func (r *rendezvous) describe(hash uint32) string {
+	return fmt.Sprintf("highest rendezvous score of %d servers", len(r.ids))
+}
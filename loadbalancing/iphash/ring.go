@@ -0,0 +1,105 @@
+package iphash
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of virtual nodes a server of weight 1 gets
+// on the consistent hash ring.
+const DefaultReplicas = 160
+
+// vnode is one point of a server on the ring.
+type vnode struct {
+	hash uint32
+	id   string
+}
+
+// ring is a consistent hash ring. Every server owns the arcs ending at its
+// virtual nodes, so adding or removing a server only moves the keys of the
+// arcs it gains or loses. A ring is never modified once built.
+type ring struct {
+	vnodes []vnode // Sorted by hash
+}
+
+// newRing places replicas*weight virtual nodes for every server on a new ring.
+func newRing(servers []Server, replicas int) *ring {
+	r := &ring{}
+	for _, server := range servers {
+		weight := server.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < replicas*weight; i++ {
+			r.vnodes = append(r.vnodes, vnode{hash: vnodeHash(server.ID, i), id: server.ID})
+		}
+	}
+	sort.Slice(r.vnodes, func(i, j int) bool {
+		if r.vnodes[i].hash == r.vnodes[j].hash {
+			return r.vnodes[i].id < r.vnodes[j].id
+		}
+		return r.vnodes[i].hash < r.vnodes[j].hash
+	})
+	return r
+}
+
+// lookup returns the ID of the first server clockwise from hash for which
+// available returns true, found by binary search.
+func (r *ring) lookup(hash uint32, available func(id string) bool) (string, bool) {
+	if len(r.vnodes) == 0 {
+		return "", false
+	}
+
+	start := sort.Search(len(r.vnodes), func(i int) bool {
+		return r.vnodes[i].hash >= hash
+	})
+	for i := 0; i < len(r.vnodes); i++ {
+		v := r.vnodes[(start+i)%len(r.vnodes)]
+		if available(v.id) {
+			return v.id, true
+		}
+	}
+	return "", false
+}
+
+// describe names the point on the ring hash falls on.
+func (r *ring) describe(hash uint32) string {
+	return fmt.Sprintf("ring hash %08x of %d points", hash, len(r.vnodes))
+}
+
+// vnodeHash places the i-th virtual node of a server on the ring.
+func vnodeHash(id string, i int) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(id + "#" + strconv.Itoa(i)))
+	return mix(hash.Sum32())
+}
+
+// mix spreads the bits of an FNV hash, whose outputs for keys differing only
+// in the last characters cluster on the ring.
+func mix(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// movedSamples is the number of hashes movedFraction compares.
+const movedSamples = 1 << 16
+
+// movedFraction estimates the share of the hash space whose owner differs
+// between before and after. The samples are scrambled rather than evenly
+// spaced, since evenly spaced hashes line up with the slots of a Maglev table.
+func movedFraction(before, after func(hash uint32) string) float64 {
+	moved := 0
+	for i := uint32(0); i < movedSamples; i++ {
+		hash := mix(i)
+		if before(hash) != after(hash) {
+			moved++
+		}
+	}
+	return float64(moved) / movedSamples
+}
@@ -0,0 +1,142 @@
+package iphash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+func clientIPs(n int) []string {
+	ips := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ips = append(ips, fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff))
+	}
+	return ips
+}
+
+func assignments(t *testing.T, ip *IPHash, ips []string) map[string]string {
+	t.Helper()
+	assigned := make(map[string]string, len(ips))
+	for _, clientIP := range ips {
+		server, err := ip.GetServer(clientIP)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assigned[clientIP] = server.ID
+	}
+	return assigned
+}
+
+func TestConsistentHashMovesFewKeys(t *testing.T) {
+	ips := clientIPs(10000)
+	ring := ConsistentHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}}, 0)
+	modulo := IpHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	ringBefore := assignments(t, ring, ips)
+	moduloBefore := assignments(t, modulo, ips)
+
+	ring.AddServer(&Server{ID: "d"})
+	modulo.AddServer(&Server{ID: "d"})
+
+	if moved := ring.MovedFraction(); math.Abs(moved-0.25) > 0.06 {
+		t.Errorf("expected about a quarter of the ring to move, got %.3f", moved)
+	}
+	if moved := modulo.MovedFraction(); moved < 0.6 {
+		t.Errorf("expected most keys to move with hash modulo, got %.3f", moved)
+	}
+
+	// Clients either stay or move to the new server, never between old ones.
+	for clientIP, server := range assignments(t, ring, ips) {
+		if before := ringBefore[clientIP]; server != before && server != "d" {
+			t.Fatalf("client %s moved from %s to %s", clientIP, before, server)
+		}
+	}
+
+	moduloMoved := 0
+	for clientIP, server := range assignments(t, modulo, ips) {
+		if moduloBefore[clientIP] != server {
+			moduloMoved++
+		}
+	}
+	if moduloMoved < len(ips)/2 {
+		t.Errorf("expected hash modulo to remap most clients, got %d of %d", moduloMoved, len(ips))
+	}
+}
+
+func TestConsistentHashRemoveOnlyMovesRemovedClients(t *testing.T) {
+	ips := clientIPs(5000)
+	ip := ConsistentHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}, 0)
+	before := assignments(t, ip, ips)
+
+	if err := ip.RemoveServer("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for clientIP, server := range assignments(t, ip, ips) {
+		if before[clientIP] != "b" && before[clientIP] != server {
+			t.Fatalf("client %s of a remaining server moved from %s to %s", clientIP, before[clientIP], server)
+		}
+	}
+	if moved := ip.MovedFraction(); math.Abs(moved-0.25) > 0.06 {
+		t.Errorf("expected about a quarter of the ring to move, got %.3f", moved)
+	}
+}
+
+func TestConsistentHashWeights(t *testing.T) {
+	ip := ConsistentHashLoadBalancer([]Server{{ID: "a", Weight: 1}, {ID: "b", Weight: 3}}, 0)
+
+	counts := make(map[string]int)
+	for _, server := range assignments(t, ip, clientIPs(20000)) {
+		counts[server]++
+	}
+	share := float64(counts["b"]) / 20000
+	if math.Abs(share-0.75) > 0.06 {
+		t.Errorf("expected b to serve about 75%% of clients, got %.3f", share)
+	}
+}
+
+func TestConsistentHashSkipsDownServers(t *testing.T) {
+	ips := clientIPs(2000)
+	ip := ConsistentHashLoadBalancer([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}}, 0)
+	before := assignments(t, ip, ips)
+
+	ip.conditions.Set("a", balancer.ConditionDown, true)
+	for clientIP, server := range assignments(t, ip, ips) {
+		if server == "a" {
+			t.Fatalf("client %s was sent to the down server", clientIP)
+		}
+		if before[clientIP] != "a" && before[clientIP] != server {
+			t.Fatalf("client %s of a healthy server moved from %s to %s", clientIP, before[clientIP], server)
+		}
+	}
+
+	ip.conditions.Set("a", balancer.ConditionDown, false)
+	for clientIP, server := range assignments(t, ip, ips) {
+		if before[clientIP] != server {
+			t.Fatalf("client %s did not return to %s", clientIP, before[clientIP])
+		}
+	}
+}
+
+func TestRingLookupWraps(t *testing.T) {
+	r := &ring{vnodes: []vnode{{hash: 100, id: "a"}, {hash: 200, id: "b"}}}
+	all := func(string) bool { return true }
+
+	tests := []struct {
+		hash uint32
+		want string
+	}{
+		{50, "a"},
+		{100, "a"},
+		{150, "b"},
+		{250, "a"},
+	}
+	for _, tt := range tests {
+		if got, _ := r.lookup(tt.hash, all); got != tt.want {
+			t.Errorf("lookup(%d): expected %s, got %s", tt.hash, tt.want, got)
+		}
+	}
+	if _, ok := (&ring{}).lookup(1, all); ok {
+		t.Error("expected lookup on an empty ring to fail")
+	}
+}
@@ -0,0 +1,90 @@
+package iphash
+
+import (
+	"math"
+	"testing"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// tableModes builds every table based mode for the given servers.
+var tableModes = map[string]func(servers []Server) *IPHash{
+	ConsistentHashName: func(servers []Server) *IPHash { return ConsistentHashLoadBalancer(servers, 0) },
+	MaglevName:         func(servers []Server) *IPHash { return MaglevLoadBalancer(servers, 0) },
+	RendezvousName:     func(servers []Server) *IPHash { return RendezvousLoadBalancer(servers) },
+}
+
+func TestTableModesSpreadByWeight(t *testing.T) {
+	for name, build := range tableModes {
+		t.Run(name, func(t *testing.T) {
+			ip := build([]Server{{ID: "a", Weight: 1}, {ID: "b", Weight: 2}, {ID: "c", Weight: 1}})
+
+			counts := make(map[string]int)
+			for _, server := range assignments(t, ip, clientIPs(40000)) {
+				counts[server]++
+			}
+			for id, want := range map[string]float64{"a": 0.25, "b": 0.5, "c": 0.25} {
+				if got := float64(counts[id]) / 40000; math.Abs(got-want) > 0.05 {
+					t.Errorf("expected %s to serve about %.2f of clients, got %.3f", id, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTableModesMoveFewKeys(t *testing.T) {
+	for name, build := range tableModes {
+		t.Run(name, func(t *testing.T) {
+			ips := clientIPs(10000)
+			ip := build([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}})
+			before := assignments(t, ip, ips)
+
+			ip.AddServer(&Server{ID: "e"})
+			if moved := ip.MovedFraction(); math.Abs(moved-0.2) > 0.06 {
+				t.Errorf("expected about a fifth of the keys to move on add, got %.3f", moved)
+			}
+
+			if err := ip.RemoveServer("e"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for clientIP, server := range assignments(t, ip, ips) {
+				if before[clientIP] != server {
+					t.Fatalf("client %s did not return to %s after the change was undone", clientIP, before[clientIP])
+				}
+			}
+		})
+	}
+}
+
+func TestTableModesSkipUnavailableServers(t *testing.T) {
+	for name, build := range tableModes {
+		t.Run(name, func(t *testing.T) {
+			ips := clientIPs(2000)
+			ip := build([]Server{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+			before := assignments(t, ip, ips)
+
+			ip.conditions.Set("b", balancer.ConditionEjected, true)
+			for clientIP, server := range assignments(t, ip, ips) {
+				if server == "b" {
+					t.Fatalf("client %s was sent to the ejected server", clientIP)
+				}
+				if before[clientIP] != "b" && before[clientIP] != server {
+					t.Fatalf("client %s of an available server moved from %s to %s", clientIP, before[clientIP], server)
+				}
+			}
+
+			ip.conditions.Set("a", balancer.ConditionDown, true)
+			ip.conditions.Set("c", balancer.ConditionDown, true)
+			if _, err := ip.GetServer("10.0.0.1"); err == nil {
+				t.Error("expected an error when no server is available")
+			}
+		})
+	}
+}
+
+func TestMaglevTableSizeIsPrime(t *testing.T) {
+	ip := MaglevLoadBalancer([]Server{{ID: "a"}, {ID: "b"}}, 1000)
+	if got := len(ip.table.(*maglev).slots); got != 1009 {
+		t.Errorf("expected the table size to be rounded up to 1009, got %d", got)
+	}
+}
@@ -0,0 +1,209 @@
+package leastconnection
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the least connection algorithm in configuration.
+const Name = "least_connection"
+
+var (
+	_ balancer.Balancer    = (*LeastConnection)(nil)
+	_ balancer.SlowStarter = (*LeastConnection)(nil)
+)
+
+// Name returns the algorithm identifier.
+func (lc *LeastConnection) Name() string {
+	return Name
+}
+
+// Pick returns the server with the fewest active connections and charges it
+// one connection. The request is only used to explain the pick.
+func (lc *LeastConnection) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	server, err := lc.nextServer()
+	if err != nil {
+		return nil, err
+	}
+	if req.Explained() {
+		req.Reason = fmt.Sprintf("least connections=%d", server.Connections-1)
+	}
+	return lc.backendFor(server), nil
+}
+
+// Release frees the connection charged to the backend by Pick.
+func (lc *LeastConnection) Release(backend *balancer.Backend) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if server := lc.find(backend.ID); server != nil && server.Connections > 0 {
+		lc.releaseServer(server)
+	}
+}
+
+// AddBackend pushes a new server with no connections onto the heap, in slow
+// start if enabled.
+func (lc *LeastConnection) AddBackend(backend balancer.Backend) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if lc.find(backend.ID) != nil {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+
+	if lc.backends == nil {
+		lc.backends = make(map[string]*balancer.Backend)
+	}
+	lc.backends[backend.ID] = &backend
+	lc.addServer(&Server{ID: backend.ID})
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID from the heap.
+func (lc *LeastConnection) RemoveBackend(id string) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if !lc.removeServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain takes the server off the heap and removes it once its last
+// connection is released.
+func (lc *LeastConnection) Drain(id string) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if !lc.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain pushes the draining server back onto the heap with the
+// connections it still holds.
+func (lc *LeastConnection) Undrain(id string) error {
+	return lc.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetWeight records the new weight. Least connection ignores weights when picking.
+func (lc *LeastConnection) SetWeight(id string, weight int) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	server := lc.find(id)
+	if server == nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+
+	updated := *lc.backendFor(server)
+	updated.Weight = weight
+	lc.backends[id] = &updated
+	return nil
+}
+
+// SetHealthy takes the server off the heap while it is down and pushes it
+// back, with the connections it still holds, once it is up again.
+func (lc *LeastConnection) SetHealthy(id string, healthy bool) error {
+	return lc.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server off the heap while it is ejected and pushes it
+// back, with the connections it still holds, once it is readmitted.
+func (lc *LeastConnection) SetEjected(id string, ejected bool) error {
+	return lc.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (lc *LeastConnection) setCondition(id string, condition balancer.Condition, on bool) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	server := lc.find(id)
+	if server == nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	lc.conditions.Set(id, condition, on)
+	lc.refresh(server)
+	return nil
+}
+
+// Snapshot returns every server ordered by ID.
+func (lc *LeastConnection) Snapshot() []balancer.BackendStatus {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(lc.Servers)+len(lc.parked))
+	for _, server := range lc.all() {
+		statuses = append(statuses, lc.conditions.Status(balancer.BackendStatus{
+			Backend:     *lc.backendFor(server),
+			Connections: server.Connections,
+		}))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// find returns the server with the given ID, whether it is on the heap or
+// parked, or nil. The caller must hold lc.mutex.
+func (lc *LeastConnection) find(id string) *Server {
+	for _, server := range lc.Servers {
+		if server.ID == id {
+			return server
+		}
+	}
+	return lc.parked[id]
+}
+
+// all returns the servers on the heap followed by the parked ones.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) all() []*Server {
+	servers := append([]*Server(nil), lc.Servers...)
+	for _, server := range lc.parked {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// refresh pushes server onto the heap when it may be picked, starting its
+// slow start, and parks it otherwise. The caller must hold lc.mutex.
+func (lc *LeastConnection) refresh(server *Server) {
+	available := lc.conditions.Available(server.ID)
+	_, parked := lc.parked[server.ID]
+
+	switch {
+	case available && parked:
+		delete(lc.parked, server.ID)
+		lc.addServer(server)
+	case !available && !parked:
+		heap.Remove(&lc.Servers, server.index)
+		if lc.parked == nil {
+			lc.parked = make(map[string]*Server)
+		}
+		lc.parked[server.ID] = server
+	}
+}
+
+// backendFor returns the backend registered for server. Servers passed to
+// LeastConnectionLoadBalancer have no backend yet, so one is created using the
+// server ID as the address. The caller must hold lc.mutex.
+func (lc *LeastConnection) backendFor(server *Server) *balancer.Backend {
+	if b, ok := lc.backends[server.ID]; ok {
+		return b
+	}
+	if lc.backends == nil {
+		lc.backends = make(map[string]*balancer.Backend)
+	}
+	b := &balancer.Backend{ID: server.ID, Address: server.ID, Weight: 1}
+	lc.backends[server.ID] = b
+	return b
+}
@@ -3,6 +3,9 @@ package leastconnection
 import (
 	"container/heap"
 	"errors"
+	"sync"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 // LeastConnection represents a least connection load balancer.
@@ -10,6 +13,12 @@ import (
 // the least number of active connections is always at the top.
 type LeastConnection struct {
 	Servers ServerQueue
+	mutex   sync.Mutex
+
+	backends   map[string]*balancer.Backend // Backend details keyed by server ID
+	parked     map[string]*Server           // Servers taken out of the heap, keyed by ID
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
+	slowStarts balancer.SlowStarts          // When servers began ramping up, keyed by ID
 }
 
 // LeastConnectionLoadBalancer creates and initializes a new LeastConnection load balancer.
@@ -41,9 +50,29 @@ func LeastConnectionLoadBalancer(servers []Server) *LeastConnection {
 //   - *Server: A pointer to the selected server with the least connections.
 //   - error: An error if no servers are available.
 func (lc *LeastConnection) GetNextServer() (*Server, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	return lc.nextServer()
+}
+
+// SetSlowStart makes servers that are added or come back up take a growing
+// share of connections over the configured window instead of all new ones.
+func (lc *LeastConnection) SetSlowStart(config balancer.SlowStart) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.slowStarts.Configure(config)
+	lc.rescale()
+}
+
+// nextServer pops the least loaded server, charges it one connection and
+// pushes it back. The caller must hold lc.mutex.
+func (lc *LeastConnection) nextServer() (*Server, error) {
 	if lc.Servers.Len() == 0 {
 		return nil, errors.New("no servers available")
 	}
+	if lc.slowStarts.Ramping() {
+		lc.rescale()
+	}
 
 	maxConnServer := heap.Pop(&lc.Servers).(*Server)
 	maxConnServer.AddConnection()
@@ -57,6 +86,108 @@ func (lc *LeastConnection) GetNextServer() (*Server, error) {
 // Parameters:
 //   - server: A pointer to the Server being released.
 func (lc *LeastConnection) ReleaseServer(server *Server) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.releaseServer(server)
+}
+
+// releaseServer frees one connection of server and restores the heap order.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) releaseServer(server *Server) {
 	server.FreeConnection()
-	heap.Fix(&lc.Servers, server.index)
+	if server.index >= 0 {
+		heap.Fix(&lc.Servers, server.index)
+	}
+	if server.Connections <= 0 && lc.conditions.Has(server.ID, balancer.ConditionDraining) {
+		lc.removeServer(server.ID)
+	}
+}
+
+// AddServer pushes a new server onto the heap, keeping the connections it
+// already has, and starts its slow start if enabled.
+// Returns an error if a server with the same ID exists.
+func (lc *LeastConnection) AddServer(server *Server) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.find(server.ID) != nil {
+		return errors.New("server already exists")
+	}
+	lc.addServer(server)
+	return nil
+}
+
+// addServer pushes server onto the heap and starts its slow start.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) addServer(server *Server) {
+	lc.slowStarts.Begin(server.ID)
+	server.share = lc.slowStarts.Factor(server.ID)
+	heap.Push(&lc.Servers, server)
+}
+
+// rescale updates the share of every server on the heap from its slow start
+// and restores the heap order, which the changed shares may have broken.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) rescale() {
+	for _, server := range lc.Servers {
+		server.share = lc.slowStarts.Factor(server.ID)
+	}
+	heap.Init(&lc.Servers)
+}
+
+// RemoveServer removes the server with the given ID, whatever connections it
+// still has. Returns an error if the server is not found.
+func (lc *LeastConnection) RemoveServer(id string) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if !lc.removeServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer takes the server with the given ID off the heap so it gets no
+// new connections, and removes it once ReleaseServer has brought its
+// connections to zero, at once if it has none.
+// Returns an error if the server is not found.
+func (lc *LeastConnection) DrainServer(id string) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if !lc.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// removeServer deletes the server from the heap or the parked servers along
+// with everything recorded about it. It reports whether the server was found.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) removeServer(id string) bool {
+	server := lc.find(id)
+	if server == nil {
+		return false
+	}
+	if server.index >= 0 {
+		heap.Remove(&lc.Servers, server.index)
+	}
+	delete(lc.parked, id)
+	delete(lc.backends, id)
+	lc.conditions.Forget(id)
+	lc.slowStarts.Forget(id)
+	return true
+}
+
+// drainServer marks the server as draining, which parks it, and removes it if
+// it has no connections left. It reports whether the server was found.
+// The caller must hold lc.mutex.
+func (lc *LeastConnection) drainServer(id string) bool {
+	server := lc.find(id)
+	if server == nil {
+		return false
+	}
+	lc.conditions.Set(id, balancer.ConditionDraining, true)
+	lc.refresh(server)
+	if server.Connections <= 0 {
+		lc.removeServer(id)
+	}
+	return true
 }
@@ -2,6 +2,9 @@ package leastconnection
 
 import (
 	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 // TestLeastConnectionLoadBalancer tests the creation of a new load balancer
@@ -141,3 +144,105 @@ func TestLoadBalancerIntegration(t *testing.T) {
 		t.Errorf("Expected server1, got %s", server.ID)
 	}
 }
+
+// TestAverageLifetime tests that the time connections stay open is tracked
+func TestAverageLifetime(t *testing.T) {
+	server := &Server{ID: "server1"}
+	server.AddConnection()
+	server.AddConnection()
+	time.Sleep(20 * time.Millisecond)
+	server.FreeConnection()
+	server.FreeConnection()
+
+	if server.Completed != 2 {
+		t.Errorf("Expected 2 completed connections, got %d", server.Completed)
+	}
+	if got := server.AverageLifetime(); got < 20*time.Millisecond {
+		t.Errorf("Expected an average lifetime of at least 20ms, got %v", got)
+	}
+}
+
+// TestDrainServer tests that a draining server keeps its connections but
+// gets no new ones, and leaves once they are released
+func TestDrainServer(t *testing.T) {
+	lb := LeastConnectionLoadBalancer([]Server{
+		{ID: "server1", Connections: 1},
+		{ID: "server2", Connections: 3},
+	})
+
+	if err := lb.DrainServer("server1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server, err := lb.GetNextServer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.ID != "server2" {
+		t.Errorf("Expected draining server1 to be skipped, got %s", server.ID)
+	}
+
+	drained := lb.find("server1")
+	if drained == nil {
+		t.Fatal("Expected server1 to stay while it has connections")
+	}
+	lb.ReleaseServer(drained)
+	if lb.find("server1") != nil {
+		t.Error("Expected server1 to be removed after its last connection")
+	}
+	if err := lb.DrainServer("server1"); err == nil {
+		t.Error("Expected error draining a removed server, got nil")
+	}
+}
+
+// TestAddRemoveServer tests runtime membership changes
+func TestAddRemoveServer(t *testing.T) {
+	lb := LeastConnectionLoadBalancer([]Server{{ID: "server1", Connections: 2}})
+
+	if err := lb.AddServer(&Server{ID: "server2"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := lb.AddServer(&Server{ID: "server2"}); err == nil {
+		t.Error("Expected error adding a duplicate server, got nil")
+	}
+	if server, _ := lb.GetNextServer(); server.ID != "server2" {
+		t.Errorf("Expected the new idle server2, got %s", server.ID)
+	}
+
+	if err := lb.RemoveServer("server2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := lb.RemoveServer("server2"); err == nil {
+		t.Error("Expected error removing a missing server, got nil")
+	}
+	if server, _ := lb.GetNextServer(); server.ID != "server1" {
+		t.Errorf("Expected server1 as the only server, got %s", server.ID)
+	}
+}
+
+// TestSlowStart tests that a new server does not take every new connection
+// while it ramps up
+func TestSlowStart(t *testing.T) {
+	now := time.Unix(0, 0)
+	lb := LeastConnectionLoadBalancer([]Server{{ID: "server1", Connections: 10}})
+	lb.SetSlowStart(balancer.SlowStart{Window: 10 * time.Second, Now: func() time.Time { return now }})
+	if err := lb.AddServer(&Server{ID: "server2"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	picks := 0
+	for i := 0; i < 10; i++ {
+		server, _ := lb.GetNextServer()
+		if server.ID == "server2" {
+			picks++
+		}
+	}
+	if picks > 2 {
+		t.Errorf("Expected server2 to take a tenth of server1's connections, got %d of 10 picks", picks)
+	}
+
+	now = now.Add(10 * time.Second)
+	server, _ := lb.GetNextServer()
+	if server.ID != "server2" {
+		t.Errorf("Expected the ramped up server2 with fewer connections, got %s", server.ID)
+	}
+}
@@ -3,25 +3,66 @@
 // connections based on the number of active connections each server has.
 package leastconnection
 
+import "time"
+
 // Server represents a server in the load balancing pool.
 type Server struct {
-	ID          string // Unique identifier for the server
-	Connections int    // Number of active connections to this server
-	index       int    // Index of the server in the heap (used internally)
+	ID          string  // Unique identifier for the server
+	Connections int     // Number of active connections to this server
+	index       int     // Index of the server in the heap (used internally)
+	share       float64 // Fraction of a full share of connections during slow start, 0 counts as 1
+
+	Completed      int           // Number of connections closed so far
+	ConnectionTime time.Duration // Sum of the time every connection has been open, up to the last change
+	lastChange     time.Time     // When Connections last changed
 }
 
 // FreeConnection decrements the number of active connections for the server.
 // This method should be called when a connection to the server is closed.
 func (s *Server) FreeConnection() {
+	s.accumulate(time.Now())
 	s.Connections--
+	s.Completed++
 }
 
 // AddConnection increments the number of active connections for the server.
 // This method should be called when a new connection is established with the server.
 func (s *Server) AddConnection() {
+	s.accumulate(time.Now())
 	s.Connections++
 }
 
+// AverageLifetime returns how long a connection stays open on average.
+// It relies on AddConnection and FreeConnection being called when the
+// connection really opens and closes, not merely when the server is picked.
+// Time already spent by connections that are still open is included, so the
+// value is exact only when Connections is zero.
+func (s *Server) AverageLifetime() time.Duration {
+	if s.Completed == 0 {
+		return 0
+	}
+	return s.ConnectionTime / time.Duration(s.Completed)
+}
+
+// accumulate adds the time every open connection has been alive since the
+// last change to ConnectionTime. By Little's law the total equals the sum of
+// the individual connection lifetimes once they are all closed.
+func (s *Server) accumulate(now time.Time) {
+	if !s.lastChange.IsZero() && s.Connections > 0 {
+		s.ConnectionTime += time.Duration(s.Connections) * now.Sub(s.lastChange)
+	}
+	s.lastChange = now
+}
+
+// load returns the connections of the server relative to its share, which
+// is its connection count unless it is in slow start.
+func (s *Server) load() float64 {
+	if s.share <= 0 {
+		return float64(s.Connections)
+	}
+	return float64(s.Connections) / s.share
+}
+
 // ServerQueue is a priority queue of servers, implemented as a min-heap.
 // The server with the least number of connections is always at the top of the heap.
 type ServerQueue []*Server
@@ -32,10 +73,10 @@ func (sq ServerQueue) Len() int {
 	return len(sq)
 }
 
-// Less compares two servers based on their number of connections.
-// This method is part of the sort.Interface implementation.
+// Less compares two servers based on their number of connections, scaled up
+// for servers in slow start. This method is part of the sort.Interface implementation.
 func (sq ServerQueue) Less(i int, j int) bool {
-	return sq[i].Connections < sq[j].Connections
+	return sq[i].load() < sq[j].load()
 }
 
 // Swap exchanges the positions of two servers in the queue.
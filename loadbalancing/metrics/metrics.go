@@ -0,0 +1,392 @@
+// Package metrics exports balancer and proxy internals in the Prometheus
+// text exposition format.
+//
+// Counters and latency histograms are recorded by wrapping the balancer of
+// every pool with Registry.Instrument. Gauges, such as active connections,
+// weights and health, are read from the balancers when /metrics is scraped,
+// so they always match what the balancers use to pick. Comparing the share
+// of picks of every backend with its share of the weights shows whether a
+// weighted algorithm follows its configured ratios:
+//
+//	rate(lb_backend_picks_total{pool="web"}[5m])
+//	  / ignoring(backend, algorithm) group_left sum(rate(lb_backend_picks_total{pool="web"}[5m]))
+//
+//	lb_backend_weight{pool="web"} / ignoring(backend) group_left sum(lb_backend_weight{pool="web"})
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/proxy"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histogram buckets.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Traffic is what one listener relayed for one backend.
+type Traffic struct {
+	Listener string
+	Protocol string
+	Backend  string
+	proxy.TrafficStats
+}
+
+// Source is the running state read on every scrape. *server.Server implements it.
+type Source interface {
+	// Pools returns the names of the running pools, sorted.
+	Pools() []string
+	// Balancer returns the balancer of the named pool.
+	Balancer(pool string) (balancer.Balancer, error)
+	// Traffic returns the traffic every listener relayed so far.
+	Traffic() []Traffic
+}
+
+// backendKey identifies a backend of a pool.
+type backendKey struct {
+	pool, backend string
+}
+
+// series holds the counters and latency histogram of one backend. Its fields
+// are updated atomically, so recording never waits for other backends or
+// for a scrape.
+type series struct {
+	picks     sync.Map // Algorithm name to *atomic.Uint64; a pool whose algorithm is swapped by a reload keeps the counts of the old one apart
+	failures  atomic.Uint64
+	ejections atomic.Uint64
+	latency   histogram
+
+	misses int // Scrapes in a row that did not find the backend in its pool, guarded by Registry.scrape
+}
+
+// countPick counts a pick of the backend by the named algorithm.
+func (s *series) countPick(algorithm string) {
+	count, ok := s.picks.Load(algorithm)
+	if !ok {
+		count, _ = s.picks.LoadOrStore(algorithm, new(atomic.Uint64))
+	}
+	count.(*atomic.Uint64).Add(1)
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	counts []atomic.Uint64 // One per LatencyBuckets bound
+	count  atomic.Uint64
+	sum    atomic.Uint64 // math.Float64bits of the sum
+}
+
+// observe records v. The buckets are updated before the count, so a scrape
+// reading the buckets first never sees a bucket above the count.
+func (h *histogram) observe(v float64) {
+	for i, bound := range LatencyBuckets {
+		if v <= bound {
+			h.counts[i].Add(1)
+		}
+	}
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	h.count.Add(1)
+}
+
+// Registry holds the counters and histograms of every pool. The series of
+// a backend are dropped once two scrapes in a row did not find the backend
+// in its pool, so removed backends and pools do not accumulate.
+type Registry struct {
+	backends sync.Map   // backendKey to *series
+	scrape   sync.Mutex // Serializes scrapes
+}
+
+// NewRegistry creates an empty Registry.
+//
+// Returns:
+//   - *Registry: A pointer to the new Registry instance
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// series returns the series of the backend of the pool, creating them if needed.
+func (r *Registry) series(pool, backend string) *series {
+	key := backendKey{pool, backend}
+	s, ok := r.backends.Load(key)
+	if !ok {
+		s, _ = r.backends.LoadOrStore(key, &series{latency: histogram{counts: make([]atomic.Uint64, len(LatencyBuckets))}})
+	}
+	return s.(*series)
+}
+
+// Instrument returns b wrapped so that picks and ejections are counted for
+// the named pool. Install it as an Observer of the proxies routing to the
+// pool to record errors and latencies too.
+func (r *Registry) Instrument(pool string, b balancer.Balancer) *Instrumented {
+	return &Instrumented{Balancer: b, registry: r, pool: pool}
+}
+
+// Instrumented is a balancer whose picks, outcomes and ejections are recorded
+// in a Registry. It forwards every call to the balancer it wraps.
+type Instrumented struct {
+	balancer.Balancer
+	registry *Registry
+	pool     string
+}
+
+var (
+	_ balancer.Balancer = (*Instrumented)(nil)
+	_ balancer.Observer = (*Instrumented)(nil)
+)
+
+// Pick picks from the wrapped balancer and counts the pick.
+func (i *Instrumented) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	backend, err := i.Balancer.Pick(req)
+	if err != nil {
+		return nil, err
+	}
+	i.registry.series(i.pool, backend.ID).countPick(i.Balancer.Name())
+	return backend, nil
+}
+
+// SetEjected forwards to the wrapped balancer and counts ejections.
+func (i *Instrumented) SetEjected(id string, ejected bool) error {
+	if err := i.Balancer.SetEjected(id, ejected); err != nil {
+		return err
+	}
+	if ejected {
+		i.registry.series(i.pool, id).ejections.Add(1)
+	}
+	return nil
+}
+
+// Observe records the latency of the outcome and counts it if it failed.
+// Outcomes without a backend are ignored.
+func (i *Instrumented) Observe(outcome balancer.Outcome) {
+	if outcome.Backend == nil {
+		return
+	}
+	s := i.registry.series(i.pool, outcome.Backend.ID)
+	if outcome.Failed() {
+		s.failures.Add(1)
+	}
+	s.latency.observe(outcome.Latency.Seconds())
+}
+
+// Handler returns an http.Handler serving the metrics of the registry and
+// the current state of source.
+func (r *Registry) Handler(source Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		r.Write(buf, source)
+		buf.Flush()
+	})
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func (r *Registry) Write(w *bufio.Writer, source Source) {
+	r.scrape.Lock()
+	defer r.scrape.Unlock()
+
+	live := r.writeGauges(w, source)
+	r.writeTraffic(w, source)
+	r.prune(live)
+
+	type entry struct {
+		backendKey
+		*series
+	}
+	var entries []entry
+	r.backends.Range(func(key, s any) bool {
+		entries = append(entries, entry{key.(backendKey), s.(*series)})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.pool != b.pool {
+			return a.pool < b.pool
+		}
+		return a.backend < b.backend
+	})
+
+	header(w, "lb_backend_picks_total", "counter", "Times the balancer picked the backend, by algorithm.")
+	for _, e := range entries {
+		var algorithms []string
+		e.picks.Range(func(algorithm, _ any) bool {
+			algorithms = append(algorithms, algorithm.(string))
+			return true
+		})
+		sort.Strings(algorithms)
+		for _, algorithm := range algorithms {
+			count, _ := e.picks.Load(algorithm)
+			sample(w, "lb_backend_picks_total", float64(count.(*atomic.Uint64).Load()), "pool", e.pool, "algorithm", algorithm, "backend", e.backend)
+		}
+	}
+
+	header(w, "lb_backend_errors_total", "counter", "Requests or connections to the backend that failed or answered with a 5xx status.")
+	for _, e := range entries {
+		sample(w, "lb_backend_errors_total", float64(e.failures.Load()), "pool", e.pool, "backend", e.backend)
+	}
+
+	header(w, "lb_backend_ejections_total", "counter", "Times outlier detection ejected the backend.")
+	for _, e := range entries {
+		sample(w, "lb_backend_ejections_total", float64(e.ejections.Load()), "pool", e.pool, "backend", e.backend)
+	}
+
+	header(w, "lb_backend_latency_seconds", "histogram", "Time until the backend answered a request or accepted a connection.")
+	for _, e := range entries {
+		h := &e.latency
+		for i, bound := range LatencyBuckets {
+			sample(w, "lb_backend_latency_seconds_bucket", float64(h.counts[i].Load()), "pool", e.pool, "backend", e.backend, "le", formatValue(bound))
+		}
+		sum := math.Float64frombits(h.sum.Load())
+		count := float64(h.count.Load())
+		sample(w, "lb_backend_latency_seconds_bucket", count, "pool", e.pool, "backend", e.backend, "le", "+Inf")
+		sample(w, "lb_backend_latency_seconds_sum", sum, "pool", e.pool, "backend", e.backend)
+		sample(w, "lb_backend_latency_seconds_count", count, "pool", e.pool, "backend", e.backend)
+	}
+}
+
+// prune drops the series of backends missing from live, the backends found
+// in their pool by this scrape, for the second scrape in a row. Waiting for a
+// second scrape keeps the series of a backend added while this one read the
+// balancers. The caller must hold r.scrape.
+func (r *Registry) prune(live map[backendKey]bool) {
+	r.backends.Range(func(key, value any) bool {
+		s := value.(*series)
+		switch {
+		case live[key.(backendKey)]:
+			s.misses = 0
+		case s.misses > 0:
+			r.backends.Delete(key)
+		default:
+			s.misses++
+		}
+		return true
+	})
+}
+
+// writeGauges writes the state of every backend as the balancers see it now
+// and returns the backends it found.
+func (r *Registry) writeGauges(w *bufio.Writer, source Source) map[backendKey]bool {
+	type pool struct {
+		name, algorithm string
+		backends        []balancer.BackendStatus
+	}
+	var pools []pool
+	for _, name := range source.Pools() {
+		b, err := source.Balancer(name)
+		if err != nil {
+			continue // Removed by a reload since it was listed
+		}
+		backends := b.Snapshot()
+		sort.Slice(backends, func(i, j int) bool { return backends[i].ID < backends[j].ID })
+		pools = append(pools, pool{name: name, algorithm: b.Name(), backends: backends})
+	}
+
+	live := make(map[backendKey]bool)
+	for _, p := range pools {
+		for _, s := range p.backends {
+			live[backendKey{p.name, s.ID}] = true
+		}
+	}
+
+	header(w, "lb_pool_info", "gauge", "Algorithm the pool balances with, always 1.")
+	for _, p := range pools {
+		sample(w, "lb_pool_info", 1, "pool", p.name, "algorithm", p.algorithm)
+	}
+
+	gauges := []struct {
+		name, help string
+		value      func(balancer.BackendStatus) float64
+	}{
+		{"lb_backend_active_connections", "Picks of the backend not released yet.", func(s balancer.BackendStatus) float64 { return float64(s.Connections) }},
+		{"lb_backend_weight", "Configured weight of the backend.", func(s balancer.BackendStatus) float64 { return float64(s.Weight) }},
+		{"lb_backend_healthy", "1 while health checks report the backend up.", func(s balancer.BackendStatus) float64 { return boolValue(s.Healthy) }},
+		{"lb_backend_ejected", "1 while outlier detection keeps the backend out.", func(s balancer.BackendStatus) float64 { return boolValue(s.Ejected) }},
+		{"lb_backend_draining", "1 while the backend drains before its removal.", func(s balancer.BackendStatus) float64 { return boolValue(s.Draining) }},
+	}
+	for _, g := range gauges {
+		header(w, g.name, "gauge", g.help)
+		for _, p := range pools {
+			for _, s := range p.backends {
+				sample(w, g.name, g.value(s), "pool", p.name, "backend", s.ID)
+			}
+		}
+	}
+	return live
+}
+
+// writeTraffic writes the requests, connections and bytes every listener relayed.
+func (r *Registry) writeTraffic(w *bufio.Writer, source Source) {
+	traffic := source.Traffic()
+	sort.Slice(traffic, func(i, j int) bool {
+		if traffic[i].Listener != traffic[j].Listener {
+			return traffic[i].Listener < traffic[j].Listener
+		}
+		return traffic[i].Backend < traffic[j].Backend
+	})
+
+	counters := []struct {
+		name, help string
+		value      func(Traffic) int64
+	}{
+		{"lb_listener_connections_total", "HTTP requests or TCP connections the listener relayed to the backend.", func(t Traffic) int64 { return t.Connections }},
+		{"lb_listener_sent_bytes_total", "Bytes the listener copied from clients to the backend.", func(t Traffic) int64 { return t.BytesSent }},
+		{"lb_listener_received_bytes_total", "Bytes the listener copied from the backend to clients.", func(t Traffic) int64 { return t.BytesReceived }},
+	}
+	for _, c := range counters {
+		header(w, c.name, "counter", c.help)
+		for _, t := range traffic {
+			sample(w, c.name, float64(c.value(t)), "listener", t.Listener, "protocol", t.Protocol, "backend", t.Backend)
+		}
+	}
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample line with labels given as name, value pairs.
+func sample(w *bufio.Writer, name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
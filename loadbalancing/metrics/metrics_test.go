@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/proxy"
+)
+
+// fakeSource serves one pool and the traffic of one listener.
+type fakeSource struct {
+	balancer balancer.Balancer
+}
+
+func (f *fakeSource) Pools() []string { return []string{"web"} }
+
+func (f *fakeSource) Balancer(pool string) (balancer.Balancer, error) {
+	if pool != "web" {
+		return nil, &lberror.PoolNotFoundError{Name: pool}
+	}
+	return f.balancer, nil
+}
+
+func (f *fakeSource) Traffic() []Traffic {
+	return []Traffic{{Listener: "public", Protocol: "tcp", Backend: "a", TrafficStats: proxy.TrafficStats{Connections: 2, BytesSent: 10, BytesReceived: 20}}}
+}
+
+func scrape(r *Registry, source Source) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	r.Write(w, source)
+	w.Flush()
+	return b.String()
+}
+
+func TestInstrumentedCountsPicks(t *testing.T) {
+	lb, err := algorithm.New("weighted_round_robin", []balancer.Backend{
+		{ID: "a", Address: "127.0.0.1:8081", Weight: 3},
+		{ID: "b", Address: "127.0.0.1:8082", Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := NewRegistry()
+	instrumented := r.Instrument("web", lb)
+
+	for i := 0; i < 8; i++ {
+		backend, err := instrumented.Pick(&balancer.Request{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i == 0 {
+			continue // Keep one connection open
+		}
+		instrumented.Release(backend)
+	}
+	instrumented.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Latency: 20 * time.Millisecond})
+	instrumented.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "b"}, Err: errors.New("refused"), Latency: 2 * time.Second})
+	if err := instrumented.SetEjected("b", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := scrape(r, &fakeSource{balancer: instrumented})
+	for _, want := range []string{
+		`lb_pool_info{pool="web",algorithm="weighted_round_robin"} 1`,
+		`lb_backend_picks_total{pool="web",algorithm="weighted_round_robin",backend="a"} 6`,
+		`lb_backend_picks_total{pool="web",algorithm="weighted_round_robin",backend="b"} 2`,
+		`lb_backend_active_connections{pool="web",backend="a"} 1`,
+		`lb_backend_weight{pool="web",backend="a"} 3`,
+		`lb_backend_healthy{pool="web",backend="b"} 1`,
+		`lb_backend_ejected{pool="web",backend="b"} 1`,
+		`lb_backend_ejections_total{pool="web",backend="b"} 1`,
+		`lb_backend_errors_total{pool="web",backend="b"} 1`,
+		`lb_backend_latency_seconds_bucket{pool="web",backend="a",le="0.025"} 1`,
+		`lb_backend_latency_seconds_bucket{pool="web",backend="a",le="0.01"} 0`,
+		`lb_backend_latency_seconds_bucket{pool="web",backend="b",le="+Inf"} 1`,
+		`lb_backend_latency_seconds_sum{pool="web",backend="b"} 2`,
+		`lb_listener_received_bytes_total{listener="public",protocol="tcp",backend="a"} 20`,
+		"# TYPE lb_backend_latency_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistryDropsRemovedBackends(t *testing.T) {
+	lb, err := algorithm.New("round_robin", []balancer.Backend{
+		{ID: "a", Address: "127.0.0.1:8081"},
+		{ID: "b", Address: "127.0.0.1:8082"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := NewRegistry()
+	instrumented := r.Instrument("web", lb)
+	source := &fakeSource{balancer: instrumented}
+	for i := 0; i < 2; i++ {
+		backend, _ := instrumented.Pick(&balancer.Request{})
+		instrumented.Release(backend)
+	}
+	instrumented.Observe(balancer.Outcome{Err: errors.New("no backend")})
+
+	if err := instrumented.RemoveBackend("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	removed := `lb_backend_picks_total{pool="web",algorithm="round_robin",backend="b"} 1`
+	if out := scrape(r, source); !strings.Contains(out, removed+"\n") {
+		t.Errorf("expected the removed backend to be kept for one scrape, got:\n%s", out)
+	}
+	out := scrape(r, source)
+	if strings.Contains(out, `backend="b"`) {
+		t.Errorf("expected the removed backend to be dropped on the second scrape, got:\n%s", out)
+	}
+	if !strings.Contains(out, `lb_backend_picks_total{pool="web",algorithm="round_robin",backend="a"} 1`+"\n") {
+		t.Errorf("expected backend a to be kept, got:\n%s", out)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	sample(w, "m", 1, "pool", "a\"b\\c\nd")
+	w.Flush()
+	if got, want := b.String(), `m{pool="a\"b\\c\nd"} 1`+"\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,284 @@
+// Package outlier implements passive outlier detection: it watches the
+// outcome of real traffic to each backend and temporarily ejects backends
+// that fail too often, readmitting them after an exponentially growing delay.
+package outlier
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// Config controls when backends are ejected and for how long.
+type Config struct {
+	ConsecutiveErrors  int           // Consecutive failures that eject a backend, zero disables the check
+	ErrorRate          float64       // Share of failed requests within an Interval that ejects a backend, zero disables the check
+	MinRequests        int           // Requests a backend needs within an Interval before ErrorRate applies
+	Interval           time.Duration // Length of the window the error rate is computed over
+	BaseEjectionTime   time.Duration // Length of the first ejection, doubled for every further one
+	MaxEjectionTime    time.Duration // Upper bound of an ejection
+	MaxEjectionPercent int           // Share of the pool, in percent, that may be ejected at the same time
+}
+
+// DefaultConfig returns a configuration close to common proxy defaults.
+func DefaultConfig() Config {
+	return Config{
+		ConsecutiveErrors:  5,
+		ErrorRate:          0.5,
+		MinRequests:        20,
+		Interval:           10 * time.Second,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionTime:    5 * time.Minute,
+		MaxEjectionPercent: 50,
+	}
+}
+
+// Stats is what the detector currently knows about one backend.
+type Stats struct {
+	Requests            int           // Outcomes observed in the current interval
+	Failures            int           // Failed outcomes observed in the current interval
+	ConsecutiveFailures int           // Failures since the last success
+	MeanLatency         time.Duration // Mean latency of the outcomes in the current interval
+	Ejected             bool          // Whether the backend is currently ejected
+	Ejections           int           // Ejection multiplier, grows with every ejection and decays while healthy
+	EjectedUntil        time.Time     // When the current ejection ends
+}
+
+// backendStats is the mutable form of Stats.
+type backendStats struct {
+	Stats
+	totalLatency time.Duration
+	readmit      *time.Timer
+}
+
+// Detector ejects backends from a balancer based on observed outcomes. It
+// implements balancer.Observer so it can be attached to a proxy directly.
+type Detector struct {
+	balancer balancer.Balancer
+	config   Config
+
+	mutex    sync.Mutex
+	backends map[string]*backendStats
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+var _ balancer.Observer = (*Detector)(nil)
+
+// NewDetector creates a Detector that ejects backends of b.
+//
+// Parameters:
+//   - b: The balancer whose backends are ejected and readmitted
+//   - config: Ejection thresholds and timings
+//
+// Returns:
+//   - *Detector: A pointer to the new Detector, call Start to evaluate error rates
+func NewDetector(b balancer.Balancer, config Config) *Detector {
+	if config.Interval <= 0 {
+		config.Interval = DefaultConfig().Interval
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = DefaultConfig().BaseEjectionTime
+	}
+	if config.MaxEjectionTime < config.BaseEjectionTime {
+		config.MaxEjectionTime = config.BaseEjectionTime
+	}
+	return &Detector{
+		balancer: b,
+		config:   config,
+		backends: make(map[string]*backendStats),
+	}
+}
+
+// Observe records an outcome. Consecutive failures are checked right away,
+// the error rate at the end of every interval.
+func (d *Detector) Observe(outcome balancer.Outcome) {
+	if outcome.Backend == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s := d.statsFor(outcome.Backend.ID)
+	s.Requests++
+	s.totalLatency += outcome.Latency
+	s.MeanLatency = s.totalLatency / time.Duration(s.Requests)
+
+	if !outcome.Failed() {
+		s.ConsecutiveFailures = 0
+		return
+	}
+	s.Failures++
+	s.ConsecutiveFailures++
+	if d.config.ConsecutiveErrors > 0 && s.ConsecutiveFailures >= d.config.ConsecutiveErrors {
+		d.eject(outcome.Backend.ID, s)
+	}
+}
+
+// Start evaluates error rates every interval until ctx is cancelled or Stop is called.
+func (d *Detector) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	d.mutex.Lock()
+	d.cancel = cancel
+	d.mutex.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.evaluate()
+			}
+		}
+	}()
+}
+
+// Stop ends evaluation and readmits every ejected backend, since nothing
+// would readmit them anymore.
+func (d *Detector) Stop() {
+	d.mutex.Lock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.mutex.Unlock()
+	d.wg.Wait()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for id, s := range d.backends {
+		if s.Ejected {
+			s.readmit.Stop()
+			d.readmitLocked(id, s)
+		}
+	}
+}
+
+// Forget drops everything known about the backend, once it left the
+// balancer or before it is added again at another address. It is not
+// readmitted if it was ejected.
+func (d *Detector) Forget(id string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if s, ok := d.backends[id]; ok {
+		if s.readmit != nil {
+			s.readmit.Stop()
+		}
+		delete(d.backends, id)
+	}
+}
+
+// Stats returns the current statistics of every observed backend.
+func (d *Detector) Stats() map[string]Stats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats := make(map[string]Stats, len(d.backends))
+	for id, s := range d.backends {
+		stats[id] = s.Stats
+	}
+	return stats
+}
+
+// evaluate ejects backends whose error rate over the past interval is too
+// high, decays the ejection multiplier of well behaved backends and starts a
+// new interval.
+func (d *Detector) evaluate() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for id, s := range d.backends {
+		if !s.Ejected && d.config.ErrorRate > 0 && s.Requests >= d.config.MinRequests && s.Requests > 0 {
+			if float64(s.Failures)/float64(s.Requests) >= d.config.ErrorRate {
+				d.eject(id, s)
+			}
+		}
+		if !s.Ejected && s.Failures == 0 && s.Ejections > 0 {
+			s.Ejections--
+		}
+
+		s.Requests, s.Failures = 0, 0
+		s.totalLatency, s.MeanLatency = 0, 0
+	}
+}
+
+// eject takes the backend out of rotation unless that would eject more than
+// MaxEjectionPercent of the pool. The caller must hold d.mutex.
+func (d *Detector) eject(id string, s *backendStats) {
+	if s.Ejected || !d.ejectionAllowed() {
+		return
+	}
+	if err := d.balancer.SetEjected(id, true); err != nil {
+		log.Printf("outlier: failed to eject backend %s: %v", id, err)
+		return
+	}
+
+	s.Ejections++
+	duration := d.config.BaseEjectionTime << (s.Ejections - 1)
+	if duration > d.config.MaxEjectionTime || duration <= 0 {
+		duration = d.config.MaxEjectionTime
+	}
+	s.Ejected = true
+	s.EjectedUntil = time.Now().Add(duration)
+	s.readmit = time.AfterFunc(duration, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if s.Ejected && d.backends[id] == s {
+			d.readmitLocked(id, s)
+		}
+	})
+	log.Printf("outlier: ejected backend %s for %v", id, duration)
+}
+
+// readmitLocked puts an ejected backend back into rotation. The caller must hold d.mutex.
+func (d *Detector) readmitLocked(id string, s *backendStats) {
+	s.Ejected = false
+	s.ConsecutiveFailures = 0
+	s.EjectedUntil = time.Time{}
+	if err := d.balancer.SetEjected(id, false); err != nil {
+		// The backend left the balancer while ejected, forget about it.
+		delete(d.backends, id)
+	}
+}
+
+// ejectionAllowed reports whether one more backend may be ejected. At least
+// one backend of a pool larger than one may always be ejected.
+// The caller must hold d.mutex.
+func (d *Detector) ejectionAllowed() bool {
+	pool := len(d.balancer.Snapshot())
+	if pool <= 1 {
+		return false
+	}
+
+	allowed := pool * d.config.MaxEjectionPercent / 100
+	if allowed < 1 {
+		allowed = 1
+	}
+
+	ejected := 0
+	for _, s := range d.backends {
+		if s.Ejected {
+			ejected++
+		}
+	}
+	return ejected < allowed
+}
+
+// statsFor returns the statistics of the backend, creating them if needed.
+// The caller must hold d.mutex.
+func (d *Detector) statsFor(id string) *backendStats {
+	s, ok := d.backends[id]
+	if !ok {
+		s = &backendStats{}
+		d.backends[id] = s
+	}
+	return s
+}
@@ -0,0 +1,258 @@
+package outlier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+)
+
+func newBalancer(t *testing.T, name string, ids ...string) balancer.Balancer {
+	t.Helper()
+	backends := make([]balancer.Backend, 0, len(ids))
+	for _, id := range ids {
+		backends = append(backends, balancer.Backend{ID: id, Address: id + ":80", Weight: 1})
+	}
+	lb, err := algorithm.New(name, backends)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return lb
+}
+
+func fail(d *Detector, id string, n int) {
+	for i := 0; i < n; i++ {
+		d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: id}, Err: errors.New("connection refused")})
+	}
+}
+
+func succeed(d *Detector, id string, n int) {
+	for i := 0; i < n; i++ {
+		d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: id}, Status: 200, Latency: time.Millisecond})
+	}
+}
+
+func TestConsecutiveErrorsEjectAndReadmit(t *testing.T) {
+	for _, name := range algorithm.Names() {
+		t.Run(name, func(t *testing.T) {
+			lb := newBalancer(t, name, "a", "b")
+			d := NewDetector(lb, Config{ConsecutiveErrors: 3, BaseEjectionTime: 20 * time.Millisecond, MaxEjectionPercent: 50})
+
+			fail(d, "a", 3)
+			if !d.Stats()["a"].Ejected {
+				t.Fatal("expected a to be ejected after 3 consecutive errors")
+			}
+			for i := 0; i < 10; i++ {
+				picked, err := lb.Pick(&balancer.Request{ClientIP: "10.0.0.1"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if picked.ID != "b" {
+					t.Fatalf("expected the ejected backend to be skipped, got %s", picked.ID)
+				}
+				lb.Release(picked)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			for _, status := range lb.Snapshot() {
+				if status.Ejected {
+					t.Errorf("expected %s to be readmitted", status.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSuccessResetsConsecutiveErrors(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 3, MaxEjectionPercent: 50})
+
+	fail(d, "a", 2)
+	succeed(d, "a", 1)
+	fail(d, "a", 2)
+	if d.Stats()["a"].Ejected {
+		t.Error("expected a success to reset the consecutive error count")
+	}
+}
+
+func TestServerErrorsCountAsFailures(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 2, MaxEjectionPercent: 50})
+
+	d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Status: 404})
+	d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Status: 404})
+	if d.Stats()["a"].Ejected {
+		t.Fatal("expected client errors not to eject")
+	}
+	d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Status: 502})
+	d.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Status: 503})
+	if !d.Stats()["a"].Ejected {
+		t.Error("expected 5xx responses to eject")
+	}
+}
+
+func TestErrorRateEjection(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b")
+	d := NewDetector(lb, Config{ErrorRate: 0.5, MinRequests: 10, MaxEjectionPercent: 50})
+
+	// Alternating outcomes never trip consecutive errors, only the rate.
+	for i := 0; i < 4; i++ {
+		fail(d, "a", 1)
+		succeed(d, "a", 1)
+	}
+	d.evaluate()
+	if d.Stats()["a"].Ejected {
+		t.Fatal("expected no ejection below MinRequests")
+	}
+
+	for i := 0; i < 5; i++ {
+		fail(d, "a", 1)
+		succeed(d, "a", 1)
+	}
+	succeed(d, "b", 10)
+	d.evaluate()
+	if !d.Stats()["a"].Ejected {
+		t.Error("expected a to be ejected at a 50% error rate")
+	}
+	if d.Stats()["b"].Ejected {
+		t.Error("expected b to stay in rotation")
+	}
+}
+
+func TestEjectionBackoff(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b")
+	d := NewDetector(lb, Config{
+		ConsecutiveErrors:  1,
+		BaseEjectionTime:   time.Second,
+		MaxEjectionTime:    3 * time.Second,
+		MaxEjectionPercent: 50,
+	})
+	defer d.Stop()
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for i, want := range expected {
+		before := time.Now()
+		fail(d, "a", 1)
+		stats := d.Stats()["a"]
+		if got := stats.EjectedUntil.Sub(before); got < want || got > want+time.Second/2 {
+			t.Errorf("ejection %d: expected about %v, got %v", i+1, want, got)
+		}
+
+		d.mutex.Lock()
+		s := d.backends["a"]
+		s.readmit.Stop()
+		d.readmitLocked("a", s)
+		d.mutex.Unlock()
+	}
+
+	// The interval with the failures ends, then a clean one decays the multiplier by one.
+	d.evaluate()
+	if got := d.Stats()["a"].Ejections; got != 3 {
+		t.Errorf("expected no decay after a failing interval, got %d", got)
+	}
+	d.evaluate()
+	if got := d.Stats()["a"].Ejections; got != 2 {
+		t.Errorf("expected the multiplier to decay to 2, got %d", got)
+	}
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b", "c", "d")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 1, MaxEjectionPercent: 50})
+	defer d.Stop()
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		fail(d, id, 1)
+	}
+	ejected := 0
+	for _, status := range lb.Snapshot() {
+		if status.Ejected {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Errorf("expected 2 of 4 backends ejected, got %d", ejected)
+	}
+}
+
+func TestForgetFreesEjectionBudget(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a", "b", "c", "d")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 1, MaxEjectionPercent: 25})
+	defer d.Stop()
+
+	fail(d, "a", 1)
+	if err := lb.RemoveBackend("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.Forget("a")
+	if _, ok := d.Stats()["a"]; ok {
+		t.Errorf("expected a to be forgotten, got %+v", d.Stats()["a"])
+	}
+
+	fail(d, "b", 1)
+	if !d.Stats()["b"].Ejected {
+		t.Error("expected b to be ejected once the removed a no longer counts")
+	}
+}
+
+func TestSingleBackendNeverEjected(t *testing.T) {
+	lb := newBalancer(t, "round_robin", "a")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 1, MaxEjectionPercent: 100})
+
+	fail(d, "a", 5)
+	if _, err := lb.Pick(nil); err != nil {
+		t.Errorf("expected the only backend to stay in rotation, got %v", err)
+	}
+}
+
+func TestStopReadmits(t *testing.T) {
+	lb := newBalancer(t, "least_connection", "a", "b")
+	d := NewDetector(lb, Config{ConsecutiveErrors: 1, BaseEjectionTime: time.Hour, MaxEjectionPercent: 50})
+
+	fail(d, "a", 1)
+	d.Stop()
+	for _, status := range lb.Snapshot() {
+		if status.Ejected {
+			t.Errorf("expected %s to be readmitted on Stop", status.ID)
+		}
+	}
+}
+
+func TestEjectedServerLeavesHeap(t *testing.T) {
+	for _, name := range []string{"least_connection", "weighted_least_connection"} {
+		t.Run(name, func(t *testing.T) {
+			lb := newBalancer(t, name, "a", "b")
+			d := NewDetector(lb, Config{ConsecutiveErrors: 1, BaseEjectionTime: time.Hour, MaxEjectionPercent: 50})
+
+			// a has the fewest connections and would be picked first.
+			held, _ := lb.Pick(nil)
+			if held.ID != "a" {
+				lb.Release(held)
+				held, _ = lb.Pick(nil)
+			}
+			lb.Release(held)
+			fail(d, "a", 1)
+
+			for i := 0; i < 3; i++ {
+				picked, err := lb.Pick(nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if picked.ID != "b" {
+					t.Fatalf("expected the ejected server to be off the heap, got %s", picked.ID)
+				}
+			}
+
+			d.Stop()
+			picked, err := lb.Pick(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if picked.ID != "a" {
+				t.Errorf("expected the readmitted server with no connections to be picked, got %s", picked.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package p2c
+
+import (
+	"sync/atomic"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the power of two choices algorithm in configuration.
+const Name = "p2c"
+
+var (
+	_ balancer.Balancer = (*P2C)(nil)
+	_ balancer.Observer = (*P2C)(nil)
+)
+
+// Name returns the algorithm identifier.
+func (p *P2C) Name() string {
+	return Name
+}
+
+// Pick returns the less loaded of two random available servers and charges it
+// one connection. The request is only used to explain the pick.
+func (p *P2C) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	s, err := p.nextServer(req)
+	if err != nil {
+		return nil, err
+	}
+	return p.backends[s.ID], nil
+}
+
+// Release frees the connection charged to the backend by Pick.
+func (p *P2C) Release(backend *balancer.Backend) {
+	p.ReleaseServer(backend.ID)
+}
+
+// Observe marks one pick of the outcome's backend as answered and folds its
+// latency into the backend's moving average.
+func (p *P2C) Observe(outcome balancer.Outcome) {
+	if outcome.Backend == nil {
+		return
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	s, ok := p.byID[outcome.Backend.ID]
+	if !ok {
+		return
+	}
+	decrementPositive(&s.outstanding)
+	for {
+		current := s.latency.Load()
+		next := int64(outcome.Latency)
+		if current > 0 {
+			next = int64(latencyDecay*float64(outcome.Latency) + (1-latencyDecay)*float64(current))
+		}
+		if s.latency.CompareAndSwap(current, next) {
+			return
+		}
+	}
+}
+
+// AddBackend adds a new server with no connections.
+func (p *P2C) AddBackend(backend balancer.Backend) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.byID[backend.ID]; ok {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+	p.addServer(Server{ID: backend.ID, Weight: backend.Weight})
+	p.backends[backend.ID] = &backend
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID.
+func (p *P2C) RemoveBackend(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.removeServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain stops sampling the server and removes it once its last connection
+// is released.
+func (p *P2C) Drain(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain samples the draining server again, with the connections it still
+// holds.
+func (p *P2C) Undrain(id string) error {
+	return p.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetWeight records the new weight and passes it to the metric.
+func (p *P2C) SetWeight(id string, weight int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.byID[id]
+	if !ok {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	s.Weight = weight
+
+	updated := *p.backends[id]
+	updated.Weight = weight
+	p.backends[id] = &updated
+	return nil
+}
+
+// SetHealthy marks the server as up or down. Down servers are never sampled.
+func (p *P2C) SetHealthy(id string, healthy bool) error {
+	return p.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server out of or back into the sampled servers.
+func (p *P2C) SetEjected(id string, ejected bool) error {
+	return p.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (p *P2C) setCondition(id string, condition balancer.Condition, on bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.byID[id]; !ok {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	p.conditions.Set(id, condition, on)
+	p.refresh()
+	return nil
+}
+
+// Snapshot returns every server in the order it was added.
+func (p *P2C) Snapshot() []balancer.BackendStatus {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(p.servers))
+	for _, s := range p.servers {
+		statuses = append(statuses, p.conditions.Status(balancer.BackendStatus{
+			Backend:     *p.backends[s.ID],
+			Connections: int(s.connections.Load()),
+		}))
+	}
+	return statuses
+}
+
+// Loads returns the load of every server keyed by ID, for inspecting what
+// the metric decides on.
+func (p *P2C) Loads() map[string]Load {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	loads := make(map[string]Load, len(p.servers))
+	for _, s := range p.servers {
+		loads[s.ID] = s.load()
+	}
+	return loads
+}
+
+// decrementPositive decrements v unless it is already zero.
+func decrementPositive(v *atomic.Int64) {
+	for {
+		current := v.Load()
+		if current <= 0 || v.CompareAndSwap(current, current-1) {
+			return
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Package p2c implements power of two choices balancing: every pick samples
+// two random available servers and takes the less loaded one. This balances
+// nearly as well as always taking the least loaded server, but a pick only
+// reads two servers and takes a shared lock, so concurrent picks do not
+// serialize the way pops and pushes on a heap do.
+package p2c
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// Server represents a server with a unique ID and a weight.
+type Server struct {
+	ID     string
+	Weight int
+}
+
+// Load is the state of a server a Metric decides on.
+type Load struct {
+	Weight      int
+	Connections int           // Picks not yet released
+	Outstanding int           // Picks whose outcome has not been observed yet
+	Latency     time.Duration // Moving average of observed latencies, zero until the first outcome
+}
+
+// Metric returns the load of a server. Of two sampled servers the one with
+// the lower load is picked.
+type Metric func(load Load) float64
+
+// Connections loads servers by their active connections, as least connection does.
+func Connections(load Load) float64 {
+	return float64(load.Connections)
+}
+
+// Outstanding loads servers by the requests they have not answered yet. It
+// needs the P2C to observe outcomes; without outcomes it equals Connections.
+func Outstanding(load Load) float64 {
+	return float64(load.Outstanding)
+}
+
+// Latency loads servers by their moving average latency. Servers without
+// measurements count as the fastest, so new servers are tried soon.
+func Latency(load Load) float64 {
+	return float64(load.Latency)
+}
+
+// metrics maps metric names used in configuration to metrics.
+var metrics = map[string]Metric{
+	"connections": Connections,
+	"outstanding": Outstanding,
+	"latency":     Latency,
+}
+
+// MetricByName returns the metric called name: connections, outstanding or latency.
+func MetricByName(name string) (Metric, error) {
+	metric, ok := metrics[name]
+	if !ok {
+		return nil, errors.New("unknown p2c metric " + name)
+	}
+	return metric, nil
+}
+
+// Rand is the random source of a P2C. Implementations must be safe for
+// concurrent use when Pick is called concurrently; *rand.Rand is not, but is
+// fine for deterministic single goroutine tests.
+type Rand interface {
+	IntN(n int) int
+}
+
+// globalRand draws from the concurrency safe top level functions of math/rand/v2.
+type globalRand struct{}
+
+func (globalRand) IntN(n int) int {
+	return rand.IntN(n)
+}
+
+// latencyDecay is the weight of a new latency sample in the moving average.
+const latencyDecay = 0.3
+
+// server is a server together with its load counters, which picks update
+// atomically while holding only the read lock.
+type server struct {
+	Server
+	connections atomic.Int64
+	outstanding atomic.Int64
+	latency     atomic.Int64 // Moving average in nanoseconds
+}
+
+// load returns the current counters of s.
+func (s *server) load() Load {
+	return Load{
+		Weight:      s.Weight,
+		Connections: int(s.connections.Load()),
+		Outstanding: int(s.outstanding.Load()),
+		Latency:     time.Duration(s.latency.Load()),
+	}
+}
+
+// P2C is a power of two choices load balancer.
+type P2C struct {
+	metric Metric
+	rand   Rand
+
+	mutex      sync.RWMutex
+	servers    []*server                    // Every server in the order added
+	available  []*server                    // Servers without conditions, the ones picks sample from
+	byID       map[string]*server           // Servers keyed by ID
+	backends   map[string]*balancer.Backend // Backend details keyed by server ID, one per server
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
+}
+
+// P2CLoadBalancer initializes a new P2C load balancer.
+//
+// Parameters:
+//   - servers: The initial servers
+//   - metric: The load compared between the two sampled servers, Connections if nil
+//   - r: The random source, the top level math/rand/v2 functions if nil
+//
+// Returns:
+//   - *P2C: A pointer to the new P2C
+func P2CLoadBalancer(servers []Server, metric Metric, r Rand) *P2C {
+	if metric == nil {
+		metric = Connections
+	}
+	if r == nil {
+		r = globalRand{}
+	}
+
+	p := &P2C{
+		metric:   metric,
+		rand:     r,
+		byID:     make(map[string]*server),
+		backends: make(map[string]*balancer.Backend),
+	}
+	for _, s := range servers {
+		p.addServer(s)
+	}
+	return p
+}
+
+// SetMetric replaces the load compared between the two sampled servers.
+func (p *P2C) SetMetric(metric Metric) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.metric = metric
+}
+
+// GetNextServer picks the less loaded of two random available servers and
+// charges it one connection. Release it with ReleaseServer.
+func (p *P2C) GetNextServer() (Server, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	s, err := p.nextServer(nil)
+	if err != nil {
+		return Server{}, err
+	}
+	return s.Server, nil
+}
+
+// ReleaseServer frees one connection of the server with the given ID. A
+// draining server is removed with its last connection.
+func (p *P2C) ReleaseServer(id string) {
+	p.mutex.RLock()
+	s, ok := p.byID[id]
+	if ok {
+		p.releaseServer(s)
+	}
+	draining := ok && p.conditions.Has(id, balancer.ConditionDraining)
+	p.mutex.RUnlock()
+
+	if !draining {
+		return
+	}
+	// Picks may have raced in between, so check again under the write lock.
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if s, ok := p.byID[id]; ok && s.connections.Load() == 0 {
+		p.removeServer(id)
+	}
+}
+
+// RemoveServer removes the server with the given ID at once, whatever
+// connections it still has. Returns an error if the server is not found.
+func (p *P2C) RemoveServer(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.removeServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer stops sampling the server with the given ID and removes it once
+// its last connection is released, at once if it has none.
+// Returns an error if the server is not found.
+func (p *P2C) DrainServer(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// nextServer samples two distinct available servers and charges the one with
+// the lower load, describing the comparison in req.Reason when asked to.
+// req may be nil. The caller must hold at least the read lock.
+func (p *P2C) nextServer(req *balancer.Request) (*server, error) {
+	n := len(p.available)
+	if n == 0 {
+		if len(p.servers) == 0 {
+			return nil, errors.New("no servers available")
+		}
+		return nil, errors.New("no healthy servers available")
+	}
+
+	chosen := p.available[0]
+	if n > 1 {
+		i := p.rand.IntN(n)
+		j := p.rand.IntN(n - 1)
+		if j >= i {
+			j++
+		}
+		chosen = p.available[i]
+		other := p.available[j]
+		chosenLoad, otherLoad := p.metric(chosen.load()), p.metric(other.load())
+		if otherLoad < chosenLoad {
+			chosen, other = other, chosen
+			chosenLoad, otherLoad = otherLoad, chosenLoad
+		}
+		if req.Explained() {
+			req.Reason = fmt.Sprintf("p2c load %g vs %g on %s", chosenLoad, otherLoad, other.ID)
+		}
+	} else if req.Explained() {
+		req.Reason = "p2c only available server"
+	}
+
+	chosen.connections.Add(1)
+	chosen.outstanding.Add(1)
+	return chosen, nil
+}
+
+// releaseServer frees one connection of s. A released pick cannot be
+// outstanding anymore, so outstanding never exceeds connections.
+// The caller must hold at least the read lock.
+func (p *P2C) releaseServer(s *server) {
+	decrementPositive(&s.connections)
+	for {
+		outstanding := s.outstanding.Load()
+		connections := s.connections.Load()
+		if outstanding <= connections || s.outstanding.CompareAndSwap(outstanding, connections) {
+			return
+		}
+	}
+}
+
+// addServer appends a server, using its ID as the backend address until
+// AddBackend registers the real one. The caller must hold the write lock.
+func (p *P2C) addServer(s Server) {
+	added := &server{Server: s}
+	p.servers = append(p.servers, added)
+	p.byID[s.ID] = added
+	p.backends[s.ID] = &balancer.Backend{ID: s.ID, Address: s.ID, Weight: s.Weight}
+	p.refresh()
+}
+
+// removeServer deletes the server with the given ID and everything recorded
+// about it. It reports whether the server was found.
+// The caller must hold the write lock.
+func (p *P2C) removeServer(id string) bool {
+	if _, ok := p.byID[id]; !ok {
+		return false
+	}
+	for i, s := range p.servers {
+		if s.ID == id {
+			p.servers = append(p.servers[:i], p.servers[i+1:]...)
+			break
+		}
+	}
+	delete(p.byID, id)
+	delete(p.backends, id)
+	p.conditions.Forget(id)
+	p.refresh()
+	return true
+}
+
+// drainServer marks the server as draining and removes it if it has no
+// connections left. It reports whether the server was found.
+// The caller must hold the write lock.
+func (p *P2C) drainServer(id string) bool {
+	s, ok := p.byID[id]
+	if !ok {
+		return false
+	}
+	p.conditions.Set(id, balancer.ConditionDraining, true)
+	p.refresh()
+	if s.connections.Load() == 0 {
+		p.removeServer(id)
+	}
+	return true
+}
+
+// refresh rebuilds the list of available servers after a membership or
+// condition change. The caller must hold the write lock.
+func (p *P2C) refresh() {
+	p.available = p.available[:0]
+	for _, s := range p.servers {
+		if p.conditions.Available(s.ID) {
+			p.available = append(p.available, s)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package p2c
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// scriptedRand returns the scripted values in order, modulo n.
+type scriptedRand struct {
+	values []int
+}
+
+func (r *scriptedRand) IntN(n int) int {
+	v := r.values[0]
+	r.values = r.values[1:]
+	return v % n
+}
+
+func servers(ids ...string) []Server {
+	s := make([]Server, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, Server{ID: id, Weight: 1})
+	}
+	return s
+}
+
+func TestPicksLessLoadedOfTwo(t *testing.T) {
+	// Samples b and a, then b and c, the second draw skipping the first index.
+	r := &scriptedRand{values: []int{1, 0, 1, 1}}
+	p := P2CLoadBalancer(servers("a", "b", "c"), nil, r)
+	p.byID["b"].connections.Store(3)
+
+	first, err := p.GetNextServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.ID != "a" {
+		t.Errorf("expected a with fewer connections than b, got %s", first.ID)
+	}
+
+	second, err := p.GetNextServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.ID != "c" {
+		t.Errorf("expected c with fewer connections than b, got %s", second.ID)
+	}
+}
+
+func TestBalancesConnections(t *testing.T) {
+	p := P2CLoadBalancer(servers("a", "b", "c", "d"), Connections, rand.New(rand.NewPCG(1, 2)))
+
+	held := make([]Server, 0, 400)
+	for i := 0; i < 400; i++ {
+		s, err := p.GetNextServer()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		held = append(held, s)
+	}
+	for id, load := range p.Loads() {
+		if load.Connections < 95 || load.Connections > 105 {
+			t.Errorf("expected about 100 connections on %s, got %d", id, load.Connections)
+		}
+	}
+
+	for _, s := range held {
+		p.ReleaseServer(s.ID)
+	}
+	for id, load := range p.Loads() {
+		if load.Connections != 0 || load.Outstanding != 0 {
+			t.Errorf("expected %s to be idle after releasing, got %+v", id, load)
+		}
+	}
+}
+
+func TestOutstandingFollowsOutcomes(t *testing.T) {
+	p := P2CLoadBalancer(nil, Outstanding, rand.New(rand.NewPCG(1, 2)))
+	p.AddBackend(balancer.Backend{ID: "a", Address: "a:80", Weight: 1})
+
+	backend, _ := p.Pick(nil)
+	p.Pick(nil)
+	p.Observe(balancer.Outcome{Backend: backend, Latency: 10 * time.Millisecond})
+	if load := p.Loads()["a"]; load.Connections != 2 || load.Outstanding != 1 {
+		t.Errorf("expected 2 connections with 1 outstanding, got %+v", load)
+	}
+
+	p.Release(backend)
+	p.Release(backend)
+	if load := p.Loads()["a"]; load.Outstanding != 0 {
+		t.Errorf("expected released picks not to stay outstanding, got %+v", load)
+	}
+}
+
+func TestLatencyMovingAverage(t *testing.T) {
+	p := P2CLoadBalancer(servers("fast", "slow"), Latency, rand.New(rand.NewPCG(1, 2)))
+	fast := &balancer.Backend{ID: "fast"}
+	slow := &balancer.Backend{ID: "slow"}
+
+	p.Observe(balancer.Outcome{Backend: fast, Latency: 10 * time.Millisecond})
+	p.Observe(balancer.Outcome{Backend: slow, Latency: 100 * time.Millisecond})
+	p.Observe(balancer.Outcome{Backend: slow, Latency: 200 * time.Millisecond})
+	if got := p.Loads()["slow"].Latency; got != 130*time.Millisecond {
+		t.Errorf("expected a moving average of 130ms, got %v", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		s, _ := p.GetNextServer()
+		if s.ID != "fast" {
+			t.Fatalf("expected the faster server, got %s", s.ID)
+		}
+		p.ReleaseServer(s.ID)
+	}
+}
+
+func TestSkipsUnavailableServers(t *testing.T) {
+	p := P2CLoadBalancer(servers("a", "b", "c"), nil, rand.New(rand.NewPCG(1, 2)))
+	p.SetHealthy("a", false)
+	p.SetEjected("c", true)
+
+	for i := 0; i < 10; i++ {
+		s, err := p.GetNextServer()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.ID != "b" {
+			t.Fatalf("expected the only available server b, got %s", s.ID)
+		}
+	}
+
+	p.SetHealthy("b", false)
+	if _, err := p.GetNextServer(); err == nil {
+		t.Error("expected an error when no server is available")
+	}
+}
+
+func TestDrainServer(t *testing.T) {
+	p := P2CLoadBalancer(servers("a", "b"), nil, rand.New(rand.NewPCG(1, 2)))
+	p.byID["a"].connections.Store(1)
+
+	if err := p.DrainServer("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		s, _ := p.GetNextServer()
+		if s.ID != "b" {
+			t.Fatalf("expected draining a to take no new picks, got %s", s.ID)
+		}
+		p.ReleaseServer(s.ID)
+	}
+
+	p.ReleaseServer("a")
+	if _, ok := p.Loads()["a"]; ok {
+		t.Error("expected a to be removed after its last connection")
+	}
+	if err := p.DrainServer("a"); err == nil {
+		t.Error("expected an error draining a removed server")
+	}
+}
+
+func TestMetricByName(t *testing.T) {
+	for _, name := range []string{"connections", "outstanding", "latency"} {
+		if _, err := MetricByName(name); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+	if _, err := MetricByName("cpu"); err == nil {
+		t.Error("expected an error for an unknown metric")
+	}
+}
@@ -0,0 +1,204 @@
+package peakewma
+
+import (
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the Peak-EWMA algorithm in configuration.
+const Name = "peak_ewma"
+
+var (
+	_ balancer.Balancer = (*PeakEWMA)(nil)
+	_ balancer.Observer = (*PeakEWMA)(nil)
+)
+
+// Name returns the algorithm identifier.
+func (p *PeakEWMA) Name() string {
+	return Name
+}
+
+// Pick returns the server with the lowest expected cost and charges it one
+// pending request. The request is only used to explain the pick.
+func (p *PeakEWMA) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	server, err := p.nextServer(req)
+	if err != nil {
+		return nil, err
+	}
+	return p.backendFor(server), nil
+}
+
+// Release marks the pending request charged to the backend by Pick as
+// finished. A draining server is removed with its last pending request.
+func (p *PeakEWMA) Release(backend *balancer.Backend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if server := p.find(backend.ID); server != nil {
+		p.releaseServer(server)
+	}
+}
+
+// Observe folds the latency of the outcome into its backend's moving average.
+// A failure counts as at least the penalty, so a backend that fails fast does
+// not look fast.
+func (p *PeakEWMA) Observe(outcome balancer.Outcome) {
+	if outcome.Backend == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	server := p.find(outcome.Backend.ID)
+	if server == nil {
+		return
+	}
+	latency := outcome.Latency
+	if outcome.Failed() && latency < p.penalty {
+		latency = p.penalty
+	}
+	p.observe(server, latency)
+}
+
+// AddBackend adds a new server without pending requests or measurements.
+func (p *PeakEWMA) AddBackend(backend balancer.Backend) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.find(backend.ID) != nil {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+
+	if p.backends == nil {
+		p.backends = make(map[string]*balancer.Backend)
+	}
+	p.backends[backend.ID] = &backend
+	p.servers = append(p.servers, &Server{ID: backend.ID, Weight: backend.Weight})
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID.
+func (p *PeakEWMA) RemoveBackend(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.removeServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// SetWeight updates the weight dividing the server's expected cost.
+func (p *PeakEWMA) SetWeight(id string, weight int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.updateWeight(id, weight) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain stops picking the server and removes it once its last pending
+// request is released.
+func (p *PeakEWMA) Drain(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain puts the draining server back into rotation with the requests it
+// still has pending.
+func (p *PeakEWMA) Undrain(id string) error {
+	return p.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetHealthy marks the server as up or down. Down servers are never picked.
+func (p *PeakEWMA) SetHealthy(id string, healthy bool) error {
+	return p.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server out of or back into rotation.
+func (p *PeakEWMA) SetEjected(id string, ejected bool) error {
+	return p.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (p *PeakEWMA) setCondition(id string, condition balancer.Condition, on bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.find(id) == nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	p.conditions.Set(id, condition, on)
+	return nil
+}
+
+// Snapshot returns every server in the order it was added, with its pending
+// requests as connections.
+func (p *PeakEWMA) Snapshot() []balancer.BackendStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(p.servers))
+	for _, server := range p.servers {
+		statuses = append(statuses, p.conditions.Status(balancer.BackendStatus{
+			Backend:     *p.backendFor(server),
+			Connections: server.Pending,
+		}))
+	}
+	return statuses
+}
+
+// Latencies returns the current decayed latency average of every server keyed by ID.
+func (p *PeakEWMA) Latencies() map[string]time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	latencies := make(map[string]time.Duration, len(p.servers))
+	for _, server := range p.servers {
+		latencies[server.ID] = p.decayed(server, now)
+	}
+	return latencies
+}
+
+// find returns the server with the given ID, or nil. The caller must hold p.mutex.
+func (p *PeakEWMA) find(id string) *Server {
+	for _, server := range p.servers {
+		if server.ID == id {
+			return server
+		}
+	}
+	return nil
+}
+
+// backendFor returns the backend registered for server. Servers passed to
+// PeakEWMALoadBalancer have none yet, so one is created using the server ID
+// as the address. The caller must hold p.mutex.
+func (p *PeakEWMA) backendFor(server *Server) *balancer.Backend {
+	if b, ok := p.backends[server.ID]; ok {
+		return b
+	}
+	if p.backends == nil {
+		p.backends = make(map[string]*balancer.Backend)
+	}
+	weight := server.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	b := &balancer.Backend{ID: server.ID, Address: server.ID, Weight: weight}
+	p.backends[server.ID] = b
+	return b
+}
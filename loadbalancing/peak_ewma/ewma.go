@@ -0,0 +1,261 @@
+// Package peakewma implements Peak-EWMA balancing. Every server keeps an
+// exponentially weighted moving average of its response latency that jumps
+// straight to any higher sample, so a slowdown is noticed at once, and decays
+// back over time, so a server that was slow gets tried again. Picks go to the
+// server with the lowest expected cost, its latency average times its
+// outstanding requests plus one, divided by its weight.
+package peakewma
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// DefaultDecayTime is how long it takes a latency average to decay to about
+// a third of its value without new samples.
+const DefaultDecayTime = 10 * time.Second
+
+// DefaultPenalty is the cost of a server that has outstanding requests but
+// no latency measurement yet, and the latency a failed request counts as.
+const DefaultPenalty = time.Second
+
+// Server represents a server tracked by Peak-EWMA.
+type Server struct {
+	ID      string
+	Weight  int           // Divides the expected cost, 0 counts as 1
+	Pending int           // Requests picked but not yet released
+	Latency time.Duration // Peak sensitive moving average latency as of the last sample
+
+	stamp time.Time // When Latency was last updated
+}
+
+// PeakEWMA is a latency aware load balancer.
+type PeakEWMA struct {
+	servers   []*Server
+	decayTime time.Duration
+	penalty   time.Duration
+	now       func() time.Time // Clock, replaceable in tests
+	mutex     sync.Mutex
+
+	backends   map[string]*balancer.Backend // Backend details keyed by server ID
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
+}
+
+// PeakEWMALoadBalancer creates and initializes a new PeakEWMA load balancer.
+//
+// Parameters:
+//   - servers: A slice of Server structs representing the available servers
+//   - decayTime: How quickly latency averages forget old samples, DefaultDecayTime if not positive
+//
+// Returns:
+//   - *PeakEWMA: A pointer to the initialized PeakEWMA load balancer
+func PeakEWMALoadBalancer(servers []Server, decayTime time.Duration) *PeakEWMA {
+	if decayTime <= 0 {
+		decayTime = DefaultDecayTime
+	}
+
+	p := &PeakEWMA{
+		decayTime: decayTime,
+		penalty:   DefaultPenalty,
+		now:       time.Now,
+	}
+	for i := range servers {
+		p.servers = append(p.servers, &servers[i])
+	}
+	return p
+}
+
+// GetNextServer selects the server with the lowest expected cost and charges
+// it one pending request.
+//
+// Returns:
+//   - *Server: A pointer to the selected server
+//   - error: An error if no servers are available
+func (p *PeakEWMA) GetNextServer() (*Server, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.nextServer(nil)
+}
+
+// ReleaseServer marks one pending request of the server as finished. A
+// draining server is removed with its last pending request.
+func (p *PeakEWMA) ReleaseServer(server *Server) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.releaseServer(server)
+}
+
+// RemoveServer removes the server with the given ID at once, whatever
+// requests it still has pending. Returns an error if the server is not found.
+func (p *PeakEWMA) RemoveServer(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.removeServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// UpdateWeight changes the weight dividing the expected cost of the server
+// with the given ID. Returns an error if the server is not found.
+func (p *PeakEWMA) UpdateWeight(id string, weight int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.updateWeight(id, weight) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer stops picking the server with the given ID and removes it once
+// its last pending request is released, at once if it has none.
+// Returns an error if the server is not found.
+func (p *PeakEWMA) DrainServer(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// ObserveLatency folds a measured response latency of the server into its
+// moving average.
+func (p *PeakEWMA) ObserveLatency(server *Server, latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.observe(server, latency)
+}
+
+// nextServer charges the available server with the lowest cost, the first
+// one added on ties, describing the cost in req.Reason when asked to. req may
+// be nil. The caller must hold p.mutex.
+func (p *PeakEWMA) nextServer(req *balancer.Request) (*Server, error) {
+	if len(p.servers) == 0 {
+		return nil, errors.New("no servers available")
+	}
+
+	now := p.now()
+	var best *Server
+	bestCost := math.Inf(1)
+	for _, server := range p.servers {
+		if !p.conditions.Available(server.ID) {
+			continue
+		}
+		if cost := p.cost(server, now); cost < bestCost {
+			best, bestCost = server, cost
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no healthy servers available")
+	}
+
+	if req.Explained() {
+		req.Reason = fmt.Sprintf("lowest peak ewma cost=%s", time.Duration(bestCost))
+	}
+	best.Pending++
+	return best, nil
+}
+
+// releaseServer marks one pending request of server as finished and removes
+// the server if it was draining and has none left. The caller must hold p.mutex.
+func (p *PeakEWMA) releaseServer(server *Server) {
+	if server.Pending > 0 {
+		server.Pending--
+	}
+	if server.Pending == 0 && p.conditions.Has(server.ID, balancer.ConditionDraining) {
+		p.removeServer(server.ID)
+	}
+}
+
+// removeServer deletes the server with the given ID and everything recorded
+// about it. It reports whether the server was found. The caller must hold p.mutex.
+func (p *PeakEWMA) removeServer(id string) bool {
+	for i, server := range p.servers {
+		if server.ID == id {
+			p.servers = append(p.servers[:i], p.servers[i+1:]...)
+			delete(p.backends, id)
+			p.conditions.Forget(id)
+			return true
+		}
+	}
+	return false
+}
+
+// updateWeight sets the weight of the server with the given ID. It reports
+// whether the server was found. The caller must hold p.mutex.
+func (p *PeakEWMA) updateWeight(id string, weight int) bool {
+	server := p.find(id)
+	if server == nil {
+		return false
+	}
+	server.Weight = weight
+
+	updated := *p.backendFor(server)
+	updated.Weight = weight
+	p.backends[id] = &updated
+	return true
+}
+
+// drainServer marks the server as draining and removes it if it has no
+// pending requests. It reports whether the server was found.
+// The caller must hold p.mutex.
+func (p *PeakEWMA) drainServer(id string) bool {
+	server := p.find(id)
+	if server == nil {
+		return false
+	}
+	p.conditions.Set(id, balancer.ConditionDraining, true)
+	if server.Pending == 0 {
+		p.removeServer(id)
+	}
+	return true
+}
+
+// cost returns the expected cost of sending one more request to server:
+// its decayed latency average times its pending requests plus one, divided
+// by its weight. The caller must hold p.mutex.
+func (p *PeakEWMA) cost(server *Server, now time.Time) float64 {
+	latency := float64(p.decayed(server, now))
+
+	weight := server.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	if latency == 0 && server.Pending > 0 {
+		// Nothing measured yet, but busy: rank behind every measured server
+		// while still preferring the one with fewer pending requests.
+		return (float64(p.penalty) + float64(server.Pending)) / float64(weight)
+	}
+	return latency * float64(server.Pending+1) / float64(weight)
+}
+
+// decayed returns the latency average of server decayed toward zero for the
+// time since its last sample. The caller must hold p.mutex.
+func (p *PeakEWMA) decayed(server *Server, now time.Time) time.Duration {
+	elapsed := now.Sub(server.stamp)
+	if server.Latency == 0 || elapsed <= 0 {
+		return server.Latency
+	}
+	return time.Duration(float64(server.Latency) * math.Exp(-float64(elapsed)/float64(p.decayTime)))
+}
+
+// observe folds latency into the moving average of server. A sample above
+// the average replaces it, lower samples are weighted by how long ago the
+// previous sample was. The caller must hold p.mutex.
+func (p *PeakEWMA) observe(server *Server, latency time.Duration) {
+	now := p.now()
+	if latency > server.Latency || server.stamp.IsZero() {
+		server.Latency = latency
+	} else {
+		w := math.Exp(-float64(now.Sub(server.stamp)) / float64(p.decayTime))
+		server.Latency = time.Duration(float64(server.Latency)*w + float64(latency)*(1-w))
+	}
+	server.stamp = now
+}
@@ -0,0 +1,138 @@
+package peakewma
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestBalancer(ids ...string) (*PeakEWMA, *fakeClock) {
+	servers := make([]Server, 0, len(ids))
+	for _, id := range ids {
+		servers = append(servers, Server{ID: id, Weight: 1})
+	}
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	p := PeakEWMALoadBalancer(servers, 10*time.Second)
+	p.now = clock.Now
+	return p, clock
+}
+
+func TestPeakEWMAAvoidsSlowServer(t *testing.T) {
+	p, _ := newTestBalancer("fast", "slow")
+	p.ObserveLatency(p.servers[0], 10*time.Millisecond)
+	p.ObserveLatency(p.servers[1], 200*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		server, err := p.GetNextServer()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if server.ID != "fast" {
+			t.Fatalf("pick %d: expected fast, got %s", i, server.ID)
+		}
+	}
+
+	// Five pending requests make fast cost 60ms against slow's 200ms,
+	// twenty make it 210ms and slow becomes the cheaper choice.
+	for i := 0; i < 15; i++ {
+		p.GetNextServer()
+	}
+	if server, _ := p.GetNextServer(); server.ID != "slow" {
+		t.Errorf("expected outstanding requests to push traffic to slow, got %s", server.ID)
+	}
+}
+
+func TestPeakEWMAReactsToPeaks(t *testing.T) {
+	p, clock := newTestBalancer("a")
+	server := p.servers[0]
+
+	p.ObserveLatency(server, 10*time.Millisecond)
+	clock.Advance(time.Second)
+	p.ObserveLatency(server, 500*time.Millisecond)
+	if server.Latency != 500*time.Millisecond {
+		t.Errorf("expected a peak to replace the average, got %v", server.Latency)
+	}
+
+	clock.Advance(10 * time.Second)
+	p.ObserveLatency(server, 100*time.Millisecond)
+	// w = e^-1, so 500ms*0.368 + 100ms*0.632 is about 247ms.
+	if server.Latency < 240*time.Millisecond || server.Latency > 255*time.Millisecond {
+		t.Errorf("expected the average to move toward the lower sample, got %v", server.Latency)
+	}
+}
+
+func TestPeakEWMADecaysStaleMeasurements(t *testing.T) {
+	p, clock := newTestBalancer("a", "b")
+	p.ObserveLatency(p.servers[0], 100*time.Millisecond)
+	p.ObserveLatency(p.servers[1], 50*time.Millisecond)
+	p.servers[1].Pending = 1
+
+	if server, _ := p.GetNextServer(); server.ID != "a" {
+		t.Fatalf("expected a at first, got %s", server.ID)
+	}
+	p.servers[0].Pending = 0
+
+	clock.Advance(30 * time.Second)
+	if got := p.Latencies()["a"]; got > 6*time.Millisecond {
+		t.Errorf("expected a stale average to decay, got %v", got)
+	}
+}
+
+func TestPeakEWMAUnmeasuredPendingPenalty(t *testing.T) {
+	p, _ := newTestBalancer("new", "known")
+	p.ObserveLatency(p.servers[1], 50*time.Millisecond)
+	p.servers[0].Pending = 1
+
+	if server, _ := p.GetNextServer(); server.ID != "known" {
+		t.Errorf("expected a busy server without measurements to rank last, got %s", server.ID)
+	}
+}
+
+func TestPeakEWMAObserveFailures(t *testing.T) {
+	p, _ := newTestBalancer("a", "b")
+	p.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "a"}, Err: errors.New("refused"), Latency: time.Millisecond})
+	p.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "b"}, Status: 200, Latency: 20 * time.Millisecond})
+
+	if got := p.Latencies()["a"]; got != DefaultPenalty {
+		t.Errorf("expected a fast failure to count as the penalty, got %v", got)
+	}
+	backend, err := p.Pick(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.ID != "b" {
+		t.Errorf("expected the failing backend to be avoided, got %s", backend.ID)
+	}
+	p.Release(backend)
+	if p.servers[1].Pending != 0 {
+		t.Errorf("expected release to clear the pending request, got %d", p.servers[1].Pending)
+	}
+}
+
+func TestPeakEWMAWeights(t *testing.T) {
+	p, _ := newTestBalancer("a", "b")
+	p.ObserveLatency(p.servers[0], 30*time.Millisecond)
+	p.ObserveLatency(p.servers[1], 20*time.Millisecond)
+	if err := p.SetWeight("a", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if server, _ := p.GetNextServer(); server.ID != "a" {
+		t.Errorf("expected the weight to halve a's cost, got %s", server.ID)
+	}
+}
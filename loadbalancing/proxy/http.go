@@ -0,0 +1,276 @@
+// Package proxy forwards client traffic to the backends chosen by a
+// balancer.Balancer.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"sysdesign/loadbalancing/accesslog"
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// stateKey is the request context key under which the requestState is stored.
+type stateKey struct{}
+
+// requestState follows a single request through the reverse proxy.
+type requestState struct {
+	start    time.Time
+	outcome  balancer.Outcome
+	canceled bool // The client went away before the backend answered
+}
+
+// statusClientClosedRequest is answered, for the access log only, to
+// requests the client gave up on. It is the status nginx uses for them.
+const statusClientClosedRequest = 499
+
+// HTTPProxy is an http.Handler that forwards every request to the backend
+// picked by its balancer and releases the backend once the response is done.
+type HTTPProxy struct {
+	balancer balancer.Balancer
+	proxy    *httputil.ReverseProxy
+
+	// TrustForwardedFor makes the proxy take the client IP from the first
+	// X-Forwarded-For entry instead of the connection's remote address.
+	// Only enable it when the proxy itself sits behind trusted proxies.
+	TrustForwardedFor bool
+
+	// Observer, when set, receives the outcome of every proxied request.
+	Observer balancer.Observer
+
+	// AccessLog, when set, gets an entry for every request, including the
+	// ones no backend was available for. Name is recorded as its listener.
+	AccessLog accesslog.Sink
+	Name      string
+
+	traffic trafficTable
+}
+
+// NewHTTPProxy creates an HTTPProxy that routes requests through b.
+//
+// Parameters:
+//   - b: The balancer used to pick a backend for each request
+//
+// Returns:
+//   - *HTTPProxy: A pointer to the new HTTPProxy instance
+func NewHTTPProxy(b balancer.Balancer) *HTTPProxy {
+	p := &HTTPProxy{balancer: b}
+	p.proxy = &httputil.ReverseProxy{
+		// The transport traces every upstream call in a client span and
+		// propagates the trace context to the backend.
+		Transport:      otelhttp.NewTransport(http.DefaultTransport),
+		Rewrite:        p.rewrite,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.handleError,
+	}
+	return p
+}
+
+// ServeHTTP picks a backend for r, forwards the request to it and releases the
+// backend after the response has been fully copied to the client.
+func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "proxy "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)),
+	)
+	defer span.End()
+
+	req := &balancer.Request{ClientIP: p.clientIP(r), Explain: p.AccessLog != nil}
+	cw := &countingWriter{ResponseWriter: w}
+	state := &requestState{start: time.Now()}
+	if p.AccessLog != nil {
+		defer func() { p.logAccess(r, req, cw, state) }()
+	}
+
+	backend, err := pick(ctx, p.balancer, req)
+	var invalid *lberror.InvalidAddressError
+	if errors.As(err, &invalid) {
+		span.SetStatus(codes.Error, "invalid client address")
+		http.Error(cw, "invalid client address", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "no backend available")
+		log.Printf("proxy: no backend for %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(cw, "no backend available", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.balancer.Release(backend)
+
+	counters := p.traffic.countersFor(backend.ID)
+	counters.connections.Add(1)
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, counter: &counters.bytesSent}
+	}
+	cw.counter = &counters.bytesReceived
+
+	state.outcome.Backend = backend
+	ctx = context.WithValue(ctx, stateKey{}, state)
+	p.proxy.ServeHTTP(cw, r.WithContext(ctx))
+
+	if state.outcome.Status != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(state.outcome.Status))
+	}
+	if state.outcome.Failed() {
+		span.SetStatus(codes.Error, "backend failed")
+	}
+	if p.Observer != nil && !state.canceled {
+		p.Observer.Observe(state.outcome)
+	}
+}
+
+// logAccess writes the access log entry of r once its response is done.
+func (p *HTTPProxy) logAccess(r *http.Request, req *balancer.Request, w *countingWriter, state *requestState) {
+	entry := accesslog.Entry{
+		Time:      state.start,
+		Listener:  p.Name,
+		ClientIP:  req.ClientIP,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    w.status,
+		Bytes:     w.written,
+		Duration:  time.Since(state.start),
+		Upstream:  state.outcome.Latency,
+		Algorithm: p.balancer.Name(),
+		Reason:    req.Reason,
+		// The proxy tries a single backend per request.
+		Retries: 0,
+	}
+	if entry.Status == 0 {
+		// Nothing was written, net/http answers 200 with an empty body.
+		entry.Status = http.StatusOK
+	}
+	if state.outcome.Backend != nil {
+		entry.Backend = state.outcome.Backend.ID
+	}
+	if err := p.AccessLog.Log(entry); err != nil {
+		log.Printf("proxy: failed to write access log: %v", err)
+	}
+}
+
+// Stats returns the requests and body bytes proxied so far, keyed by backend ID.
+func (p *HTTPProxy) Stats() map[string]TrafficStats {
+	return p.traffic.snapshot()
+}
+
+// rewrite points the outbound request at the backend stored in its context.
+func (p *HTTPProxy) rewrite(pr *httputil.ProxyRequest) {
+	backend := pr.In.Context().Value(stateKey{}).(*requestState).outcome.Backend
+	target, err := backend.URL()
+	if err != nil {
+		// Leave the URL untouched so the transport fails and handleError reports it.
+		log.Printf("proxy: invalid address for backend %s: %v", backend.ID, err)
+		return
+	}
+
+	pr.SetURL(target)
+	if p.TrustForwardedFor {
+		pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+	}
+	pr.SetXForwarded()
+}
+
+// modifyResponse records the status and latency of the backend's response.
+func (p *HTTPProxy) modifyResponse(resp *http.Response) error {
+	state := resp.Request.Context().Value(stateKey{}).(*requestState)
+	state.outcome.Status = resp.StatusCode
+	state.outcome.Latency = time.Since(state.start)
+	return nil
+}
+
+// handleError answers with 502 when the backend could not be reached. A
+// request cancelled by its client is not held against the backend: it is
+// neither observed nor logged as a failure.
+func (p *HTTPProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	state := r.Context().Value(stateKey{}).(*requestState)
+	state.outcome.Latency = time.Since(state.start)
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		state.canceled = true
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	state.outcome.Err = err
+
+	log.Printf("proxy: backend %s failed for %s %s: %v", state.outcome.Backend.ID, r.Method, r.URL.Path, err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// clientIP returns the IP address of the client that sent r.
+func (p *HTTPProxy) clientIP(r *http.Request) string {
+	if p.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// countingBody counts the bytes of a request body read by the reverse proxy.
+type countingBody struct {
+	io.ReadCloser
+	counter *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.counter.Add(int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes of a response body written to the client,
+// in total for the request and in counter once a backend was picked. It also
+// remembers the status sent.
+type countingWriter struct {
+	http.ResponseWriter
+	counter *atomic.Int64 // nil until a backend was picked
+	status  int
+	written int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	if w.counter != nil {
+		w.counter.Add(int64(n))
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the Flusher and Hijacker of the
+// underlying writer, which the reverse proxy needs for streaming and upgrades.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
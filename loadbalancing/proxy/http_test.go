@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+)
+
+// startBackends starts n HTTP servers that answer with their own ID.
+func startBackends(t *testing.T, n int) []balancer.Backend {
+	t.Helper()
+	backends := make([]balancer.Backend, 0, n)
+	for i := 0; i < n; i++ {
+		id := string(rune('a' + i))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Client", r.Header.Get("X-Forwarded-For"))
+			io.WriteString(w, id)
+		}))
+		t.Cleanup(server.Close)
+		backends = append(backends, balancer.Backend{ID: id, Address: server.URL, Weight: 1})
+	}
+	return backends
+}
+
+func get(t *testing.T, handler http.Handler, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPProxyRoundRobin(t *testing.T) {
+	lb, err := algorithm.New("round_robin", startBackends(t, 2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+
+	expected := []string{"a", "b", "a", "b"}
+	for i, want := range expected {
+		rec := get(t, p, "192.0.2.1:1234", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, rec.Code)
+		}
+		if got := rec.Body.String(); got != want {
+			t.Errorf("request %d: expected backend %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestHTTPProxyReleasesLeastConnection(t *testing.T) {
+	lb, err := algorithm.New("least_connection", startBackends(t, 2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+
+	for i := 0; i < 4; i++ {
+		get(t, p, "192.0.2.1:1234", nil)
+	}
+
+	for _, status := range lb.Snapshot() {
+		if status.Connections != 0 {
+			t.Errorf("expected backend %s to have no connections left, got %d", status.ID, status.Connections)
+		}
+	}
+}
+
+func TestHTTPProxyIPHashUsesClientIP(t *testing.T) {
+	lb, err := algorithm.New("ip_hash", startBackends(t, 3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+
+	first := get(t, p, "198.51.100.7:4000", nil)
+	if got := first.Header().Get("X-Client"); got != "198.51.100.7" {
+		t.Errorf("expected X-Forwarded-For 198.51.100.7, got %q", got)
+	}
+	for port := 4001; port < 4005; port++ {
+		rec := get(t, p, "198.51.100.7:"+strconv.Itoa(port), nil)
+		if rec.Body.String() != first.Body.String() {
+			t.Errorf("expected the same backend %s for the same client, got %s", first.Body.String(), rec.Body.String())
+		}
+	}
+}
+
+func TestHTTPProxyTrustForwardedFor(t *testing.T) {
+	lb, err := algorithm.New("round_robin", startBackends(t, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+	p.TrustForwardedFor = true
+
+	rec := get(t, p, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"203.0.113.9"}})
+	if got := rec.Header().Get("X-Client"); got != "203.0.113.9, 10.0.0.1" {
+		t.Errorf("expected forwarded chain to be preserved, got %q", got)
+	}
+	if got := p.clientIP(httptest.NewRequest(http.MethodGet, "/", nil)); got != "192.0.2.1" {
+		t.Errorf("expected remote address without forwarded header, got %q", got)
+	}
+}
+
+func TestHTTPProxyNoBackends(t *testing.T) {
+	lb, err := algorithm.New("round_robin", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := get(t, NewHTTPProxy(lb), "192.0.2.1:1234", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rec.Code)
+	}
+}
+
+// recordingObserver keeps every outcome it observes.
+type recordingObserver struct {
+	mutex    sync.Mutex
+	outcomes []balancer.Outcome
+}
+
+func (o *recordingObserver) Observe(outcome balancer.Outcome) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.outcomes = append(o.outcomes, outcome)
+}
+
+func TestHTTPProxyObserver(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	lb, err := algorithm.New("round_robin", []balancer.Backend{
+		{ID: "failing", Address: failing.URL},
+		{ID: "closed", Address: closed.URL},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	observer := &recordingObserver{}
+	p := NewHTTPProxy(lb)
+	p.Observer = observer
+
+	get(t, p, "192.0.2.1:1234", nil)
+	get(t, p, "192.0.2.1:1234", nil)
+
+	if len(observer.outcomes) != 2 {
+		t.Fatalf("expected 2 outcomes, got %d", len(observer.outcomes))
+	}
+	first, second := observer.outcomes[0], observer.outcomes[1]
+	if first.Backend.ID != "failing" || first.Status != http.StatusInternalServerError || !first.Failed() {
+		t.Errorf("expected a failed 500 from the failing backend, got %+v", first)
+	}
+	if second.Backend.ID != "closed" || second.Err == nil || !second.Failed() {
+		t.Errorf("expected a connection error from the closed backend, got %+v", second)
+	}
+}
+
+func TestHTTPProxyClientCancelIsNoFailure(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	lb, err := algorithm.New("round_robin", []balancer.Backend{{ID: "slow", Address: slow.URL}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	observer := &recordingObserver{}
+	p := NewHTTPProxy(lb)
+	p.Observer = observer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("expected status %d, got %d", statusClientClosedRequest, rec.Code)
+	}
+	if len(observer.outcomes) != 0 {
+		t.Errorf("expected no outcome for a cancelled request, got %+v", observer.outcomes)
+	}
+}
+
+func TestHTTPProxyInvalidForwardedFor(t *testing.T) {
+	lb, err := algorithm.New("ip_hash", startBackends(t, 2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+	p.TrustForwardedFor = true
+
+	rec := get(t, p, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"unknown"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+	rec = get(t, p, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"2001:db8::7"}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 for an IPv6 client, got %d", rec.Code)
+	}
+}
+
+func TestHTTPProxyStats(t *testing.T) {
+	lb, err := algorithm.New("round_robin", startBackends(t, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+
+	get(t, p, "192.0.2.1:1234", nil)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	stats := p.Stats()["a"]
+	want := TrafficStats{Connections: 2, BytesSent: 5, BytesReceived: 2}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestHTTPProxyAccessLog(t *testing.T) {
+	lb, err := algorithm.New("least_connection", startBackends(t, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	logger, err := accesslog.New(&buf, `{{.ClientIP}} {{.Method}} {{.Path}} {{.Status}} {{.Bytes}} {{.Backend}} {{.Algorithm}} {{.Retries}} {{.Reason}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewHTTPProxy(lb)
+	p.AccessLog = logger
+
+	get(t, p, "10.0.0.1:1234", nil)
+	lb.SetHealthy("a", false)
+	get(t, p, "10.0.0.2:1234", nil)
+
+	want := "10.0.0.1 GET / 200 1 a least_connection 0 least connections=0\n" +
+		"10.0.0.2 GET / 503 21  least_connection 0 \n"
+	if buf.String() != want {
+		t.Errorf("expected access log\n%s\ngot\n%s", want, buf.String())
+	}
+}
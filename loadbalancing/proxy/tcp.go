@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// TCPProxy accepts TCP connections and pipes bytes in both directions to a
+// backend picked by its balancer. The backend is picked when the connection is
+// accepted and released only when both directions are closed, so connection
+// counting algorithms follow the real lifetime of each socket.
+type TCPProxy struct {
+	balancer balancer.Balancer
+
+	// DialTimeout bounds how long connecting to a backend may take. Zero means no limit.
+	DialTimeout time.Duration
+	// IdleTimeout closes a connection once no bytes have moved in either
+	// direction for this long. Zero disables the timeout.
+	IdleTimeout time.Duration
+	// Observer, when set, receives the outcome of connecting to the backend
+	// for every accepted connection.
+	Observer balancer.Observer
+
+	traffic   trafficTable
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewTCPProxy creates a TCPProxy that routes connections through b.
+//
+// Parameters:
+//   - b: The balancer used to pick a backend for each connection
+//
+// Returns:
+//   - *TCPProxy: A pointer to the new TCPProxy instance
+func NewTCPProxy(b balancer.Balancer) *TCPProxy {
+	return &TCPProxy{
+		balancer:    b,
+		DialTimeout: 5 * time.Second,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (p *TCPProxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts connections on l until l or the proxy is closed. It returns
+// nil when the proxy was closed through Close.
+func (p *TCPProxy) Serve(l net.Listener) error {
+	if !p.track(l, nil) {
+		l.Close()
+		return net.ErrClosed
+	}
+	defer p.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		if !p.track(nil, conn) {
+			conn.Close()
+			return nil
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.untrack(nil, conn)
+			p.handle(conn)
+		}()
+	}
+}
+
+// Close stops every listener, closes active connections and waits for their
+// handlers to release their backends.
+func (p *TCPProxy) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+// Shutdown stops every listener at once, then waits for active connections
+// to end on their own. If ctx ends first the remaining connections are closed
+// as by Close and ctx's error is returned.
+func (p *TCPProxy) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.Close()
+		return ctx.Err()
+	}
+}
+
+// Stats returns the traffic relayed so far, keyed by backend ID.
+func (p *TCPProxy) Stats() map[string]TrafficStats {
+	return p.traffic.snapshot()
+}
+
+// handle proxies a single client connection until both directions are done.
+func (p *TCPProxy) handle(client net.Conn) {
+	defer client.Close()
+
+	clientIP := remoteIP(client.RemoteAddr())
+	ctx, span := tracer().Start(context.Background(), "proxy tcp",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.ClientAddress(clientIP)),
+	)
+	defer span.End()
+
+	backend, err := pick(ctx, p.balancer, &balancer.Request{ClientIP: clientIP})
+	if err != nil {
+		span.SetStatus(codes.Error, "no backend available")
+		log.Printf("proxy: no backend for %s: %v", client.RemoteAddr(), err)
+		return
+	}
+	defer p.balancer.Release(backend)
+
+	// Raw TCP cannot carry the trace context, so the upstream side is only
+	// traced up to the connection.
+	_, dial := tracer().Start(ctx, "tcp.dial",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.NetworkPeerAddress(backend.HostPort())),
+	)
+	start := time.Now()
+	upstream, err := net.DialTimeout("tcp", backend.HostPort(), p.DialTimeout)
+	endSpan(dial, err)
+	if err != nil {
+		span.SetStatus(codes.Error, "backend unreachable")
+	}
+	if p.Observer != nil {
+		p.Observer.Observe(balancer.Outcome{Backend: backend, Err: err, Latency: time.Since(start)})
+	}
+	if err != nil {
+		log.Printf("proxy: failed to connect to backend %s: %v", backend.ID, err)
+		return
+	}
+	defer upstream.Close()
+
+	counters := p.traffic.countersFor(backend.ID)
+	counters.connections.Add(1)
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pipe(upstream, client, &counters.bytesSent, &lastActivity)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pipe(client, upstream, &counters.bytesReceived, &lastActivity)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// pipe copies src to dst until src is exhausted or fails. A clean EOF only
+// half-closes dst so the opposite direction can keep flowing; any other error
+// closes both sides.
+func (p *TCPProxy) pipe(dst, src net.Conn, counter *atomic.Int64, lastActivity *atomic.Int64) {
+	buf := make([]byte, 32*1024)
+	for {
+		if p.IdleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(p.IdleTimeout))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				src.Close()
+				dst.Close()
+				return
+			}
+			counter.Add(int64(n))
+		}
+		if err == nil {
+			continue
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// The other direction may still be busy, the connection is only
+			// idle once nothing moved either way for a full timeout.
+			if time.Since(time.Unix(0, lastActivity.Load())) < p.IdleTimeout {
+				continue
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			closeWrite(dst)
+			return
+		}
+		src.Close()
+		dst.Close()
+		return
+	}
+}
+
+// track registers a listener or connection so Close can reach it.
+// It reports false if the proxy is already closed.
+func (p *TCPProxy) track(l net.Listener, conn net.Conn) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return false
+	}
+	if l != nil {
+		p.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		p.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+// untrack forgets a listener or connection registered with track.
+func (p *TCPProxy) untrack(l net.Listener, conn net.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.listeners, l)
+	delete(p.conns, conn)
+}
+
+func (p *TCPProxy) isClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
+
+// closeWrite shuts down the writing side of conn when the connection supports
+// it, signalling EOF to the peer while still allowing reads.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// remoteIP returns the IP part of addr.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	leastconnection "sysdesign/loadbalancing/least_connection"
+)
+
+// startEchoBackend starts a TCP server that reads until EOF and then writes
+// back everything it received in upper case.
+func startEchoBackend(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write([]byte(strings.ToUpper(string(data))))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startTCPProxy(t *testing.T, p *TCPProxy) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+	return l.Addr().String()
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTCPProxyHalfClose(t *testing.T) {
+	lb := leastconnection.LeastConnectionLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "echo", Address: startEchoBackend(t)})
+	p := NewTCPProxy(lb)
+	addr := startTCPProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+	waitFor(t, func() bool { return lb.Snapshot()[0].Connections == 1 })
+
+	// The backend only answers after seeing EOF, so the reply proves the
+	// write side was half-closed while the read side stayed open.
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(reply) != "HELLO" {
+		t.Errorf("expected HELLO, got %q", reply)
+	}
+
+	waitFor(t, func() bool { return lb.Snapshot()[0].Connections == 0 })
+	stats := p.Stats()["echo"]
+	if stats.Connections != 1 || stats.BytesSent != 5 || stats.BytesReceived != 5 {
+		t.Errorf("unexpected traffic stats: %+v", stats)
+	}
+	if lb.Servers[0].Completed != 1 || lb.Servers[0].AverageLifetime() <= 0 {
+		t.Errorf("expected one completed connection with a lifetime, got %d and %v",
+			lb.Servers[0].Completed, lb.Servers[0].AverageLifetime())
+	}
+}
+
+func TestTCPProxyIdleTimeout(t *testing.T) {
+	lb := leastconnection.LeastConnectionLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "echo", Address: startEchoBackend(t)})
+	p := NewTCPProxy(lb)
+	p.IdleTimeout = 50 * time.Millisecond
+	addr := startTCPProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	waitFor(t, func() bool { return lb.Snapshot()[0].Connections == 0 })
+}
+
+func TestTCPProxyNoBackends(t *testing.T) {
+	p := NewTCPProxy(leastconnection.LeastConnectionLoadBalancer(nil))
+	addr := startTCPProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestTCPProxyShutdownKeepsConnections(t *testing.T) {
+	lb := leastconnection.LeastConnectionLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "echo", Address: startEchoBackend(t)})
+	p := NewTCPProxy(lb)
+	addr := startTCPProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	waitFor(t, func() bool { return lb.Snapshot()[0].Connections == 1 })
+
+	done := make(chan error, 1)
+	go func() { done <- p.Shutdown(context.Background()) }()
+	waitFor(t, func() bool {
+		c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	})
+
+	conn.(*net.TCPConn).CloseWrite()
+	if reply, _ := io.ReadAll(conn); string(reply) != "HELLO" {
+		t.Errorf("expected the open connection to finish, got %q", reply)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
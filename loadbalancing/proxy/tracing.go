@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+)
+
+// tracerName identifies the spans of the proxies. Spans go to the global
+// tracer provider, which records nothing until one is installed, see the
+// tracing package.
+const tracerName = "sysdesign/loadbalancing/proxy"
+
+// Span attributes describing backend selection.
+const (
+	attrAlgorithm  = attribute.Key("lb.algorithm")
+	attrBackendID  = attribute.Key("lb.backend.id")
+	attrBackend    = attribute.Key("lb.backend.address")
+	attrCandidates = attribute.Key("lb.candidates")
+	attrHashKey    = attribute.Key("lb.hash_key")
+	attrReason     = attribute.Key("lb.reason")
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// pick picks a backend from b for req inside a "balancer.pick" span, which
+// records the algorithm, the backends it could choose from, the hash key of
+// hashing algorithms, the chosen backend and why it was chosen.
+func pick(ctx context.Context, b balancer.Balancer, req *balancer.Request) (*balancer.Backend, error) {
+	_, span := tracer().Start(ctx, "balancer.pick")
+	defer span.End()
+
+	if span.IsRecording() {
+		req.Explain = true
+	}
+	backend, err := b.Pick(req)
+	if !span.IsRecording() {
+		return backend, err
+	}
+
+	name := b.Name()
+	candidates := 0
+	for _, status := range b.Snapshot() {
+		if status.Healthy && !status.Ejected && !status.Draining {
+			candidates++
+		}
+	}
+	span.SetAttributes(attrAlgorithm.String(name), attrCandidates.Int(candidates))
+	if algorithm.Hashing(name) {
+		span.SetAttributes(attrHashKey.String(req.HashKey()))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "no backend available")
+		return nil, err
+	}
+	span.SetAttributes(attrBackendID.String(backend.ID), attrBackend.String(backend.Address), attrReason.String(req.Reason))
+	return backend, nil
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
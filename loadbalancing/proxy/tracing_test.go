@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+)
+
+// recordSpans installs a tracer provider recording every span until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestHTTPProxyTracing(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	lb, err := algorithm.New("ip_hash", []balancer.Backend{
+		{ID: "a", Address: backend.URL, Weight: 1},
+		{ID: "b", Address: "127.0.0.1:1", Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lb.SetHealthy("b", false)
+	p := NewHTTPProxy(lb)
+
+	// The client's trace continues through the proxy.
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	rec := get(t, p, "192.0.2.1:1234", http.Header{"Traceparent": {parent}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, pickSpan := spans["proxy GET"], spans["balancer.pick"]
+	if server == nil || pickSpan == nil {
+		t.Fatalf("expected server and pick spans, got %v", recorder.Ended())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected the incoming trace to continue, got trace %s", got)
+	}
+	if server.SpanKind() != trace.SpanKindServer || pickSpan.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("expected the pick span inside the server span")
+	}
+
+	attrs := attributes(pickSpan)
+	if attrs[attrAlgorithm].AsString() != "ip_hash" || attrs[attrBackendID].AsString() != "a" ||
+		attrs[attrCandidates].AsInt64() != 1 || attrs[attrHashKey].AsString() != "192.0.2.1" {
+		t.Errorf("unexpected pick attributes: %v", pickSpan.Attributes())
+	}
+
+	var client sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() == trace.SpanKindClient {
+			client = span
+		}
+	}
+	if client == nil {
+		t.Fatal("expected a client span for the upstream call")
+	}
+	want := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("expected the backend to receive %s, got %s", want, traceparent)
+	}
+}
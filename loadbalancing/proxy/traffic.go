@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TrafficStats holds the bytes and connections a proxy relayed for one backend.
+type TrafficStats struct {
+	Connections   int64 // Connections or requests sent to the backend so far
+	BytesSent     int64 // Bytes copied from clients to the backend
+	BytesReceived int64 // Bytes copied from the backend back to clients
+}
+
+// trafficCounters is the concurrently updated form of TrafficStats.
+type trafficCounters struct {
+	connections   atomic.Int64
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+}
+
+// trafficTable holds the traffic counters of every backend a proxy used.
+type trafficTable struct {
+	mutex sync.Mutex
+	stats map[string]*trafficCounters
+}
+
+// countersFor returns the traffic counters of the backend, creating them if needed.
+func (t *trafficTable) countersFor(id string) *trafficCounters {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.stats == nil {
+		t.stats = make(map[string]*trafficCounters)
+	}
+	c, ok := t.stats[id]
+	if !ok {
+		c = &trafficCounters{}
+		t.stats[id] = c
+	}
+	return c
+}
+
+// snapshot returns the current value of every counter, keyed by backend ID.
+func (t *trafficTable) snapshot() map[string]TrafficStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stats := make(map[string]TrafficStats, len(t.stats))
+	for id, c := range t.stats {
+		stats[id] = TrafficStats{
+			Connections:   c.connections.Load(),
+			BytesSent:     c.bytesSent.Load(),
+			BytesReceived: c.bytesReceived.Load(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+)
+
+// udpFlow is one client address and port pinned to a backend.
+type udpFlow struct {
+	client   *net.UDPAddr
+	backend  *balancer.Backend
+	upstream *net.UDPConn     // Connected socket to the backend, replies arrive here
+	counters *trafficCounters // Traffic of the backend, shared with its other flows
+	lastSeen time.Time        // Last datagram seen in either direction, guarded by UDPProxy.mutex
+}
+
+// UDPProxy relays datagrams to backends picked by its balancer. Datagrams are
+// grouped into flows by client address and port; the first datagram of a flow
+// picks a backend, using the "ip:port" pair as the affinity key, and every
+// later datagram of the flow goes to the same backend. Replies from the
+// backend are sent back to the client through the proxy's socket.
+//
+// UDP has no handshake, so a flow's outcome is only known to have failed when
+// opening its socket fails or the backend's host refuses a datagram with an
+// ICMP port unreachable; datagrams the backend silently drops go unnoticed.
+type UDPProxy struct {
+	balancer balancer.Balancer
+
+	// IdleTimeout expires a flow once no datagram moved in either direction
+	// for this long, releasing its backend.
+	IdleTimeout time.Duration
+	// BufferSize is the largest datagram the proxy relays.
+	BufferSize int
+	// Observer, when set, receives the outcome of opening every new flow to
+	// its backend and an error for every datagram the backend refused.
+	Observer balancer.Observer
+
+	traffic trafficTable
+	mutex   sync.Mutex
+	conn    *net.UDPConn
+	flows   map[string]*udpFlow
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewUDPProxy creates a UDPProxy that routes flows through b.
+//
+// Parameters:
+//   - b: The balancer used to pick a backend for each new flow
+//
+// Returns:
+//   - *UDPProxy: A pointer to the new UDPProxy instance
+func NewUDPProxy(b balancer.Balancer) *UDPProxy {
+	return &UDPProxy{
+		balancer:    b,
+		IdleTimeout: 30 * time.Second,
+		BufferSize:  64 * 1024,
+		flows:       make(map[string]*udpFlow),
+		done:        make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens on the UDP address addr and calls Serve.
+func (p *UDPProxy) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+// Serve relays datagrams received on conn until the proxy is closed, in which
+// case it returns nil.
+func (p *UDPProxy) Serve(conn *net.UDPConn) error {
+	p.mutex.Lock()
+	if p.closed || p.conn != nil {
+		p.mutex.Unlock()
+		conn.Close()
+		return errors.New("udp proxy is closed or already serving")
+	}
+	p.conn = conn
+	p.mutex.Unlock()
+
+	p.wg.Add(1)
+	go p.expireFlows()
+
+	buf := make([]byte, p.BufferSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		flow, err := p.flowFor(client)
+		if err != nil {
+			log.Printf("proxy: dropping datagram from %s: %v", client, err)
+			continue
+		}
+		if _, err := flow.upstream.Write(buf[:n]); err != nil {
+			p.observe(balancer.Outcome{Backend: flow.backend, Err: err})
+			log.Printf("proxy: failed to relay datagram to backend %s: %v", flow.backend.ID, err)
+			continue
+		}
+		flow.counters.bytesSent.Add(int64(n))
+	}
+}
+
+// Close stops serving, expires every flow and waits for the relay goroutines.
+func (p *UDPProxy) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	for key, flow := range p.flows {
+		p.removeFlow(key, flow)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+// Flows returns the number of active flows.
+func (p *UDPProxy) Flows() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.flows)
+}
+
+// Stats returns the traffic relayed so far, keyed by backend ID. Every flow
+// counts as a connection.
+func (p *UDPProxy) Stats() map[string]TrafficStats {
+	return p.traffic.snapshot()
+}
+
+// flowFor returns the flow of client, creating it and picking a backend if
+// needed. The backend is picked and dialed without holding p.mutex, so a slow
+// balancer or resolver does not hold up the replies of other flows.
+func (p *UDPProxy) flowFor(client *net.UDPAddr) (*udpFlow, error) {
+	key := client.String()
+
+	p.mutex.Lock()
+	if flow, ok := p.flows[key]; ok {
+		flow.lastSeen = time.Now()
+		p.mutex.Unlock()
+		return flow, nil
+	}
+	p.mutex.Unlock()
+
+	backend, err := p.balancer.Pick(&balancer.Request{ClientIP: client.IP.String(), Key: key})
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	upstream, err := dialUDP(backend)
+	p.observe(balancer.Outcome{Backend: backend, Err: err, Latency: time.Since(start)})
+	if err != nil {
+		p.balancer.Release(backend)
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		upstream.Close()
+		p.balancer.Release(backend)
+		return nil, errors.New("udp proxy is closed")
+	}
+	if flow, ok := p.flows[key]; ok {
+		upstream.Close()
+		p.balancer.Release(backend)
+		flow.lastSeen = time.Now()
+		return flow, nil
+	}
+
+	flow := &udpFlow{
+		client:   client,
+		backend:  backend,
+		upstream: upstream,
+		counters: p.traffic.countersFor(backend.ID),
+		lastSeen: time.Now(),
+	}
+	flow.counters.connections.Add(1)
+	p.flows[key] = flow
+	p.wg.Add(1)
+	go p.relayReplies(flow)
+	return flow, nil
+}
+
+// dialUDP opens a socket connected to the backend.
+func dialUDP(backend *balancer.Backend) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", backend.HostPort())
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, addr)
+}
+
+// observe passes outcome to the Observer, if any.
+func (p *UDPProxy) observe(outcome balancer.Outcome) {
+	if p.Observer != nil {
+		p.Observer.Observe(outcome)
+	}
+}
+
+// relayReplies copies datagrams from the flow's backend back to its client
+// until the flow's upstream socket is closed. A datagram the backend refused
+// is reported to the Observer and the flow kept.
+func (p *UDPProxy) relayReplies(flow *udpFlow) {
+	defer p.wg.Done()
+
+	buf := make([]byte, p.BufferSize)
+	for {
+		n, err := flow.upstream.Read(buf)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			p.observe(balancer.Outcome{Backend: flow.backend, Err: err})
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		flow.lastSeen = time.Now()
+		conn := p.conn
+		p.mutex.Unlock()
+
+		if _, err := conn.WriteToUDP(buf[:n], flow.client); err != nil {
+			log.Printf("proxy: failed to relay reply to %s: %v", flow.client, err)
+			continue
+		}
+		flow.counters.bytesReceived.Add(int64(n))
+	}
+}
+
+// expireFlows periodically removes flows idle for longer than IdleTimeout.
+func (p *UDPProxy) expireFlows() {
+	defer p.wg.Done()
+
+	interval := p.IdleTimeout / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mutex.Lock()
+			for key, flow := range p.flows {
+				if p.IdleTimeout > 0 && now.Sub(flow.lastSeen) >= p.IdleTimeout {
+					p.removeFlow(key, flow)
+				}
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// removeFlow closes the flow and releases its backend. The caller must hold p.mutex.
+func (p *UDPProxy) removeFlow(key string, flow *udpFlow) {
+	delete(p.flows, key)
+	flow.upstream.Close()
+	p.balancer.Release(flow.backend)
+}
+
+func (p *UDPProxy) isClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
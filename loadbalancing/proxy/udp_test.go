@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/iphash"
+)
+
+// startUDPBackend starts a UDP server that replies to every datagram with its ID.
+func startUDPBackend(t *testing.T, id string) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP([]byte(id), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func startUDPProxy(t *testing.T, p *UDPProxy) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go p.Serve(conn)
+	t.Cleanup(func() { p.Close() })
+	return conn.LocalAddr().String()
+}
+
+func exchange(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPProxyFlowAffinity(t *testing.T) {
+	lb := iphash.IpHashLoadBalancer(nil)
+	for _, id := range []string{"a", "b", "c"} {
+		lb.AddBackend(balancer.Backend{ID: id, Address: startUDPBackend(t, id)})
+	}
+	p := NewUDPProxy(lb)
+	proxyAddr, _ := net.ResolveUDPAddr("udp", startUDPProxy(t, p))
+
+	seen := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		conn, err := net.DialUDP("udp", nil, proxyAddr)
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+
+		first := exchange(t, conn)
+		seen[first] = true
+		for j := 0; j < 3; j++ {
+			if got := exchange(t, conn); got != first {
+				t.Errorf("flow %s: expected backend %s, got %s", conn.LocalAddr(), first, got)
+			}
+		}
+	}
+
+	if p.Flows() != 8 {
+		t.Errorf("expected 8 flows, got %d", p.Flows())
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected flows from different ports to spread across backends, all went to %v", seen)
+	}
+}
+
+func TestUDPProxyIdleExpiry(t *testing.T) {
+	lb := iphash.IpHashLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "a", Address: startUDPBackend(t, "a")})
+	p := NewUDPProxy(lb)
+	p.IdleTimeout = 50 * time.Millisecond
+	proxyAddr, _ := net.ResolveUDPAddr("udp", startUDPProxy(t, p))
+
+	conn, err := net.DialUDP("udp", nil, proxyAddr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	exchange(t, conn)
+	if got := lb.Snapshot()[0].Connections; got != 1 {
+		t.Errorf("expected 1 active flow on the backend, got %d", got)
+	}
+
+	waitFor(t, func() bool { return p.Flows() == 0 })
+	if got := lb.Snapshot()[0].Connections; got != 0 {
+		t.Errorf("expected the expired flow to be released, got %d", got)
+	}
+}
+
+func TestUDPProxyCountsTraffic(t *testing.T) {
+	lb := iphash.IpHashLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "a", Address: startUDPBackend(t, "a")})
+	p := NewUDPProxy(lb)
+	observer := &recordingObserver{}
+	p.Observer = observer
+	proxyAddr, _ := net.ResolveUDPAddr("udp", startUDPProxy(t, p))
+
+	conn, err := net.DialUDP("udp", nil, proxyAddr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	exchange(t, conn)
+	exchange(t, conn)
+
+	stats := p.Stats()["a"]
+	if stats.Connections != 1 || stats.BytesSent != 8 || stats.BytesReceived != 2 {
+		t.Errorf("expected 1 flow, 8 bytes sent and 2 received, got %+v", stats)
+	}
+	observer.mutex.Lock()
+	defer observer.mutex.Unlock()
+	if len(observer.outcomes) != 1 || observer.outcomes[0].Failed() {
+		t.Errorf("expected one successful outcome for the new flow, got %+v", observer.outcomes)
+	}
+}
+
+func TestUDPProxyObservesRefusedDatagrams(t *testing.T) {
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := closed.LocalAddr().String()
+	closed.Close()
+
+	lb := iphash.IpHashLoadBalancer(nil)
+	lb.AddBackend(balancer.Backend{ID: "gone", Address: addr})
+	p := NewUDPProxy(lb)
+	observer := &recordingObserver{}
+	p.Observer = observer
+	proxyAddr, _ := net.ResolveUDPAddr("udp", startUDPProxy(t, p))
+
+	conn, err := net.DialUDP("udp", nil, proxyAddr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+
+	waitFor(t, func() bool {
+		observer.mutex.Lock()
+		defer observer.mutex.Unlock()
+		for _, outcome := range observer.outcomes {
+			if outcome.Failed() && outcome.Backend.ID == "gone" {
+				return true
+			}
+		}
+		return false
+	})
+}
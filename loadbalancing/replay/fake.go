@@ -0,0 +1,54 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sysdesign/loadbalancing/fakebackend"
+)
+
+// FakeHandler answers replayed requests the way they were answered when
+// recorded: after the duration, with the status and with as many body bytes
+// as their headers ask for. Requests without these headers get an empty 200
+// right away.
+func FakeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		duration, _ := time.ParseDuration(r.Header.Get(DurationHeader))
+		status, _ := strconv.Atoi(r.Header.Get(StatusHeader))
+		if status < 100 || status > 999 {
+			status = http.StatusOK
+		}
+		size, _ := strconv.ParseInt(r.Header.Get(BytesHeader), 10, 64)
+
+		if duration > 0 {
+			timer := time.NewTimer(duration)
+			defer timer.Stop()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+			}
+		}
+		if size > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.WriteHeader(status)
+		io.CopyN(w, zeros{}, max(size, 0))
+	})
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// StartFakeBackends starts n fake backends on free loopback ports, with IDs
+// b1 to bn, answering replayed requests with FakeHandler.
+func StartFakeBackends(n int) (fakebackend.Group, error) {
+	return fakebackend.StartGroup(n, fakebackend.Config{Handler: FakeHandler()})
+}
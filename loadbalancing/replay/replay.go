@@ -0,0 +1,306 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/simulator"
+)
+
+// Headers telling the fake backends of StartFakeBackends how to answer a
+// replayed request. Real backends ignore them.
+const (
+	DurationHeader = "X-Replay-Duration" // How long to take, as parsed by time.ParseDuration
+	StatusHeader   = "X-Replay-Status"   // Status to answer with
+	BytesHeader    = "X-Replay-Bytes"    // Response body bytes to send
+)
+
+// Replayer sends recorded requests again.
+type Replayer struct {
+	// Balancer picks the backend of every request. Picks are made one after
+	// the other in the order of the trace, so an algorithm that does not
+	// depend on load, such as a hash, routes a trace the same way every
+	// time. Backends are released once their response was read, after the
+	// outcome went to Balancer if it is a balancer.Observer.
+	Balancer balancer.Balancer
+
+	// Target, when set, receives every request instead of a backend picked
+	// by Balancer, for example a running proxy trusting X-Forwarded-For.
+	Target string
+
+	// Speed divides the gaps between requests and the durations asked of
+	// fake backends: 1 replays in real time, 10 ten times faster at the same
+	// load on fake backends. Zero means 1.
+	Speed float64
+
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Result is the outcome of a replay.
+type Result struct {
+	Algorithm string                // Name of the balancer, or the Target
+	Requests  int                   // Requests sent
+	Errors    int                   // Requests that failed or were answered with a 5xx status
+	Duration  time.Duration         // Wall time from the first request until the last response
+	Response  simulator.Percentiles // Time from sending a request until its response was read
+	Backends  []BackendResult       // Per backend, in the order of the balancer's Snapshot
+	Affinity  float64               // Share of clients whose every request went to the same backend
+
+	// Picks holds the backend ID chosen for every record, in the order of
+	// the trace after sorting by time, empty where no backend was available.
+	// It is nil with a Target.
+	Picks []string
+}
+
+// BackendResult is the outcome of a replay for one backend.
+type BackendResult struct {
+	ID       string
+	Requests int
+	Errors   int
+	Bytes    int64 // Response body bytes read
+}
+
+// Replay sends records, sorted by arrival time, and waits for all of them to
+// be answered.
+//
+// Parameters:
+//   - ctx: Stops sending new requests and aborts open ones when cancelled
+//   - records: The recorded requests, e.g. from ReadFile
+//
+// Returns:
+//   - *Result: The errors, latencies and backend choices of the replay
+//   - error: An error if the replayer has neither Balancer nor Target or ctx was cancelled
+func (p *Replayer) Replay(ctx context.Context, records []Record) (*Result, error) {
+	if p.Balancer == nil && p.Target == "" {
+		return nil, fmt.Errorf("replay needs a balancer or a target")
+	}
+	records = sortByTime(records)
+	speed := p.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	result := &Result{Requests: len(records), Algorithm: p.Target}
+	if p.Target == "" {
+		result.Algorithm = p.Balancer.Name()
+		result.Picks = make([]string, len(records))
+	}
+	r := &replay{Replayer: p, client: client, speed: speed, backends: make(map[string]*BackendResult)}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range records {
+		at := start.Add(time.Duration(float64(records[i].Time.Sub(records[0].Time)) / speed))
+		if err := sleepUntil(ctx, at); err != nil {
+			wg.Wait()
+			return nil, err
+		}
+
+		var backend *balancer.Backend
+		if p.Target == "" {
+			picked, err := p.Balancer.Pick(&balancer.Request{ClientIP: records[i].ClientIP})
+			if err != nil {
+				r.done(nil, 0, 0, false)
+				continue
+			}
+			backend = picked
+			result.Picks[i] = backend.ID
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.send(ctx, records[i], backend)
+		}(i)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Duration = time.Since(start)
+	result.Errors = r.errors
+	result.Response = simulator.Summarize(r.latencies)
+	if p.Target == "" {
+		result.Affinity = affinity(records, result.Picks)
+		for _, status := range p.Balancer.Snapshot() {
+			if b, ok := r.backends[status.ID]; ok {
+				result.Backends = append(result.Backends, *b)
+			} else {
+				result.Backends = append(result.Backends, BackendResult{ID: status.ID})
+			}
+		}
+	}
+	return result, nil
+}
+
+// replay is the state of one Replay call.
+type replay struct {
+	*Replayer
+	client *http.Client
+	speed  float64
+
+	mutex     sync.Mutex
+	errors    int
+	latencies []time.Duration // Of every request sent
+	backends  map[string]*BackendResult
+}
+
+// send sends record to backend, or to the target if backend is nil.
+func (r *replay) send(ctx context.Context, record Record, backend *balancer.Backend) {
+	if backend != nil {
+		defer r.Balancer.Release(backend)
+	}
+	start := time.Now()
+	outcome := balancer.Outcome{Backend: backend}
+	var n int64
+	req, err := r.request(ctx, record, backend)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = r.client.Do(req); err == nil {
+			outcome.Status = resp.StatusCode
+			n, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	outcome.Err = err
+	outcome.Latency = time.Since(start)
+
+	// As behind the proxy, balancers learning from latencies, like
+	// peak_ewma, see the outcome before the backend is released.
+	if observer, ok := r.Balancer.(balancer.Observer); ok && backend != nil {
+		observer.Observe(outcome)
+	}
+	r.done(backend, n, outcome.Latency, !outcome.Failed())
+}
+
+// request builds the request replaying record against backend or the target.
+func (r *replay) request(ctx context.Context, record Record, backend *balancer.Backend) (*http.Request, error) {
+	target := r.Target
+	if backend != nil {
+		u, err := backend.URL()
+		if err != nil {
+			return nil, err
+		}
+		target = u.String()
+	}
+	method := record.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target+record.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Forwarded-For", record.ClientIP)
+	req.Header.Set(DurationHeader, (time.Duration(float64(record.Duration) / r.speed)).String())
+	req.Header.Set(StatusHeader, strconv.Itoa(record.Status))
+	req.Header.Set(BytesHeader, strconv.FormatInt(record.Bytes, 10))
+	return req, nil
+}
+
+// done counts a request to backend that took latency, with a nil backend
+// and zero latency if no backend was available.
+func (r *replay) done(backend *balancer.Backend, bytes int64, latency time.Duration, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !ok {
+		r.errors++
+	}
+	if latency > 0 {
+		r.latencies = append(r.latencies, latency)
+	}
+	if backend == nil {
+		return
+	}
+	b := r.backends[backend.ID]
+	if b == nil {
+		b = &BackendResult{ID: backend.ID}
+		r.backends[backend.ID] = b
+	}
+	b.Requests++
+	b.Bytes += bytes
+	if !ok {
+		b.Errors++
+	}
+}
+
+// sleepUntil waits until t or until ctx is cancelled.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sortByTime returns records sorted by arrival time, keeping the order of
+// records that arrived at the same time.
+func sortByTime(records []Record) []Record {
+	sorted := slices.Clone(records)
+	slices.SortStableFunc(sorted, func(a, b Record) int { return a.Time.Compare(b.Time) })
+	return sorted
+}
+
+// affinity returns the share of clients whose every request went to the
+// same backend according to picks, made for records.
+func affinity(records []Record, picks []string) float64 {
+	first := make(map[string]string)
+	sticky := make(map[string]bool)
+	for i, id := range picks {
+		if id == "" {
+			continue
+		}
+		client := records[i].ClientIP
+		if prev, ok := first[client]; !ok {
+			first[client] = id
+			sticky[client] = true
+		} else if prev != id {
+			sticky[client] = false
+		}
+	}
+	n := 0
+	for _, ok := range sticky {
+		if ok {
+			n++
+		}
+	}
+	if len(first) == 0 {
+		return 0
+	}
+	return float64(n) / float64(len(first))
+}
+
+// Moved returns the share of requests that a and b, replays of the same
+// trace, sent to different backends. Requests without a backend in either
+// count as moved.
+func Moved(a, b *Result) float64 {
+	n := min(len(a.Picks), len(b.Picks))
+	if n == 0 {
+		return 0
+	}
+	moved := 0
+	for i := 0; i < n; i++ {
+		if a.Picks[i] == "" || a.Picks[i] != b.Picks[i] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(n)
+}
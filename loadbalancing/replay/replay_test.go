@@ -0,0 +1,205 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/simulator"
+)
+
+func TestReplayIsDeterministicForHashes(t *testing.T) {
+	fakes, err := StartFakeBackends(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fakes.Close()
+	records := testRecords(200)
+
+	var results []*Result
+	for _, name := range []string{"ip_hash", "consistent_hash", "ip_hash"} {
+		b, err := algorithm.New(name, fakes.Backends())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result, err := (&Replayer{Balancer: b, Speed: 100}).Replay(context.Background(), records)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Errors != 0 || result.Requests != len(records) {
+			t.Fatalf("expected %d requests without errors, got %d with %d errors", len(records), result.Requests, result.Errors)
+		}
+		if result.Affinity != 1 {
+			t.Errorf("expected %s to keep every client on one backend, got %.2f", name, result.Affinity)
+		}
+		results = append(results, result)
+	}
+
+	if moved := Moved(results[0], results[2]); moved != 0 {
+		t.Errorf("expected two replays through ip_hash to route alike, %.2f moved", moved)
+	}
+	if moved := Moved(results[0], results[1]); moved == 0 {
+		t.Error("expected ip_hash and consistent_hash to route some requests differently")
+	}
+	served := 0
+	var body int64
+	for _, b := range results[0].Backends {
+		served += b.Requests
+		body += b.Bytes
+	}
+	if want := int64(100 * 199 * 200 / 2); served != len(records) || body != want {
+		t.Errorf("expected %d requests and %d bytes over the backends, got %d and %d", len(records), want, served, body)
+	}
+
+	var table bytes.Buffer
+	if err := WriteTable(&table, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(table.String(), "consistent_hash") {
+		t.Errorf("expected the table to list consistent_hash, got\n%s", table.String())
+	}
+}
+
+func TestReplayKeepsTheRecordedPace(t *testing.T) {
+	fakes, err := StartFakeBackends(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fakes.Close()
+	b, _ := algorithm.New("least_connection", fakes.Backends())
+
+	// 50 requests over 343ms, each taking up to 10ms, replayed twice as fast.
+	start := time.Now()
+	result, err := (&Replayer{Balancer: b, Speed: 2}).Replay(context.Background(), testRecords(50))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 170*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected the replay to take about 175ms, took %v", elapsed)
+	}
+	if result.Response.P50 < 2*time.Millisecond {
+		t.Errorf("expected fake backends to take the scaled recorded durations, got a median of %v", result.Response.P50)
+	}
+	for _, status := range b.Snapshot() {
+		if status.Connections != 0 {
+			t.Errorf("expected every backend released, %s has %d connections", status.ID, status.Connections)
+		}
+	}
+}
+
+// observed counts the outcomes passed to the balancer it wraps, checking
+// each comes before the release of its backend.
+type observed struct {
+	balancer.Balancer
+	outcomes atomic.Int64
+	early    atomic.Int64 // Outcomes of backends already released
+}
+
+func (o *observed) Observe(outcome balancer.Outcome) {
+	o.outcomes.Add(1)
+	for _, status := range o.Snapshot() {
+		if status.ID == outcome.Backend.ID && status.Connections == 0 {
+			o.early.Add(1)
+		}
+	}
+	o.Balancer.(balancer.Observer).Observe(outcome)
+}
+
+func TestReplayFeedsObservers(t *testing.T) {
+	fakes, err := StartFakeBackends(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fakes.Close()
+	b, err := algorithm.New("peak_ewma", fakes.Backends())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	o := &observed{Balancer: b}
+
+	records := testRecords(20)
+	if _, err := (&Replayer{Balancer: o, Speed: 100}).Replay(context.Background(), records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := o.outcomes.Load(); got != int64(len(records)) {
+		t.Errorf("expected an outcome for each of the %d requests, got %d", len(records), got)
+	}
+	if got := o.early.Load(); got != 0 {
+		t.Errorf("expected every outcome before its release, got %d after", got)
+	}
+}
+
+func TestReplayToTarget(t *testing.T) {
+	var clients atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-For") != "" {
+			clients.Add(1)
+		}
+		if r.URL.Path == "/items/0" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer target.Close()
+
+	result, err := (&Replayer{Target: target.URL, Speed: 1000}).Replay(context.Background(), testRecords(20))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clients.Load() != 20 {
+		t.Errorf("expected every request to carry its client IP, got %d of 20", clients.Load())
+	}
+	if result.Errors != 4 {
+		t.Errorf("expected the 4 requests of /items/0 to fail, got %d errors", result.Errors)
+	}
+	if result.Picks != nil || result.Backends != nil {
+		t.Errorf("expected no picks without a balancer, got %v and %v", result.Picks, result.Backends)
+	}
+}
+
+func TestReplayStopsWithItsContext(t *testing.T) {
+	fakes, _ := StartFakeBackends(1)
+	defer fakes.Close()
+	b, _ := algorithm.New("round_robin", fakes.Backends())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := (&Replayer{Balancer: b}).Replay(ctx, testRecords(100)); err == nil {
+		t.Error("expected the cancelled replay to fail")
+	}
+	if _, err := (&Replayer{}).Replay(context.Background(), testRecords(1)); err == nil {
+		t.Error("expected a replay without balancer or target to fail")
+	}
+}
+
+func TestWorkloadSimulatesTheTrace(t *testing.T) {
+	records := testRecords(1000)
+	records[0], records[1] = records[1], records[0]
+	cfg := simulator.Config{
+		Servers:  []simulator.Server{{ID: "a", Workers: 4}, {ID: "b", Workers: 4}},
+		Workload: Workload(records),
+	}
+
+	reports, err := simulator.Compare(cfg, "ip_hash", "least_connection")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range reports {
+		if r.Requests != len(records) || r.Completed != len(records) {
+			t.Errorf("expected %s to serve all %d requests, served %d", r.Algorithm, len(records), r.Completed)
+		}
+		if r.Response.Max < 10*time.Millisecond {
+			t.Errorf("expected the recorded durations as service times, got a maximum response of %v", r.Response.Max)
+		}
+	}
+	again, _ := simulator.Compare(cfg, "least_connection")
+	if again[0].Response != reports[1].Response {
+		t.Errorf("expected equal results for the same trace, got %+v and %+v", again[0].Response, reports[1].Response)
+	}
+}
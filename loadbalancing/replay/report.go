@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sysdesign/loadbalancing/simulator"
+)
+
+// Workload returns records, sorted by arrival time, as a simulator workload.
+// The recorded duration of a request, which includes any queueing on the
+// backend that served it, becomes its service time on a server of speed 1.
+func Workload(records []Record) simulator.Workload {
+	records = sortByTime(records)
+	recorded := make([]simulator.Recorded, len(records))
+	for i, r := range records {
+		recorded[i] = simulator.Recorded{
+			Arrival:  r.Time.Sub(records[0].Time),
+			ClientIP: r.ClientIP,
+			Demand:   r.Duration,
+		}
+	}
+	return simulator.Workload{Requests: len(records), Recorded: recorded}
+}
+
+// WriteTable writes results side by side as a plain text table: one row per
+// replay with its errors, latencies, client affinity and the requests moved
+// compared to the first replay, followed by the requests every backend got.
+func WriteTable(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "algorithm\trequests\terrors\tresponse p50\tresponse p99\tresponse max\taffinity\tmoved\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%.1f%%\t%.1f%%\t\n",
+			r.Algorithm, r.Requests, r.Errors,
+			round(r.Response.P50), round(r.Response.P99), round(r.Response.Max),
+			100*r.Affinity, 100*Moved(results[0], r))
+	}
+	if err := tw.Flush(); err != nil || len(results) == 0 {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"requests"}
+	for _, b := range results[0].Backends {
+		header = append(header, b.ID)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, r := range results {
+		row := []string{r.Algorithm}
+		for _, b := range r.Backends {
+			row = append(row, fmt.Sprintf("%d", b.Requests))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+// round shortens d to three significant digits for display.
+func round(d time.Duration) time.Duration {
+	unit := time.Nanosecond
+	for d >= 1000*unit {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
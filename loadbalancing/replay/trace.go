@@ -0,0 +1,278 @@
+// Package replay records proxied traffic to compact trace files and replays
+// them through any balancer, so an algorithm change can be tried on
+// yesterday's traffic before it is rolled out.
+//
+// A Writer is an accesslog.Sink: set as the access log of the proxy, it
+// records the arrival time, client IP, method, path, status, duration and
+// response size of every request. A Replayer sends the recorded requests
+// again, in real time or accelerated, choosing the backend of each with a
+// balancer in the order they were recorded, to real backends or to the fake
+// ones of StartFakeBackends. Workload turns a trace into a simulator
+// workload, replaying it in virtual time with fully deterministic results.
+//
+// Traces are a header followed by one record per request. Times and sizes
+// are varints and strings are written once and referred to by index
+// afterwards, so a record of a known client and path takes about ten bytes.
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+)
+
+// magic starts every trace, its last byte is the version of the format.
+const magic = "LBTRACE\x01"
+
+// maxStrings bounds the strings a trace refers to by index, so paths with
+// unique IDs cannot grow the table of readers and writers without limit.
+// Strings beyond it are written out in full every time.
+const maxStrings = 1 << 16
+
+// ErrNotTrace is returned by Reader when the input is not a trace.
+var ErrNotTrace = errors.New("not a trace file")
+
+// Record is one recorded request.
+type Record struct {
+	Time     time.Time     // When the request arrived, at microsecond precision
+	ClientIP string        // Client address the balancer saw
+	Method   string        // HTTP method
+	Path     string        // URL path of the request
+	Status   int           // Status sent to the client
+	Duration time.Duration // Time from arrival until the response was sent, at microsecond precision
+	Bytes    int64         // Response body bytes sent to the client
+}
+
+// Writer writes records to a trace. It is safe for concurrent use.
+type Writer struct {
+	mutex   sync.Mutex
+	w       *bufio.Writer
+	closer  io.Closer
+	header  bool              // Whether magic was written
+	last    int64             // Time of the previous record in microseconds since the epoch
+	strings map[string]uint64 // Index of the strings written so far
+	buf     []byte
+}
+
+// NewWriter creates a Writer writing a trace to w. Records are buffered
+// until Flush or Close.
+//
+// Parameters:
+//   - w: Where the trace is written, closed by Close if it is an io.Closer
+//
+// Returns:
+//   - *Writer: A pointer to the new Writer instance
+func NewWriter(w io.Writer) *Writer {
+	closer, _ := w.(io.Closer)
+	return &Writer{w: bufio.NewWriter(w), closer: closer, strings: make(map[string]uint64)}
+}
+
+// Create creates the trace file path, truncating it if it exists, and
+// returns a Writer for it.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(file), nil
+}
+
+// Write appends r to the trace.
+func (w *Writer) Write(r Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	b := w.buf[:0]
+	if !w.header {
+		b = append(b, magic...)
+		w.header = true
+	}
+	// Records are written when their response is done, so arrival times may
+	// go back a little and the delta is signed.
+	now := r.Time.UnixMicro()
+	b = binary.AppendVarint(b, now-w.last)
+	w.last = now
+	b = w.appendString(b, r.ClientIP)
+	b = w.appendString(b, r.Method)
+	b = w.appendString(b, r.Path)
+	b = binary.AppendUvarint(b, uint64(max(r.Status, 0)))
+	b = binary.AppendUvarint(b, uint64(max(r.Duration.Microseconds(), 0)))
+	b = binary.AppendUvarint(b, uint64(max(r.Bytes, 0)))
+	w.buf = b
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+// appendString appends the index of s plus one if it was written before, or
+// zero followed by s.
+func (w *Writer) appendString(b []byte, s string) []byte {
+	if i, ok := w.strings[s]; ok {
+		return binary.AppendUvarint(b, i+1)
+	}
+	if len(w.strings) < maxStrings {
+		w.strings[s] = uint64(len(w.strings))
+	}
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// Log records the request of entry, so a Writer can be the access log of the
+// proxy.
+func (w *Writer) Log(entry accesslog.Entry) error {
+	return w.Write(Record{
+		Time:     entry.Time,
+		ClientIP: entry.ClientIP,
+		Method:   entry.Method,
+		Path:     entry.Path,
+		Status:   entry.Status,
+		Duration: entry.Duration,
+		Bytes:    entry.Bytes,
+	})
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Flush()
+}
+
+// Close flushes buffered records and closes the underlying writer if it is
+// an io.Closer.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.w.Flush()
+	if w.closer != nil {
+		err = errors.Join(err, w.closer.Close())
+	}
+	return err
+}
+
+// Reader reads records from a trace.
+type Reader struct {
+	r       *bufio.Reader
+	header  bool
+	last    int64
+	strings []string
+}
+
+// NewReader creates a Reader reading a trace from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record, or io.EOF after the last one.
+func (r *Reader) Read() (Record, error) {
+	if !r.header {
+		header := make([]byte, len(magic))
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return Record{}, io.EOF
+			}
+			return Record{}, ErrNotTrace
+		}
+		if string(header) != magic {
+			return Record{}, ErrNotTrace
+		}
+		r.header = true
+	}
+
+	delta, err := binary.ReadVarint(r.r)
+	if err != nil {
+		// A trace may only end between records.
+		return Record{}, err
+	}
+	var record Record
+	r.last += delta
+	record.Time = time.UnixMicro(r.last)
+	fields := []*string{&record.ClientIP, &record.Method, &record.Path}
+	for _, field := range fields {
+		if *field, err = r.readString(); err != nil {
+			return Record{}, truncated(err)
+		}
+	}
+	var numbers [3]uint64
+	for i := range numbers {
+		if numbers[i], err = binary.ReadUvarint(r.r); err != nil {
+			return Record{}, truncated(err)
+		}
+	}
+	record.Status = int(numbers[0])
+	record.Duration = time.Duration(numbers[1]) * time.Microsecond
+	record.Bytes = int64(numbers[2])
+	return record, nil
+}
+
+func (r *Reader) readString() (string, error) {
+	ref, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", err
+	}
+	if ref > 0 {
+		if ref > uint64(len(r.strings)) {
+			return "", fmt.Errorf("corrupt trace: string %d of %d", ref-1, len(r.strings))
+		}
+		return r.strings[ref-1], nil
+	}
+
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<20 {
+		return "", fmt.Errorf("corrupt trace: string of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return "", err
+	}
+	s := string(b)
+	if len(r.strings) < maxStrings {
+		r.strings = append(r.strings, s)
+	}
+	return s, nil
+}
+
+// truncated reports a trace that ends within a record.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadAll reads every record of the trace in r.
+func ReadAll(r io.Reader) ([]Record, error) {
+	reader := NewReader(r)
+	var records []Record
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// ReadFile reads every record of the trace file path.
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadAll(file)
+}
@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+)
+
+func testRecords(n int) []Record {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			Time:     start.Add(time.Duration(i) * 7 * time.Millisecond),
+			ClientIP: fmt.Sprintf("10.0.0.%d", i%20),
+			Method:   "GET",
+			Path:     fmt.Sprintf("/items/%d", i%5),
+			Status:   200,
+			Duration: time.Duration(i%10+1) * time.Millisecond,
+			Bytes:    int64(100 * i),
+		}
+	}
+	return records
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	records := testRecords(500)
+	// Records are logged when done, so arrivals may go back in time.
+	records[10].Time, records[11].Time = records[11].Time, records[10].Time
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if perRecord := buf.Len() / len(records); perRecord > 12 {
+		t.Errorf("expected a compact trace, got %d bytes per record", perRecord)
+	}
+
+	got, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("expected %d records, got %d", len(records), len(got))
+	}
+	for i := range records {
+		if !got[i].Time.Equal(records[i].Time) {
+			t.Fatalf("record %d: expected time %v, got %v", i, records[i].Time, got[i].Time)
+		}
+		got[i].Time = records[i].Time
+		if !reflect.DeepEqual(got[i], records[i]) {
+			t.Fatalf("record %d: expected %+v, got %+v", i, records[i], got[i])
+		}
+	}
+}
+
+func TestReaderRejectsBadInput(t *testing.T) {
+	if _, err := ReadAll(bytes.NewReader([]byte("not a trace at all"))); !errors.Is(err, ErrNotTrace) {
+		t.Errorf("expected ErrNotTrace, got %v", err)
+	}
+	if records, err := ReadAll(bytes.NewReader(nil)); err != nil || len(records) != 0 {
+		t.Errorf("expected an empty input to hold no records, got %d and %v", len(records), err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(testRecords(1)[0])
+	w.Flush()
+	_, err := ReadAll(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated trace, got %v", err)
+	}
+}
+
+func TestWriterRecordsAccessLogEntries(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var sink accesslog.Sink = w
+	entry := accesslog.Entry{
+		Time:     time.Now(),
+		ClientIP: "192.0.2.1",
+		Method:   "POST",
+		Path:     "/orders",
+		Status:   201,
+		Bytes:    42,
+		Duration: 15 * time.Millisecond,
+		Backend:  "a",
+	}
+	if err := sink.Log(entry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.Flush()
+
+	records, err := ReadAll(&buf)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one record, got %d and %v", len(records), err)
+	}
+	r := records[0]
+	if r.ClientIP != "192.0.2.1" || r.Method != "POST" || r.Path != "/orders" || r.Status != 201 || r.Bytes != 42 || r.Duration != 15*time.Millisecond {
+		t.Errorf("expected the entry's request, got %+v", r)
+	}
+}
@@ -0,0 +1,180 @@
+package roundrobin
+
+import (
+	"fmt"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the round robin algorithm in configuration.
+const Name = "round_robin"
+
+var _ balancer.Balancer = (*RoundRobin)(nil)
+
+// Name returns the algorithm identifier.
+func (rr *RoundRobin) Name() string {
+	return Name
+}
+
+// Pick returns the next server in rotation. The request is only used to
+// explain the pick.
+func (rr *RoundRobin) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	server, err := rr.nextServer()
+	if err != nil {
+		return nil, err
+	}
+
+	if rr.active == nil {
+		rr.active = make(map[Server]int)
+	}
+	rr.active[*server]++
+	if req.Explained() {
+		// nextServer has moved past the picked server.
+		position := (rr.current + len(rr.servers) - 1) % len(rr.servers)
+		req.Reason = fmt.Sprintf("round robin position %d of %d", position, len(rr.servers))
+	}
+	return rr.backendFor(*server), nil
+}
+
+// Release marks a pick of the backend as finished. A draining server is
+// removed with its last pick.
+func (rr *RoundRobin) Release(backend *balancer.Backend) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	server := Server(backend.ID)
+	if rr.active[server] > 0 {
+		rr.active[server]--
+	}
+	if rr.active[server] == 0 && rr.conditions.Has(backend.ID, balancer.ConditionDraining) {
+		rr.removeServer(server)
+	}
+}
+
+// AddBackend registers the backend as a server keyed by its ID.
+func (rr *RoundRobin) AddBackend(backend balancer.Backend) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	server := Server(backend.ID)
+	if rr.indexOf(server) >= 0 {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+
+	if rr.backends == nil {
+		rr.backends = make(map[Server]*balancer.Backend)
+	}
+	rr.backends[server] = &backend
+	rr.servers = append(rr.servers, server)
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID from the rotation.
+func (rr *RoundRobin) RemoveBackend(id string) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if !rr.removeServer(Server(id)) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain takes the server out of the rotation and removes it once its last
+// pick is released.
+func (rr *RoundRobin) Drain(id string) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if !rr.drainServer(Server(id)) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain puts the draining server back into the rotation.
+func (rr *RoundRobin) Undrain(id string) error {
+	return rr.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetWeight records the new weight. Round robin ignores weights when picking.
+func (rr *RoundRobin) SetWeight(id string, weight int) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	server := Server(id)
+	if rr.indexOf(server) < 0 {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	updated := *rr.backendFor(server)
+	updated.Weight = weight
+	rr.backends[server] = &updated
+	return nil
+}
+
+// SetHealthy takes the server out of or back into the rotation.
+func (rr *RoundRobin) SetHealthy(id string, healthy bool) error {
+	return rr.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server out of or back into the rotation.
+func (rr *RoundRobin) SetEjected(id string, ejected bool) error {
+	return rr.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (rr *RoundRobin) setCondition(id string, condition balancer.Condition, on bool) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if rr.indexOf(Server(id)) < 0 {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	rr.conditions.Set(id, condition, on)
+	return nil
+}
+
+// Snapshot returns every server in rotation order.
+func (rr *RoundRobin) Snapshot() []balancer.BackendStatus {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(rr.servers))
+	for _, server := range rr.servers {
+		statuses = append(statuses, rr.conditions.Status(balancer.BackendStatus{
+			Backend:     *rr.backendFor(server),
+			Connections: rr.active[server],
+		}))
+	}
+	return statuses
+}
+
+// indexOf returns the position of server in the rotation, or -1.
+// The caller must hold rr.mutex.
+func (rr *RoundRobin) indexOf(server Server) int {
+	for i, s := range rr.servers {
+		if s == server {
+			return i
+		}
+	}
+	return -1
+}
+
+// backendFor returns the backend registered for server. Servers added through
+// AddServer have no backend yet, so one is created using the server as both
+// ID and address. The caller must hold rr.mutex.
+func (rr *RoundRobin) backendFor(server Server) *balancer.Backend {
+	if b, ok := rr.backends[server]; ok {
+		return b
+	}
+	if rr.backends == nil {
+		rr.backends = make(map[Server]*balancer.Backend)
+	}
+	b := &balancer.Backend{ID: string(server), Address: string(server), Weight: 1}
+	rr.backends[server] = b
+	return b
+}
@@ -3,14 +3,19 @@ package roundrobin
 import (
 	"errors"
 	"sync"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 type Server string
 
 type RoundRobin struct {
-	servers []Server
-	current int
-	mutex   sync.Mutex
+	servers    []Server
+	current    int
+	mutex      sync.Mutex
+	backends   map[Server]*balancer.Backend // Backend details for servers added through AddBackend
+	active     map[Server]int               // Picks not yet released, per server
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
 }
 
 func (rr *RoundRobin) AddServer(server Server) {
@@ -19,14 +24,83 @@ func (rr *RoundRobin) AddServer(server Server) {
 	rr.servers = append(rr.servers, server)
 }
 
+// RemoveServer takes server out of the rotation.
+// Returns an error if the server is not found.
+func (rr *RoundRobin) RemoveServer(server Server) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	if !rr.removeServer(server) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer stops handing out server and removes it once every pick of it
+// made through Pick has been released, at once if there is none.
+// Returns an error if the server is not found.
+func (rr *RoundRobin) DrainServer(server Server) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	if !rr.drainServer(server) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
 func (rr *RoundRobin) NextServer() (*Server, error) {
 	rr.mutex.Lock()
 	defer rr.mutex.Unlock()
+	return rr.nextServer()
+}
 
+// nextServer returns the first healthy server from the current position on
+// and advances past it. The caller must hold rr.mutex.
+func (rr *RoundRobin) nextServer() (*Server, error) {
 	if len(rr.servers) == 0 {
 		return nil, errors.New("no servers available")
 	}
-	currentServer := rr.servers[rr.current]
-	rr.current = (rr.current + 1) / len(rr.servers)
-	return &currentServer, nil
+
+	for range rr.servers {
+		currentServer := rr.servers[rr.current]
+		rr.current = (rr.current + 1) % len(rr.servers)
+		if rr.conditions.Available(string(currentServer)) {
+			return &currentServer, nil
+		}
+	}
+	return nil, errors.New("no healthy servers available")
+}
+
+// removeServer deletes server and everything recorded about it, keeping the
+// rotation position on the server that would have come next. It reports
+// whether the server was found. The caller must hold rr.mutex.
+func (rr *RoundRobin) removeServer(server Server) bool {
+	i := rr.indexOf(server)
+	if i < 0 {
+		return false
+	}
+
+	rr.servers = append(rr.servers[:i], rr.servers[i+1:]...)
+	if i < rr.current {
+		rr.current--
+	}
+	if rr.current >= len(rr.servers) {
+		rr.current = 0
+	}
+	delete(rr.backends, server)
+	delete(rr.active, server)
+	rr.conditions.Forget(string(server))
+	return true
+}
+
+// drainServer marks server as draining and removes it if it has no picks
+// left. It reports whether the server was found. The caller must hold rr.mutex.
+func (rr *RoundRobin) drainServer(server Server) bool {
+	if rr.indexOf(server) < 0 {
+		return false
+	}
+	rr.conditions.Set(string(server), balancer.ConditionDraining, true)
+	if rr.active[server] == 0 {
+		rr.removeServer(server)
+	}
+	return true
 }
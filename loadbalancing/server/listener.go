@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	"sysdesign/loadbalancing/metrics"
+	"sysdesign/loadbalancing/proxy"
+)
+
+// Defaults of http listeners whose configuration leaves the timeout at zero.
+// They keep clients that send headers slowly or hold idle keep-alive
+// connections from tying up the proxy.
+const (
+	defaultHeaderTimeout = 10 * time.Second
+	defaultHTTPIdle      = 2 * time.Minute
+)
+
+// listener is a running listener. It routes through a balancer.Switch, so
+// its pool can be changed or replaced without touching the socket.
+type listener struct {
+	cfg     config.Listener
+	route   *balancer.Switch
+	socket  io.Closer // net.Listener for http and tcp, *net.UDPConn for udp
+	stopped atomic.Bool
+
+	accessLog accesslog.Sink // Access log of http listeners, may be nil
+
+	handler atomic.Pointer[proxy.HTTPProxy] // Current handler of http listeners
+	http    *http.Server
+	tcp     *proxy.TCPProxy
+	udp     *proxy.UDPProxy
+}
+
+// bind opens the socket of the listener configuration.
+func bind(cfg config.Listener) (io.Closer, error) {
+	if cfg.Protocol == "udp" {
+		addr, err := net.ResolveUDPAddr("udp", cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		return net.ListenUDP("udp", addr)
+	}
+	return net.Listen("tcp", cfg.Address)
+}
+
+// network is the kind of socket bound for the listener configuration.
+func network(cfg config.Listener) string {
+	if cfg.Protocol == "udp" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// newListener builds the proxy of cfg on a socket returned by bind, routing
+// to p. Requests of http listeners are written to accessLog unless it is nil.
+func newListener(cfg config.Listener, p *pool, socket io.Closer, accessLog accesslog.Sink) *listener {
+	l := &listener{cfg: cfg, route: balancer.NewSwitch(p.routed, p.observer), socket: socket, accessLog: accessLog}
+	switch cfg.Protocol {
+	case "http":
+		l.handler.Store(l.newHandler())
+		l.http = &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				l.handler.Load().ServeHTTP(w, r)
+			}),
+			ReadHeaderTimeout: defaultHeaderTimeout,
+			IdleTimeout:       defaultHTTPIdle,
+		}
+		if cfg.Timeouts.Header > 0 {
+			l.http.ReadHeaderTimeout = cfg.Timeouts.Header
+		}
+		if cfg.Timeouts.Idle > 0 {
+			l.http.IdleTimeout = cfg.Timeouts.Idle
+		}
+	case "tcp":
+		l.tcp = proxy.NewTCPProxy(l.route)
+		l.tcp.Observer = l.route
+		l.tcp.IdleTimeout = cfg.Timeouts.Idle
+		if cfg.Timeouts.Dial > 0 {
+			l.tcp.DialTimeout = cfg.Timeouts.Dial
+		}
+	case "udp":
+		l.udp = proxy.NewUDPProxy(l.route)
+		l.udp.Observer = l.route
+		if cfg.Timeouts.Idle > 0 {
+			l.udp.IdleTimeout = cfg.Timeouts.Idle
+		}
+	}
+	return l
+}
+
+// newHandler returns an HTTP proxy for the current configuration of the listener.
+func (l *listener) newHandler() *proxy.HTTPProxy {
+	handler := proxy.NewHTTPProxy(l.route)
+	handler.Observer = l.route
+	handler.TrustForwardedFor = l.cfg.TrustForwardedFor
+	handler.AccessLog = l.accessLog
+	handler.Name = l.cfg.Name
+	return handler
+}
+
+// Addr returns the address the listener is bound to.
+func (l *listener) Addr() net.Addr {
+	switch socket := l.socket.(type) {
+	case net.Listener:
+		return socket.Addr()
+	case *net.UDPConn:
+		return socket.LocalAddr()
+	}
+	return nil
+}
+
+// serve serves the listener in the background. An error ending it before
+// close was called is sent to errs unless errs is full.
+func (l *listener) serve(errs chan<- error) {
+	name := l.cfg.Name
+	go func() {
+		var err error
+		switch {
+		case l.http != nil:
+			err = l.http.Serve(l.socket.(net.Listener))
+		case l.tcp != nil:
+			err = l.tcp.Serve(l.socket.(net.Listener))
+		case l.udp != nil:
+			err = l.udp.Serve(l.socket.(*net.UDPConn))
+		}
+		if err == nil || errors.Is(err, http.ErrServerClosed) || l.stopped.Load() {
+			return
+		}
+		select {
+		case errs <- fmt.Errorf("listener %s: %v", name, err):
+		default:
+		}
+	}()
+}
+
+// traffic returns what the listener relayed to every backend.
+func (l *listener) traffic() []metrics.Traffic {
+	var stats map[string]proxy.TrafficStats
+	switch {
+	case l.http != nil:
+		stats = l.handler.Load().Stats()
+	case l.tcp != nil:
+		stats = l.tcp.Stats()
+	case l.udp != nil:
+		stats = l.udp.Stats()
+	}
+	traffic := make([]metrics.Traffic, 0, len(stats))
+	for id, s := range stats {
+		traffic = append(traffic, metrics.Traffic{Listener: l.cfg.Name, Protocol: l.cfg.Protocol, Backend: id, TrafficStats: s})
+	}
+	return traffic
+}
+
+// update applies a new configuration with the same socket settings. The
+// pool it routes to is changed through route.
+func (l *listener) update(cfg config.Listener) {
+	l.cfg = cfg
+	if l.http != nil {
+		l.handler.Store(l.newHandler())
+	}
+}
+
+// close stops accepting at once, releasing the socket so it can be bound again.
+func (l *listener) close() {
+	l.stopped.Store(true)
+	l.socket.Close()
+}
+
+// drain closes the listener and waits for its connections to end on their
+// own, closing the ones left when ctx ends. UDP flows cannot be handed over
+// and end at once.
+func (l *listener) drain(ctx context.Context) error {
+	l.close()
+	switch {
+	case l.http != nil:
+		return l.http.Shutdown(ctx)
+	case l.tcp != nil:
+		return l.tcp.Shutdown(ctx)
+	case l.udp != nil:
+		return l.udp.Close()
+	}
+	return nil
+}
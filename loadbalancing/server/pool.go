@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/health"
+	"sysdesign/loadbalancing/metrics"
+	"sysdesign/loadbalancing/outlier"
+	"sysdesign/loadbalancing/p2c"
+)
+
+// pool is a running backend pool: its balancer and the checks watching it.
+type pool struct {
+	cfg      config.Pool
+	balancer balancer.Balancer
+	routed   balancer.Balancer     // balancer, instrumented when metrics are recorded; listeners and checks use it
+	observer balancer.Observer     // Receives proxied outcomes, nil if nothing learns from them
+	checker  *health.HealthChecker // Active health checks, nil if the pool has none
+	detector *outlier.Detector     // Outlier detection, nil if the pool has none
+	stops    []func()              // Stop the health checks and outlier detection
+
+	mutex   sync.Mutex                  // Serializes updates with adding waiting backends
+	waiting map[string]balancer.Backend // Backends added once the draining backend holding their ID is gone
+}
+
+// waitInterval is how often a pool checks whether backends waiting for their
+// ID to be released can be added.
+const waitInterval = 100 * time.Millisecond
+
+// newPool builds the balancer of a validated pool configuration, recording
+// its metrics in registry unless it is nil. Call startChecks to start its
+// health checks and outlier detection.
+func newPool(cfg config.Pool, registry *metrics.Registry) (*pool, error) {
+	lb, err := algorithm.New(cfg.Algorithm, cfg.BalancerBackends())
+	if err != nil {
+		return nil, err
+	}
+	p := &pool{cfg: cfg, balancer: lb, routed: lb}
+	if registry != nil {
+		p.routed = registry.Instrument(cfg.Name, lb)
+	}
+	if err := p.applySettings(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// applySettings applies the algorithm specific settings of cfg to the balancer.
+func (p *pool) applySettings(cfg config.Pool) error {
+	if cfg.P2CMetric != "" {
+		metric, err := p2c.MetricByName(cfg.P2CMetric)
+		if err != nil {
+			return err
+		}
+		setter, ok := p.balancer.(interface{ SetMetric(p2c.Metric) })
+		if !ok {
+			return fmt.Errorf("algorithm %s does not use a metric", p.balancer.Name())
+		}
+		setter.SetMetric(metric)
+	}
+
+	if starter, ok := p.balancer.(balancer.SlowStarter); ok {
+		var slowStart balancer.SlowStart
+		if s := cfg.SlowStart; s != nil {
+			ramp, err := balancer.RampByName(s.Ramp)
+			if err != nil {
+				return err
+			}
+			slowStart = balancer.SlowStart{Window: s.Window, Ramp: ramp}
+		}
+		starter.SetSlowStart(slowStart)
+	}
+
+	if hashed, ok := p.balancer.(interface{ SetPrefixLengths(v4, v6 int) error }); ok {
+		var v4, v6 int
+		if h := cfg.Hash; h != nil {
+			v4, v6 = h.PrefixV4, h.PrefixV6
+		}
+		if err := hashed.SetPrefixLengths(v4, v6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startChecks starts the health checks and outlier detection configured for
+// the pool until ctx is cancelled or stopChecks is called.
+func (p *pool) startChecks(ctx context.Context) error {
+	if h := p.cfg.Health; h != nil {
+		probe, err := health.NewProbe(h.Kind, h.Target)
+		if err != nil {
+			return err
+		}
+		if http, ok := probe.(health.HTTPProbe); ok {
+			http.Status, http.BodyContains = h.Status, h.Body
+			probe = http
+		}
+		checker := health.NewHealthChecker(p.routed, probe, health.Config{
+			Interval: h.Interval,
+			Timeout:  h.Timeout,
+			Rise:     h.Rise,
+			Fall:     h.Fall,
+		})
+		go logHealthEvents(p.cfg.Name, checker.Subscribe())
+		checker.Start(ctx)
+		p.checker = checker
+		p.stops = append(p.stops, checker.Stop)
+	}
+
+	// Balancers that learn from outcomes, like p2c, observe alongside metrics
+	// and outlier detection.
+	var observers balancer.Observers
+	if o, ok := p.balancer.(balancer.Observer); ok {
+		observers = append(observers, o)
+	}
+	if o, ok := p.routed.(*metrics.Instrumented); ok {
+		observers = append(observers, o)
+	}
+	if o := p.cfg.Outlier; o != nil {
+		detector := outlier.NewDetector(p.routed, outlierConfig(*o))
+		detector.Start(ctx)
+		p.detector = detector
+		p.stops = append(p.stops, detector.Stop)
+		observers = append(observers, detector)
+	}
+	p.observer = nil
+	if len(observers) > 0 {
+		p.observer = observers
+	}
+	return nil
+}
+
+// stopChecks ends the health checks and outlier detection of the pool.
+// Stopping outlier detection readmits every ejected backend.
+func (p *pool) stopChecks() {
+	for _, stop := range p.stops {
+		stop()
+	}
+	p.stops = nil
+	p.checker = nil
+	p.detector = nil
+}
+
+// restartChecks replaces the checks of the pool with the ones of cfg. When
+// health checks are turned off every backend is marked up again, as nothing
+// would bring back the ones that are down.
+func (p *pool) restartChecks(ctx context.Context, cfg config.Pool) error {
+	p.stopChecks()
+	if cfg.Health == nil && p.cfg.Health != nil {
+		for _, status := range p.balancer.Snapshot() {
+			p.balancer.SetHealthy(status.ID, true)
+		}
+	}
+	p.cfg.Health, p.cfg.Outlier = cfg.Health, cfg.Outlier
+	return p.startChecks(ctx)
+}
+
+// update applies the in place changes of d, leading to cfg, to the running
+// pool. d must have passed check, so the backend changes fit the balancer.
+//
+// Removed backends are drained, so their open connections finish. A backend
+// added again while it still drains is taken back with its connections if
+// its address is the same. Otherwise, as for a backend whose address
+// changed, the new one is added once the draining one is gone, so releases
+// and outcomes of the old address never reach it.
+func (p *pool) update(ctx context.Context, cfg config.Pool, d config.PoolDiff) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var errs []error
+	for _, id := range d.RemovedBackends {
+		p.forget(id)
+		if _, ok := p.waiting[id]; ok {
+			delete(p.waiting, id)
+			continue
+		}
+		if err := p.balancer.Drain(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	backends := make(map[string]balancer.Backend, len(cfg.Backends))
+	for _, b := range cfg.BalancerBackends() {
+		backends[b.ID] = b
+	}
+	for _, id := range d.AddedBackends {
+		if err := p.add(ctx, backends[id]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, id := range d.ReweightedBackends {
+		if b, ok := p.waiting[id]; ok {
+			b.Weight = backends[id].Weight
+			p.waiting[id] = b
+			continue
+		}
+		if err := p.balancer.SetWeight(id, backends[id].Weight); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.checker != nil {
+		p.checker.Sync()
+	}
+
+	if d.SettingsChanged {
+		if err := p.applySettings(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.ChecksChanged {
+		if err := p.restartChecks(ctx, cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.cfg = cfg
+	return errors.Join(errs...)
+}
+
+// check reports whether the backend changes of d fit the balancer: removed
+// and reweighted backends must be in it, added ones must not, unless they
+// are draining. Reloads check every updated pool before changing anything.
+func (p *pool) check(d config.PoolDiff) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	statuses := make(map[string]balancer.BackendStatus)
+	for _, status := range p.balancer.Snapshot() {
+		statuses[status.ID] = status
+	}
+	known := func(id string) bool {
+		status, ok := statuses[id]
+		_, waiting := p.waiting[id]
+		return waiting || ok && !status.Draining
+	}
+
+	removed := make(map[string]bool, len(d.RemovedBackends))
+	for _, id := range d.RemovedBackends {
+		if !known(id) {
+			return &lberror.BackendNotFoundError{ID: id}
+		}
+		removed[id] = true
+	}
+	for _, id := range d.AddedBackends {
+		if !removed[id] && known(id) {
+			return &lberror.DuplicateBackendError{ID: id}
+		}
+	}
+	for _, id := range d.ReweightedBackends {
+		if !known(id) {
+			return &lberror.BackendNotFoundError{ID: id}
+		}
+	}
+	return nil
+}
+
+// add adds b to the balancer. If a draining backend still holds its ID, it
+// is taken back when it has the same address, and b waits for it to be gone
+// otherwise. It must be called with p.mutex held.
+func (p *pool) add(ctx context.Context, b balancer.Backend) error {
+	var duplicate *lberror.DuplicateBackendError
+	err := p.balancer.AddBackend(b)
+	if !errors.As(err, &duplicate) {
+		return err
+	}
+
+	status, ok := p.status(b.ID)
+	switch {
+	case !ok:
+		// Drained since.
+		return p.balancer.AddBackend(b)
+	case !status.Draining:
+		return err
+	case status.Address != b.Address:
+		p.wait(ctx, b)
+		return nil
+	}
+
+	var notFound *lberror.BackendNotFoundError
+	if err := p.balancer.Undrain(b.ID); errors.As(err, &notFound) {
+		return p.balancer.AddBackend(b)
+	} else if err != nil {
+		return err
+	}
+	if status.Weight != b.Weight {
+		return p.balancer.SetWeight(b.ID, b.Weight)
+	}
+	return nil
+}
+
+// status returns the state of the backend with the given ID in the balancer.
+func (p *pool) status(id string) (balancer.BackendStatus, bool) {
+	for _, status := range p.balancer.Snapshot() {
+		if status.ID == id {
+			return status, true
+		}
+	}
+	return balancer.BackendStatus{}, false
+}
+
+// wait adds b once the draining backend holding its ID is gone, checking
+// every waitInterval until ctx ends or the pool is retired. It must be called
+// with p.mutex held.
+func (p *pool) wait(ctx context.Context, b balancer.Backend) {
+	if p.waiting == nil {
+		p.waiting = make(map[string]balancer.Backend)
+	}
+	p.waiting[b.ID] = b
+	if len(p.waiting) > 1 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(waitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			p.mutex.Lock()
+			for id, b := range p.waiting {
+				if _, ok := p.status(id); ok {
+					continue
+				}
+				// Outcomes of the old address came in while it drained.
+				p.forget(id)
+				if err := p.balancer.AddBackend(b); err != nil {
+					fmt.Printf("Failed to add backend %s/%s after it drained: %v\n", p.cfg.Name, id, err)
+				}
+				delete(p.waiting, id)
+				if p.checker != nil {
+					p.checker.Sync()
+				}
+			}
+			done := len(p.waiting) == 0
+			p.mutex.Unlock()
+			if done {
+				return
+			}
+		}
+	}()
+}
+
+// forget drops what outlier detection knows about the backend with the
+// given ID, so removed backends do not count toward its ejection limit and
+// a backend added at a new address starts afresh. It must be called with
+// p.mutex held.
+func (p *pool) forget(id string) {
+	if p.detector != nil {
+		p.detector.Forget(id)
+	}
+}
+
+// retire stops the checks of a pool no longer in use and forgets the
+// backends waiting to be added.
+func (p *pool) retire() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopChecks()
+	clear(p.waiting)
+}
+
+// outlierConfig returns the outlier package defaults overridden by the
+// non-zero fields of o.
+func outlierConfig(o config.Outlier) outlier.Config {
+	c := outlier.DefaultConfig()
+	if o.ConsecutiveErrors > 0 {
+		c.ConsecutiveErrors = o.ConsecutiveErrors
+	}
+	if o.EjectionTime > 0 {
+		c.BaseEjectionTime = o.EjectionTime
+	}
+	if o.MaxEjectionTime > 0 {
+		c.MaxEjectionTime = o.MaxEjectionTime
+	}
+	if o.MaxEjectionPercent > 0 {
+		c.MaxEjectionPercent = o.MaxEjectionPercent
+	}
+	return c
+}
+
+// logHealthEvents prints every backend state change of the pool until events is closed.
+func logHealthEvents(pool string, events <-chan health.Event) {
+	for event := range events {
+		if event.Healthy {
+			fmt.Printf("Backend %s/%s is up\n", pool, event.BackendID)
+		} else {
+			fmt.Printf("Backend %s/%s is down: %v\n", pool, event.BackendID, event.Err)
+		}
+	}
+}
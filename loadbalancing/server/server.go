@@ -0,0 +1,500 @@
+// Package server runs the listeners and pools of a configuration and
+// reloads it while it keeps serving.
+//
+// A reload compares the running configuration with the new one (see
+// config.Compare) and applies only the differences:
+//   - Backends added to a pool join its balancer, removed ones are drained
+//     and changed weights are set in place, so the state of every backend
+//     that survives, such as its connection count, carries over.
+//   - A pool whose algorithm changed gets a new balancer. Connections already
+//     open are released to the old one; the new one starts from zero.
+//   - Listeners that only change their pool keep their socket. Listeners
+//     whose address, protocol or timeouts changed are rebound; their open
+//     TCP and HTTP connections finish on the old socket, while UDP flows end.
+//
+// Everything that can fail, checking backend changes against the running
+// balancers, building balancers and binding new sockets, is done before
+// anything changes, so a rejected reload leaves the running configuration
+// untouched.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"sysdesign/loadbalancing/accesslog"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	lberror "sysdesign/loadbalancing/error"
+	"sysdesign/loadbalancing/health"
+	"sysdesign/loadbalancing/metrics"
+)
+
+// Server serves a configuration until its Run context ends.
+type Server struct {
+	// DrainTimeout bounds how long the connections of a removed, replaced or
+	// stopped listener may take to finish before they are closed.
+	DrainTimeout time.Duration
+	// Metrics, when set before Run, records the picks, outcomes and ejections
+	// of every pool.
+	Metrics *metrics.Registry
+	// AccessLog, when set before Run, gets an entry for every request of
+	// every http listener.
+	AccessLog accesslog.Sink
+
+	mutex     sync.Mutex
+	ctx       context.Context // Context of Run, nil before Run and after it returned
+	cfg       *config.Config
+	pools     map[string]*pool
+	listeners map[string]*listener
+	errs      chan error     // First listener failure
+	draining  sync.WaitGroup // Listeners still draining
+}
+
+// New creates a Server for a configuration returned by config.Load or
+// checked with Config.Validate.
+//
+// Parameters:
+//   - cfg: The configuration served by Run
+//
+// Returns:
+//   - *Server: A pointer to the new Server instance
+func New(cfg *config.Config) *Server {
+	return &Server{
+		DrainTimeout: 30 * time.Second,
+		cfg:          cfg,
+		pools:        make(map[string]*pool),
+		listeners:    make(map[string]*listener),
+		errs:         make(chan error, 1),
+	}
+}
+
+// Run starts every pool and listener and serves until ctx is cancelled or a
+// listener fails. It then drains every listener and stops the pools.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mutex.Lock()
+	if s.ctx != nil {
+		s.mutex.Unlock()
+		return errors.New("server is already running")
+	}
+	s.ctx = ctx
+	err := s.start()
+	s.mutex.Unlock()
+
+	if err == nil {
+		select {
+		case <-ctx.Done():
+		case err = <-s.errs:
+		}
+	}
+	s.stop()
+	return err
+}
+
+// start starts the pools and listeners of the configuration. It must be
+// called with the mutex held.
+func (s *Server) start() error {
+	for _, pc := range s.cfg.Pools {
+		p, err := newPool(pc, s.Metrics)
+		if err != nil {
+			return fmt.Errorf("pool %s: %v", pc.Name, err)
+		}
+		s.pools[pc.Name] = p
+		if err := p.startChecks(s.ctx); err != nil {
+			return fmt.Errorf("pool %s: %v", pc.Name, err)
+		}
+	}
+	for _, lc := range s.cfg.Listeners {
+		socket, err := bind(lc)
+		if err != nil {
+			return fmt.Errorf("listener %s: %v", lc.Name, err)
+		}
+		s.startListener(lc, socket)
+	}
+	return nil
+}
+
+// startListener serves lc on socket. It must be called with the mutex held.
+func (s *Server) startListener(lc config.Listener, socket io.Closer) {
+	p := s.pools[lc.Pool]
+	l := newListener(lc, p, socket, s.AccessLog)
+	s.listeners[lc.Name] = l
+	l.serve(s.errs)
+	fmt.Printf("Proxying %s (%s) to pool %s of %d backends using %s\n", l.Addr(), lc.Protocol, lc.Pool, len(p.balancer.Snapshot()), p.balancer.Name())
+}
+
+// stop drains every listener, waits for them and stops every pool.
+func (s *Server) stop() {
+	s.mutex.Lock()
+	for name, l := range s.listeners {
+		s.drainListener(l)
+		delete(s.listeners, name)
+	}
+	for name, p := range s.pools {
+		p.retire()
+		delete(s.pools, name)
+	}
+	s.ctx = nil
+	s.mutex.Unlock()
+
+	s.draining.Wait()
+}
+
+// drainListener drains l in the background for at most DrainTimeout. Its
+// socket is closed before drainListener returns.
+func (s *Server) drainListener(l *listener) {
+	l.close()
+	s.draining.Add(1)
+	go func() {
+		defer s.draining.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+		defer cancel()
+		if err := l.drain(ctx); err != nil {
+			fmt.Printf("Listener %s closed connections still open after %v: %v\n", l.cfg.Name, s.DrainTimeout, err)
+		}
+	}()
+}
+
+// Config returns the configuration being served.
+func (s *Server) Config() *config.Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cfg
+}
+
+// Addr returns the address the named listener is bound to, or nil if there
+// is no such listener running. It resolves the ports of addresses like ":0".
+func (s *Server) Addr(name string) net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if l, ok := s.listeners[name]; ok {
+		return l.Addr()
+	}
+	return nil
+}
+
+// Reload switches the running server to cfg, applying only what changed.
+// An invalid cfg, or one whose pools cannot be built or whose new listeners
+// cannot bind, is rejected and the running configuration is kept.
+//
+// Parameters:
+//   - cfg: The new configuration, validated and completed by Reload if needed
+//
+// Returns:
+//   - config.Diff: What changed, empty if the reload was rejected
+//   - error: Why the reload was rejected, or what failed while applying it
+func (s *Server) Reload(cfg *config.Config) (config.Diff, error) {
+	if err := cfg.Validate(); err != nil {
+		return config.Diff{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reload(cfg)
+}
+
+// reload switches the running server to the validated cfg. It must be called
+// with the mutex held.
+func (s *Server) reload(cfg *config.Config) (config.Diff, error) {
+	if s.ctx == nil {
+		return config.Diff{}, errors.New("server is not running")
+	}
+
+	d := config.Compare(s.cfg, cfg)
+	pools, sockets, err := s.prepare(cfg, d)
+	if err != nil {
+		for _, socket := range sockets {
+			socket.Close()
+		}
+		return config.Diff{}, err
+	}
+	return d, s.commit(cfg, d, pools, sockets)
+}
+
+// AddBackend adds a backend to the named pool of the running configuration,
+// as a reload adding it would: it is health checked, and a later reload
+// without it drains it.
+func (s *Server) AddBackend(pool string, backend balancer.Backend) error {
+	return s.changeBackends(pool, func(backends []config.Backend) ([]config.Backend, error) {
+		for _, b := range backends {
+			if b.ID == backend.ID {
+				return nil, &lberror.DuplicateBackendError{ID: backend.ID}
+			}
+		}
+		return append(backends, config.Backend{ID: backend.ID, Address: backend.Address, Weight: backend.Weight}), nil
+	})
+}
+
+// RemoveBackend removes a backend from the named pool of the running
+// configuration. It is drained, so its open connections finish.
+func (s *Server) RemoveBackend(pool, id string) error {
+	return s.changeBackends(pool, func(backends []config.Backend) ([]config.Backend, error) {
+		for i, b := range backends {
+			if b.ID == id {
+				return slices.Delete(backends, i, i+1), nil
+			}
+		}
+		return nil, &lberror.BackendNotFoundError{ID: id}
+	})
+}
+
+// SetWeight changes the weight of a backend of the named pool in the running
+// configuration.
+func (s *Server) SetWeight(pool, id string, weight int) error {
+	return s.changeBackends(pool, func(backends []config.Backend) ([]config.Backend, error) {
+		for i := range backends {
+			if backends[i].ID == id {
+				backends[i].Weight = weight
+				return backends, nil
+			}
+		}
+		return nil, &lberror.BackendNotFoundError{ID: id}
+	})
+}
+
+// changeBackends reloads the running configuration with the backends of the
+// named pool replaced by what change returns for a copy of them.
+func (s *Server) changeBackends(pool string, change func([]config.Backend) ([]config.Backend, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx == nil {
+		return errors.New("server is not running")
+	}
+
+	cfg := *s.cfg
+	cfg.Pools = slices.Clone(s.cfg.Pools)
+	pc := cfg.Pool(pool)
+	if pc == nil {
+		return &lberror.PoolNotFoundError{Name: pool}
+	}
+	backends, err := change(slices.Clone(pc.Backends))
+	if err != nil {
+		return err
+	}
+	pc.Backends = backends
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	_, err = s.reload(&cfg)
+	return err
+}
+
+// prepare checks the backend changes of updated pools against their
+// balancers, builds the pools and binds the listeners a reload adds or
+// replaces. The sockets bound so far are returned even on error, so the
+// caller can close them. Replaced listeners keeping their address are bound
+// by commit, once the old socket is closed.
+func (s *Server) prepare(cfg *config.Config, d config.Diff) (map[string]*pool, map[string]io.Closer, error) {
+	pools := make(map[string]*pool)
+	for _, pd := range d.Pools {
+		if pd.Change == config.Updated {
+			if err := s.pools[pd.Name].check(pd); err != nil {
+				return nil, nil, fmt.Errorf("pool %s: %v", pd.Name, err)
+			}
+			continue
+		}
+		if pd.Change != config.Added && pd.Change != config.Replaced {
+			continue
+		}
+		p, err := newPool(*cfg.Pool(pd.Name), s.Metrics)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pool %s: %v", pd.Name, err)
+		}
+		pools[pd.Name] = p
+	}
+
+	sockets := make(map[string]io.Closer)
+	for _, ld := range d.Listeners {
+		if ld.Change != config.Added && ld.Change != config.Replaced {
+			continue
+		}
+		lc := listenerConfig(cfg, ld.Name)
+		if old, ok := s.listeners[ld.Name]; ok && network(old.cfg) == network(lc) && old.cfg.Address == lc.Address {
+			continue
+		}
+		socket, err := bind(lc)
+		if err != nil {
+			return nil, sockets, fmt.Errorf("listener %s: %v", ld.Name, err)
+		}
+		sockets[ld.Name] = socket
+	}
+	return pools, sockets, nil
+}
+
+// commit applies a prepared reload. Failures at this point are unexpected;
+// they are returned together while the rest of the reload is still applied.
+// A listener that could not be rebound is dropped from the running
+// configuration, so a later reload adds it again.
+func (s *Server) commit(cfg *config.Config, d config.Diff, pools map[string]*pool, sockets map[string]io.Closer) error {
+	var errs []error
+	var retired []*pool
+
+	for _, pd := range d.Pools {
+		switch pd.Change {
+		case config.Added, config.Replaced:
+			if old, ok := s.pools[pd.Name]; ok {
+				retired = append(retired, old)
+			}
+			p := pools[pd.Name]
+			if err := p.startChecks(s.ctx); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %v", pd.Name, err))
+			}
+			s.pools[pd.Name] = p
+		case config.Updated:
+			if err := s.pools[pd.Name].update(s.ctx, *cfg.Pool(pd.Name), pd); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %v", pd.Name, err))
+			}
+		case config.Removed:
+			retired = append(retired, s.pools[pd.Name])
+			delete(s.pools, pd.Name)
+		}
+	}
+
+	var failed []string
+	for _, ld := range d.Listeners {
+		switch ld.Change {
+		case config.Updated:
+			s.listeners[ld.Name].update(listenerConfig(cfg, ld.Name))
+		case config.Removed:
+			s.drainListener(s.listeners[ld.Name])
+			delete(s.listeners, ld.Name)
+		case config.Added, config.Replaced:
+			if old, ok := s.listeners[ld.Name]; ok {
+				s.drainListener(old)
+				delete(s.listeners, ld.Name)
+			}
+			lc := listenerConfig(cfg, ld.Name)
+			socket, ok := sockets[ld.Name]
+			if !ok {
+				var err error
+				if socket, err = bind(lc); err != nil {
+					errs = append(errs, fmt.Errorf("listener %s: %v", ld.Name, err))
+					failed = append(failed, ld.Name)
+					continue
+				}
+			}
+			s.startListener(lc, socket)
+		}
+	}
+
+	// Point every listener at the current balancer and observer of its pool,
+	// which changed for replaced pools and for pools whose checks restarted.
+	for _, l := range s.listeners {
+		p := s.pools[l.cfg.Pool]
+		l.route.Swap(p.routed, p.observer)
+	}
+	for _, p := range retired {
+		p.retire()
+	}
+
+	s.cfg = cfg
+	if len(failed) > 0 {
+		s.cfg = withoutListeners(cfg, failed)
+	}
+	return errors.Join(errs...)
+}
+
+// listenerConfig returns the listener of cfg with the given name.
+func listenerConfig(cfg *config.Config, name string) config.Listener {
+	for _, l := range cfg.Listeners {
+		if l.Name == name {
+			return l
+		}
+	}
+	return config.Listener{}
+}
+
+// withoutListeners returns a copy of cfg without the named listeners.
+func withoutListeners(cfg *config.Config, names []string) *config.Config {
+	c := *cfg
+	c.Listeners = nil
+	for _, l := range cfg.Listeners {
+		skip := false
+		for _, name := range names {
+			skip = skip || l.Name == name
+		}
+		if !skip {
+			c.Listeners = append(c.Listeners, l)
+		}
+	}
+	return &c
+}
+
+// Pools returns the names of the running pools, sorted.
+func (s *Server) Pools() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.pools))
+	for name := range s.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Balancer returns the balancer of the named pool. Change its backends with
+// AddBackend, RemoveBackend and SetWeight instead of through the balancer,
+// so the running configuration, health checks and outlier detection follow.
+func (s *Server) Balancer(pool string) (balancer.Balancer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p, ok := s.pools[pool]
+	if !ok {
+		return nil, &lberror.PoolNotFoundError{Name: pool}
+	}
+	return p.routed, nil
+}
+
+// Traffic returns what every running listener relayed to each backend.
+// Counts start over for listeners a reload replaced or whose HTTP settings
+// changed.
+func (s *Server) Traffic() []metrics.Traffic {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var traffic []metrics.Traffic
+	for _, l := range s.listeners {
+		traffic = append(traffic, l.traffic()...)
+	}
+	return traffic
+}
+
+// Health returns the health checking state of every probed backend of the
+// named pool, empty if the pool has no health checks.
+func (s *Server) Health(pool string) (map[string]health.Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p, ok := s.pools[pool]
+	if !ok {
+		return nil, &lberror.PoolNotFoundError{Name: pool}
+	}
+	if p.checker == nil {
+		return map[string]health.Status{}, nil
+	}
+	return p.checker.Statuses(), nil
+}
+
+// CheckNow probes the backend of the named pool at once instead of waiting
+// for its interval. Backends added since the last interval are not probed yet
+// and are reported as not found.
+func (s *Server) CheckNow(pool, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p, ok := s.pools[pool]
+	if !ok {
+		return &lberror.PoolNotFoundError{Name: pool}
+	}
+	if p.checker == nil {
+		return &lberror.NoHealthChecksError{Pool: pool}
+	}
+	return p.checker.CheckNow(id)
+}
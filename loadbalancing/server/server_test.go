@@ -0,0 +1,475 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/config"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// start runs a server for the configuration until the test ends.
+func start(t *testing.T, data string) *Server {
+	t.Helper()
+	cfg, err := config.Parse("lb.yaml", []byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := New(cfg)
+	s.DrainTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error from Run: %v", err)
+		}
+	})
+
+	for deadline := time.Now().Add(time.Second); s.Addr(cfg.Listeners[0].Name) == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return s
+}
+
+// reload parses the configuration and reloads the server with it.
+func reload(t *testing.T, s *Server, data string) (config.Diff, error) {
+	t.Helper()
+	cfg, err := config.Parse("lb.yaml", []byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s.Reload(cfg)
+}
+
+// connections returns the connection count of every backend of the pool.
+func connections(s *Server, pool string) map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	counts := make(map[string]int)
+	for _, status := range s.pools[pool].balancer.Snapshot() {
+		counts[status.ID] = status.Connections
+	}
+	return counts
+}
+
+const leastConnection = `listeners:
+  - name: public
+    address: "127.0.0.1:0"
+    protocol: tcp
+    pool: web
+pools:
+  - name: web
+    algorithm: least_connection
+    backends:
+      - {id: a, address: "127.0.0.1:8081"}
+      - {id: b, address: "127.0.0.1:8082"}
+`
+
+func TestReloadKeepsConnectionCounts(t *testing.T) {
+	s := start(t, leastConnection)
+	route := s.listeners["public"].route
+	for i := 0; i < 4; i++ {
+		if _, err := route.Pick(&balancer.Request{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	d, err := reload(t, s, leastConnection+`      - {id: c, address: "127.0.0.1:8083"}
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.Pools) != 1 || d.Pools[0].Change != config.Updated {
+		t.Fatalf("expected the pool to be updated in place, got %v", d)
+	}
+
+	want := map[string]int{"a": 2, "b": 2, "c": 0}
+	if got := connections(s, "web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected connection counts %v to carry over, got %v", want, got)
+	}
+
+	// The new backend is idle, so least connection sends the next picks to it.
+	backend, err := route.Pick(&balancer.Request{})
+	if err != nil || backend.ID != "c" {
+		t.Errorf("expected the idle new backend, got %v, %v", backend, err)
+	}
+}
+
+func TestReloadDrainsRemovedBackends(t *testing.T) {
+	s := start(t, leastConnection)
+	route := s.listeners["public"].route
+	var picked []*balancer.Backend
+	for i := 0; i < 2; i++ {
+		backend, err := route.Pick(&balancer.Request{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		picked = append(picked, backend)
+	}
+
+	if _, err := reload(t, s, strings.Replace(leastConnection, `      - {id: b, address: "127.0.0.1:8082"}
+`, "", 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// b keeps its open connection while draining and leaves once it ends.
+	if got := connections(s, "web"); got["b"] != 1 {
+		t.Fatalf("expected b to drain its connection, got %v", got)
+	}
+	for i := 0; i < 3; i++ {
+		if backend, _ := route.Pick(&balancer.Request{}); backend.ID != "a" {
+			t.Fatalf("expected no new connections to the draining backend, got %s", backend.ID)
+		}
+	}
+	for _, backend := range picked {
+		route.Release(backend)
+	}
+	if _, ok := connections(s, "web")["b"]; ok {
+		t.Errorf("expected b to be removed once drained")
+	}
+}
+
+func TestReloadTakesBackDrainingBackends(t *testing.T) {
+	s := start(t, leastConnection)
+	route := s.listeners["public"].route
+	held, err := route.Pick(&balancer.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other := strings.NewReplacer(`{id: a, address: "127.0.0.1:8081"}`, `{id: x, address: "127.0.0.1:8089"}`, `      - {id: b, address: "127.0.0.1:8082"}
+`, "").Replace(leastConnection)
+	if _, err := reload(t, s, other); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reload(t, s, leastConnection); err != nil {
+		t.Fatalf("unexpected error adding back the draining %s: %v", held.ID, err)
+	}
+
+	want := map[string]int{"a": 0, "b": 0}
+	want[held.ID] = 1
+	if got := connections(s, "web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	route.Release(held)
+	if got := connections(s, "web"); len(got) != 2 {
+		t.Errorf("expected %s kept after its last release, got %v", held.ID, got)
+	}
+}
+
+func TestReloadWaitsForChangedAddressToDrain(t *testing.T) {
+	s := start(t, leastConnection)
+	route := s.listeners["public"].route
+	held, err := route.Pick(&balancer.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	moved := strings.Replace(leastConnection, "127.0.0.1:808", "127.0.0.1:809", -1)
+	if _, err := reload(t, s, moved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The idle backend moves at once, the held one keeps its old address
+	// until its connection ends.
+	for _, status := range s.pools["web"].balancer.Snapshot() {
+		if status.ID == held.ID && (status.Address != held.Address || !status.Draining) {
+			t.Errorf("expected %s to drain at its old address, got %+v", held.ID, status)
+		}
+		if status.ID != held.ID && status.Address == held.Address {
+			t.Errorf("expected %s at its new address, got %+v", status.ID, status)
+		}
+	}
+
+	route.Release(held)
+	if got := connections(s, "web"); got[held.ID] != 0 {
+		t.Errorf("expected the release of the old address not to reach the new one, got %v", got)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status, ok := s.pools["web"].status(held.ID)
+		if ok && status.Address != held.Address && !status.Draining {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s added at its new address once drained, got %+v", held.ID, status)
+		}
+	}
+}
+
+func TestReloadForgetsRemovedBackendOutliers(t *testing.T) {
+	withOutliers := leastConnection + `    outlier: {consecutive_errors: 1}
+`
+	s := start(t, withOutliers)
+	route := s.listeners["public"].route
+	route.Observe(balancer.Outcome{Backend: &balancer.Backend{ID: "b"}, Err: errors.New("connection refused")})
+	if !s.pools["web"].detector.Stats()["b"].Ejected {
+		t.Fatal("expected b to be ejected")
+	}
+
+	if _, err := reload(t, s, strings.Replace(withOutliers, `      - {id: b, address: "127.0.0.1:8082"}
+`, "", 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats, ok := s.pools["web"].detector.Stats()["b"]; ok {
+		t.Errorf("expected outlier detection to forget the removed b, got %+v", stats)
+	}
+}
+
+func TestReloadRejectsUpdatesNotFittingTheBalancer(t *testing.T) {
+	s := start(t, leastConnection)
+	s.pools["web"].balancer.RemoveBackend("b")
+
+	changed := strings.NewReplacer(`8081"}`, `8081", weight: 3}`, `8082"}`, `8082", weight: 3}
+      - {id: c, address: "127.0.0.1:8083"}`).Replace(leastConnection)
+	before := s.Config()
+	d, err := reload(t, s, changed)
+	if err == nil || !strings.Contains(err.Error(), `backend "b" not found`) || !d.Empty() {
+		t.Fatalf("expected the reload to be rejected as b is missing, got %v, %v", d, err)
+	}
+	if s.Config() != before {
+		t.Error("expected the running configuration to be kept")
+	}
+	want := map[string]int{"a": 0}
+	if got := connections(s, "web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the pool untouched, got %v", got)
+	}
+	for _, status := range s.pools["web"].balancer.Snapshot() {
+		if status.Weight != 1 {
+			t.Errorf("expected %s to keep its weight, got %d", status.ID, status.Weight)
+		}
+	}
+}
+
+func TestReloadSwapsAlgorithm(t *testing.T) {
+	s := start(t, leastConnection)
+	route := s.listeners["public"].route
+	backend, err := route.Pick(&balancer.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.mutex.Lock()
+	old := s.pools["web"].balancer
+	s.mutex.Unlock()
+
+	d, err := reload(t, s, strings.Replace(leastConnection, "least_connection", "round_robin", 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.Pools) != 1 || d.Pools[0].Change != config.Replaced {
+		t.Fatalf("expected the pool to be replaced, got %v", d)
+	}
+	if name := route.Name(); name != "round_robin" {
+		t.Errorf("expected the listener to use round_robin, got %s", name)
+	}
+
+	// The connection opened before the reload ends on the balancer that picked it.
+	route.Release(backend)
+	for _, status := range old.Snapshot() {
+		if status.Connections != 0 {
+			t.Errorf("expected the old balancer to see its connection released, got %+v", status)
+		}
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	s := start(t, leastConnection)
+	running := s.Config()
+
+	invalid := strings.Replace(leastConnection, "least_connection", "fastest", 1)
+	if _, err := config.Parse("lb.yaml", []byte(invalid)); err == nil {
+		t.Fatal("expected the unknown algorithm to be invalid")
+	}
+	cfg := &config.Config{Listeners: running.Listeners, Pools: []config.Pool{{Name: "web", Algorithm: "fastest"}}}
+	if _, err := s.Reload(cfg); err == nil {
+		t.Error("expected the invalid configuration to be rejected")
+	}
+
+	// A listener that cannot bind rejects the whole reload.
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	unbindable := leastConnection + `  - name: api
+    algorithm: round_robin
+    backends:
+      - address: "127.0.0.1:7000"
+`
+	unbindable = strings.Replace(unbindable, "pools:", `  - name: api
+    address: "`+busy.Addr().String()+`"
+    pool: api
+pools:`, 1)
+	if _, err := reload(t, s, unbindable); err == nil {
+		t.Error("expected a listener on a busy address to be rejected")
+	}
+
+	if s.Config() != running {
+		t.Error("expected the running configuration to be kept")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pools["api"]; ok {
+		t.Error("expected the pool of the rejected reload not to run")
+	}
+}
+
+func TestReloadRoutesHTTP(t *testing.T) {
+	backend := func(body string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	blue, green := backend("blue"), backend("green")
+
+	data := `listeners:
+  - name: public
+    address: "127.0.0.1:0"
+    pool: blue
+pools:
+  - name: blue
+    algorithm: round_robin
+    backends:
+      - address: "` + blue.Listener.Addr().String() + `"
+  - name: green
+    algorithm: round_robin
+    backends:
+      - address: "` + green.Listener.Addr().String() + `"
+`
+	s := start(t, data)
+	addr := s.Addr("public").String()
+
+	get := func() string {
+		t.Helper()
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := get(); got != "blue" {
+		t.Fatalf("expected blue, got %q", got)
+	}
+
+	if _, err := reload(t, s, strings.Replace(data, "pool: blue", "pool: green", 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.Addr("public").String(); got != addr {
+		t.Errorf("expected the listener to keep its socket %s, got %s", addr, got)
+	}
+	if got := get(); got != "green" {
+		t.Errorf("expected green after the reload, got %q", got)
+	}
+}
+
+func TestPoolLookups(t *testing.T) {
+	s := start(t, leastConnection)
+
+	if got := s.Pools(); !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("expected the web pool, got %v", got)
+	}
+	if b, err := s.Balancer("web"); err != nil || b.Name() != "least_connection" {
+		t.Errorf("expected the least_connection balancer, got %v, %v", b, err)
+	}
+
+	var notFound *lberror.PoolNotFoundError
+	if _, err := s.Balancer("api"); !errors.As(err, &notFound) {
+		t.Errorf("expected PoolNotFoundError, got %v", err)
+	}
+	var noChecks *lberror.NoHealthChecksError
+	if err := s.CheckNow("web", "a"); !errors.As(err, &noChecks) {
+		t.Errorf("expected NoHealthChecksError, got %v", err)
+	}
+}
+
+func TestHTTPListenerTimeouts(t *testing.T) {
+	s := start(t, `listeners:
+  - name: public
+    address: "127.0.0.1:0"
+    pool: web
+    timeouts:
+      header: 50ms
+pools:
+  - name: web
+    algorithm: round_robin
+    backends:
+      - address: "127.0.0.1:8081"
+`)
+	if got := s.listeners["public"].http.IdleTimeout; got != defaultHTTPIdle {
+		t.Errorf("expected the default idle timeout %v, got %v", defaultHTTPIdle, got)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr("public").String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: slow\r\n")
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("expected the proxy to close a connection slow to send its headers, got %v", err)
+	}
+}
+
+func TestHTTPHealthCheckMatchesBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "starting")
+	}))
+	t.Cleanup(backend.Close)
+
+	s := start(t, `listeners:
+  - name: public
+    address: "127.0.0.1:0"
+    pool: web
+pools:
+  - name: web
+    algorithm: round_robin
+    backends:
+      - {id: a, address: "`+backend.Listener.Addr().String()+`"}
+    health:
+      kind: http
+      body: ready
+      interval: 20ms
+      fall: 1
+`)
+	b, err := s.Balancer("web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); b.Snapshot()[0].Healthy; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a backend whose body does not match to be marked down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewPoolRejectsMetricWithoutP2C(t *testing.T) {
+	_, err := newPool(config.Pool{
+		Name:      "web",
+		Algorithm: "round_robin",
+		Backends:  []config.Backend{{ID: "a", Address: "127.0.0.1:8081", Weight: 1}},
+		P2CMetric: "latency",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "does not use a metric") {
+		t.Errorf("expected a metric on round_robin to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteJSON writes reports as an indented JSON array, series and
+// distributions included.
+func WriteJSON(w io.Writer, reports []*Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
+
+// WriteSeriesCSV writes the sampled server state of reports as CSV, one row
+// per algorithm, sample and server:
+//
+//	algorithm,time_s,server,load,connections
+func WriteSeriesCSV(w io.Writer, reports []*Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"algorithm", "time_s", "server", "load", "connections"})
+	for _, r := range reports {
+		for _, sample := range r.Series {
+			for i, s := range sample.Servers {
+				cw.Write([]string{
+					r.Algorithm,
+					formatSeconds(sample.Time),
+					r.Servers[i].ID,
+					strconv.FormatFloat(s.Load, 'f', 4, 64),
+					strconv.Itoa(s.Connections),
+				})
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLatencyCSV writes the latency distributions of reports as CSV, one
+// row per algorithm and quantile:
+//
+//	algorithm,fraction,queue_delay_ms,response_ms
+func WriteLatencyCSV(w io.Writer, reports []*Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"algorithm", "fraction", "queue_delay_ms", "response_ms"})
+	for _, r := range reports {
+		for i, q := range r.ResponseCDF {
+			cw.Write([]string{
+				r.Algorithm,
+				strconv.FormatFloat(q.Fraction, 'f', -1, 64),
+				formatMilliseconds(r.QueueDelayCDF[i].Latency),
+				formatMilliseconds(q.Latency),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func compareForExport(t *testing.T) []*Report {
+	t.Helper()
+	cfg := testConfig("")
+	cfg.Workload.Requests = 2000
+	reports, err := Compare(cfg, "round_robin", "least_connection")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return reports
+}
+
+func TestWriteSeriesCSV(t *testing.T) {
+	reports := compareForExport(t)
+	var buf bytes.Buffer
+	if err := WriteSeriesCSV(&buf, reports); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV: %v", err)
+	}
+	if got := strings.Join(rows[0], ","); got != "algorithm,time_s,server,load,connections" {
+		t.Errorf("unexpected header %q", got)
+	}
+	want := 1
+	for _, r := range reports {
+		want += len(r.Series) * len(r.Servers)
+	}
+	if len(rows) != want {
+		t.Errorf("expected %d rows, got %d", want, len(rows))
+	}
+	if rows[1][0] != "round_robin" || rows[1][2] != "a" {
+		t.Errorf("expected the first row for round_robin and server a, got %v", rows[1])
+	}
+}
+
+func TestWriteLatencyCSV(t *testing.T) {
+	reports := compareForExport(t)
+	var buf bytes.Buffer
+	if err := WriteLatencyCSV(&buf, reports); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV: %v", err)
+	}
+	if want := 1 + len(reports[0].ResponseCDF) + len(reports[1].ResponseCDF); len(rows) != want {
+		t.Errorf("expected %d rows, got %d", want, len(rows))
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	reports := compareForExport(t)
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, reports); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded []*Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON: %v", err)
+	}
+	if len(decoded) != 2 || decoded[1].Algorithm != "least_connection" || len(decoded[1].Series) != len(reports[1].Series) {
+		t.Errorf("expected the reports to round trip, got %d reports", len(decoded))
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	reports := compareForExport(t)
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, "Test <scenario>", reports); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	page := buf.String()
+
+	if !strings.Contains(page, "<h1>Test &lt;scenario&gt;</h1>") {
+		t.Error("expected the escaped title as heading")
+	}
+	// Two distributions, then load and connections per algorithm.
+	svgs := regexp.MustCompile(`(?s)<svg.*?</svg>`).FindAllString(page, -1)
+	if want := 2 + 2*len(reports); len(svgs) != want {
+		t.Fatalf("expected %d charts, got %d", want, len(svgs))
+	}
+	for _, svg := range svgs {
+		if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+			t.Errorf("expected well-formed SVG: %v", err)
+		}
+	}
+	if !strings.Contains(page, "<title>least_connection</title>") {
+		t.Error("expected a line per algorithm in the distributions")
+	}
+	if strings.Contains(page, "<script") || strings.Contains(page, "src=") || strings.Contains(page, "<link") {
+		t.Error("expected a self-contained page")
+	}
+}
+
+func TestTicks(t *testing.T) {
+	if got := linearTicks(0, 1); len(got) != 6 || got[5] != 1 {
+		t.Errorf("expected ticks every 0.2 from 0 to 1, got %v", got)
+	}
+	if got := logTicks(1e4, 1e7); len(got) != 4 || got[0] != 1e4 {
+		t.Errorf("expected the powers of ten from 1e4 to 1e7, got %v", got)
+	}
+}
@@ -0,0 +1,312 @@
+package simulator
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// palette colors the lines of a chart, in order.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// Chart dimensions in pixels.
+const (
+	chartWidth   = 460
+	chartHeight  = 240
+	marginLeft   = 56
+	marginRight  = 12
+	marginTop    = 12
+	marginBottom = 40
+)
+
+// latencyFloor is where latencies are drawn on the logarithmic axes of the
+// distribution charts when they are shorter, zero waits included.
+const latencyFloor = 10 * time.Microsecond
+
+type point struct {
+	x, y float64
+}
+
+type line struct {
+	name   string
+	color  string
+	points []point
+}
+
+// chart is a line chart rendered as inline SVG.
+type chart struct {
+	xLabel, yLabel string
+	xLog           bool // Logarithmic x axis, xMin must then be positive
+	xMin, xMax     float64
+	yMin, yMax     float64
+	xFormat        func(float64) string
+	yFormat        func(float64) string
+	lines          []line
+}
+
+// svg renders c with axes, ticks, grid lines and its lines.
+func (c chart) svg() template.HTML {
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+	xScale := func(x float64) float64 {
+		if c.xLog {
+			x, lo, hi := math.Log10(max(x, c.xMin)), math.Log10(c.xMin), math.Log10(c.xMax)
+			return marginLeft + (x-lo)/(hi-lo)*plotWidth
+		}
+		return marginLeft + (x-c.xMin)/(c.xMax-c.xMin)*plotWidth
+	}
+	yScale := func(y float64) float64 {
+		return marginTop + plotHeight - (y-c.yMin)/(c.yMax-c.yMin)*plotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10">`, chartWidth, chartHeight, chartWidth, chartHeight)
+
+	xTicks := linearTicks(c.xMin, c.xMax)
+	if c.xLog {
+		xTicks = logTicks(c.xMin, c.xMax)
+	}
+	for _, t := range xTicks {
+		x := xScale(t)
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" stroke="#e5e5e5"/>`, x, marginTop, x, marginTop+plotHeight)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x, marginTop+plotHeight+14, template.HTMLEscapeString(c.xFormat(t)))
+	}
+	for _, t := range linearTicks(c.yMin, c.yMax) {
+		y := yScale(t)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#e5e5e5"/>`, marginLeft, y, marginLeft+plotWidth, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, marginLeft-4, y, template.HTMLEscapeString(c.yFormat(t)))
+	}
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%.1f" fill="none" stroke="#999"/>`, marginLeft, marginTop, plotWidth, plotHeight)
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, marginLeft+plotWidth/2, chartHeight-6, template.HTMLEscapeString(c.xLabel))
+	fmt.Fprintf(&b, `<text transform="translate(12 %.1f) rotate(-90)" text-anchor="middle">%s</text>`, marginTop+plotHeight/2, template.HTMLEscapeString(c.yLabel))
+
+	for _, l := range c.lines {
+		coords := make([]string, 0, len(l.points))
+		for _, p := range l.points {
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", xScale(p.x), yScale(min(max(p.y, c.yMin), c.yMax))))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"><title>%s</title></polyline>`, l.color, strings.Join(coords, " "), template.HTMLEscapeString(l.name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// linearTicks returns about five evenly spaced round values from lo to hi.
+func linearTicks(lo, hi float64) []float64 {
+	if hi <= lo {
+		return []float64{lo}
+	}
+	raw := (hi - lo) / 5
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude
+	for _, m := range []float64{2, 5, 10} {
+		if raw > step {
+			step = m * magnitude
+		}
+	}
+	var ticks []float64
+	for t := math.Ceil(lo/step) * step; t <= hi+step/1e9; t += step {
+		ticks = append(ticks, t)
+	}
+	return ticks
+}
+
+// logTicks returns the powers of ten from lo to hi.
+func logTicks(lo, hi float64) []float64 {
+	var ticks []float64
+	for t := math.Pow(10, math.Ceil(math.Log10(lo))); t <= hi*(1+1e-9); t *= 10 {
+		ticks = append(ticks, t)
+	}
+	return ticks
+}
+
+// legendEntry is the key of one line of a chart.
+type legendEntry struct {
+	Name  string
+	Color string
+}
+
+// reportView holds everything the HTML template shows.
+type reportView struct {
+	Title        string
+	Reports      []*Report
+	Servers      []ServerReport
+	ResponseCDF  template.HTML
+	QueueCDF     template.HTML
+	Algorithms   []legendEntry
+	ServerLegend []legendEntry
+	Rows         []algorithmView
+}
+
+// algorithmView holds the charts of one algorithm.
+type algorithmView struct {
+	Algorithm   string
+	Load        template.HTML
+	Connections template.HTML
+}
+
+// WriteHTML writes reports of the same scenario as a self-contained HTML
+// page: summary tables, latency distributions of every algorithm and, side
+// by side per algorithm, the load and balancer connections of every server
+// over time. The charts are inline SVG; the page needs no scripts or network.
+//
+// Parameters:
+//   - w: Where the page is written
+//   - title: Heading of the page, usually describing the scenario
+//   - reports: The reports to compare, as returned by Compare
+//
+// Returns:
+//   - error: An error if writing fails
+func WriteHTML(w io.Writer, title string, reports []*Report) error {
+	view := reportView{Title: title, Reports: reports}
+	if len(reports) > 0 {
+		view.Servers = reports[0].Servers
+	}
+	for i, r := range reports {
+		view.Algorithms = append(view.Algorithms, legendEntry{Name: r.Algorithm, Color: palette[i%len(palette)]})
+	}
+	for i, s := range view.Servers {
+		view.ServerLegend = append(view.ServerLegend, legendEntry{Name: s.ID, Color: palette[i%len(palette)]})
+	}
+	view.ResponseCDF = latencyChart(reports, "response time", func(r *Report) []Quantile { return r.ResponseCDF })
+	view.QueueCDF = latencyChart(reports, "queueing delay", func(r *Report) []Quantile { return r.QueueDelayCDF })
+
+	// Equal axes across algorithms keep the charts comparable.
+	var end time.Duration
+	maxConnections := 1
+	for _, r := range reports {
+		if n := len(r.Series); n > 0 {
+			end = max(end, r.Series[n-1].Time)
+		}
+		for _, sample := range r.Series {
+			for _, s := range sample.Servers {
+				maxConnections = max(maxConnections, s.Connections)
+			}
+		}
+	}
+	for _, r := range reports {
+		view.Rows = append(view.Rows, algorithmView{
+			Algorithm:   r.Algorithm,
+			Load:        seriesChart(r, end, "load", 1, func(s ServerSample) float64 { return s.Load }, percent),
+			Connections: seriesChart(r, end, "connections", float64(maxConnections), func(s ServerSample) float64 { return float64(s.Connections) }, integer),
+		})
+	}
+	return htmlReport.Execute(w, view)
+}
+
+// latencyChart draws the distribution of every report returned by of.
+func latencyChart(reports []*Report, label string, of func(*Report) []Quantile) template.HTML {
+	c := chart{
+		xLabel:  label + " (log scale)",
+		yLabel:  "share of requests",
+		xLog:    true,
+		xMin:    float64(latencyFloor),
+		xMax:    float64(10 * latencyFloor),
+		yMax:    1,
+		xFormat: func(x float64) string { return axisDuration(time.Duration(x)) },
+		yFormat: percent,
+	}
+	for i, r := range reports {
+		l := line{name: r.Algorithm, color: palette[i%len(palette)]}
+		for _, q := range of(r) {
+			l.points = append(l.points, point{x: float64(q.Latency), y: q.Fraction})
+			c.xMax = max(c.xMax, float64(q.Latency))
+		}
+		c.lines = append(c.lines, l)
+	}
+	c.xMax = math.Pow(10, math.Ceil(math.Log10(c.xMax)))
+	return c.svg()
+}
+
+// seriesChart draws value of every server of r over time.
+func seriesChart(r *Report, end time.Duration, label string, yMax float64, value func(ServerSample) float64, format func(float64) string) template.HTML {
+	c := chart{
+		xLabel:  "virtual time",
+		yLabel:  label,
+		xMax:    max(end.Seconds(), 1e-9),
+		yMax:    yMax,
+		xFormat: func(x float64) string { return axisDuration(time.Duration(x * float64(time.Second))) },
+		yFormat: format,
+	}
+	for i, s := range r.Servers {
+		l := line{name: s.ID, color: palette[i%len(palette)]}
+		for _, sample := range r.Series {
+			l.points = append(l.points, point{x: sample.Time.Seconds(), y: value(sample.Servers[i])})
+		}
+		c.lines = append(c.lines, l)
+	}
+	return c.svg()
+}
+
+// axisDuration formats a tick of a time axis, in seconds from one second on.
+func axisDuration(d time.Duration) string {
+	if d >= time.Second {
+		return fmt.Sprintf("%gs", round(d).Seconds())
+	}
+	return round(d).String()
+}
+
+func percent(v float64) string {
+	return fmt.Sprintf("%.0f%%", 100*v)
+}
+
+func integer(v float64) string {
+	return fmt.Sprintf("%.0f", v)
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string { return round(d).String() },
+	"percent":  percent,
+	"fixed":    func(v float64) string { return fmt.Sprintf("%.3f", v) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 4px 10px; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; align-items: flex-start; }
+.legend span { display: inline-block; margin-right: 1em; }
+.legend i { display: inline-block; width: 12px; height: 3px; margin-right: 4px; vertical-align: middle; }
+h3 { margin-bottom: 0.3em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<h2>Summary</h2>
+<table>
+<tr><th>algorithm</th><th>wait p50</th><th>wait p99</th><th>wait p99.9</th><th>response p50</th><th>response p99</th><th>response p99.9</th><th>dropped</th><th>fairness</th></tr>
+{{range .Reports}}<tr><td>{{.Algorithm}}</td><td>{{duration .QueueDelay.P50}}</td><td>{{duration .QueueDelay.P99}}</td><td>{{duration .QueueDelay.P999}}</td><td>{{duration .Response.P50}}</td><td>{{duration .Response.P99}}</td><td>{{duration .Response.P999}}</td><td>{{percent .DropRate}}</td><td>{{fixed .Fairness}}</td></tr>
+{{end}}</table>
+
+<h2>Utilization</h2>
+<table>
+<tr><th>algorithm</th>{{range .Servers}}<th>{{.ID}} (capacity {{.Capacity}})</th>{{end}}</tr>
+{{range .Reports}}<tr><td>{{.Algorithm}}</td>{{range .Servers}}<td>{{percent .Utilization}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Latency distributions</h2>
+<p class="legend">{{range .Algorithms}}<span><i style="background: {{.Color}}"></i>{{.Name}}</span>{{end}}</p>
+<div class="charts">
+<div><h3>Response time</h3>{{.ResponseCDF}}</div>
+<div><h3>Queueing delay</h3>{{.QueueCDF}}</div>
+</div>
+
+<h2>Servers over time</h2>
+<p class="legend">{{range .ServerLegend}}<span><i style="background: {{.Color}}"></i>{{.Name}}</span>{{end}}</p>
+{{range .Rows}}<h3>{{.Algorithm}}</h3>
+<div class="charts">
+<div>{{.Load}}</div>
+<div>{{.Connections}}</div>
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,181 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the outcome of one simulation run. Durations encode to JSON as
+// nanoseconds.
+type Report struct {
+	Algorithm  string         `json:"algorithm"`
+	Requests   int            `json:"requests"`    // Requests generated
+	Completed  int            `json:"completed"`   // Requests served
+	Dropped    int            `json:"dropped"`     // Requests rejected by a full queue or for lack of a backend
+	Duration   time.Duration  `json:"duration"`    // Virtual time until the last request was served
+	QueueDelay Percentiles    `json:"queue_delay"` // Time served requests waited for a worker
+	Response   Percentiles    `json:"response"`    // Time from arrival until served, waiting included
+	Servers    []ServerReport `json:"servers"`
+	Fairness   float64        `json:"fairness"` // Jain's fairness index of server utilization, 1 when every server is equally busy
+
+	QueueDelayCDF []Quantile `json:"queue_delay_cdf"` // Distribution of QueueDelay, fine grained in the tail
+	ResponseCDF   []Quantile `json:"response_cdf"`    // Distribution of Response, fine grained in the tail
+	Series        []Sample   `json:"series"`          // State of the servers every Config.SampleInterval
+}
+
+// DropRate returns the share of requests that were dropped.
+func (r *Report) DropRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Dropped) / float64(r.Requests)
+}
+
+// ServerReport is the outcome of a run for one server.
+type ServerReport struct {
+	ID          string  `json:"id"`
+	Capacity    float64 `json:"capacity"`    // Workers times speed
+	Requests    int     `json:"requests"`    // Requests routed to the server, dropped ones included
+	Dropped     int     `json:"dropped"`     // Requests dropped because its queue was full
+	MaxQueue    int     `json:"max_queue"`   // Longest queue seen
+	Utilization float64 `json:"utilization"` // Share of worker time spent serving, 0 to 1
+}
+
+// Percentiles summarizes a latency distribution.
+type Percentiles struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// Quantile is a point of a latency distribution: Fraction of the requests
+// took at most Latency.
+type Quantile struct {
+	Fraction float64       `json:"fraction"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// Sample is the state of the servers at one point in virtual time.
+type Sample struct {
+	Time    time.Duration  `json:"time"`
+	Servers []ServerSample `json:"servers"` // In the order of Report.Servers
+}
+
+// ServerSample is the state of one server in a Sample.
+type ServerSample struct {
+	Load        float64 `json:"load"`        // Share of its workers busy since the previous sample, 0 to 1
+	Connections int     `json:"connections"` // Requests the balancer counts as open, served and queued
+}
+
+// Summarize summarizes samples by the nearest rank method. samples is sorted
+// in place.
+func Summarize(samples []time.Duration) Percentiles {
+	if len(samples) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(samples)
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	rank := func(q float64) time.Duration {
+		i := int(q*float64(len(samples))+0.5) - 1
+		return samples[min(max(i, 0), len(samples)-1)]
+	}
+	return Percentiles{
+		Mean: sum / time.Duration(len(samples)),
+		P50:  rank(0.50),
+		P90:  rank(0.90),
+		P99:  rank(0.99),
+		P999: rank(0.999),
+		Max:  samples[len(samples)-1],
+	}
+}
+
+// cdf returns the distribution of samples, sorted ascending, in steps of 1%
+// up to the 90th percentile, 0.1% up to the 99th and 0.01% beyond.
+func cdf(sorted []time.Duration) []Quantile {
+	if len(sorted) == 0 {
+		return nil
+	}
+	var quantiles []Quantile
+	add := func(fraction float64) {
+		i := min(max(int(math.Ceil(fraction*float64(len(sorted))))-1, 0), len(sorted)-1)
+		quantiles = append(quantiles, Quantile{Fraction: fraction, Latency: sorted[i]})
+	}
+	for i := 0; i < 90; i++ {
+		add(float64(i) / 100)
+	}
+	for i := 900; i < 990; i++ {
+		add(float64(i) / 1000)
+	}
+	for i := 9900; i <= 10000; i++ {
+		add(float64(i) / 10000)
+	}
+	return quantiles
+}
+
+// jain returns Jain's fairness index of xs, (Σx)² / (n·Σx²). It ranges from
+// 1/n, when one value holds everything, to 1, when all values are equal.
+func jain(xs []float64) float64 {
+	var sum, squares float64
+	for _, x := range xs {
+		sum += x
+		squares += x * x
+	}
+	if squares == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(xs)) * squares)
+}
+
+// WriteTable writes reports side by side as a plain text table: one row per
+// algorithm with its latencies, drop rate and fairness, followed by the
+// utilization of every server.
+func WriteTable(w io.Writer, reports []*Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "algorithm\twait p50\twait p99\twait p99.9\tresponse p50\tresponse p99\tdropped\tfairness\t")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.2f%%\t%.3f\t\n",
+			r.Algorithm,
+			round(r.QueueDelay.P50), round(r.QueueDelay.P99), round(r.QueueDelay.P999),
+			round(r.Response.P50), round(r.Response.P99),
+			100*r.DropRate(), r.Fairness)
+	}
+	if err := tw.Flush(); err != nil || len(reports) == 0 {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"utilization"}
+	for _, s := range reports[0].Servers {
+		header = append(header, fmt.Sprintf("%s (%g)", s.ID, s.Capacity))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, r := range reports {
+		row := []string{r.Algorithm}
+		for _, s := range r.Servers {
+			row = append(row, fmt.Sprintf("%.0f%%", 100*s.Utilization))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+// round shortens d to three significant digits for display.
+func round(d time.Duration) time.Duration {
+	unit := time.Nanosecond
+	for d >= 1000*unit {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
@@ -0,0 +1,321 @@
+// Package simulator compares load balancing algorithms on synthetic
+// workloads without sending a single packet. Requests with Poisson or bursty
+// arrivals and light or heavy-tailed service times are routed by a real
+// balancer, created through the algorithm package, to simulated servers of
+// differing capacity. Time is virtual, so a simulated hour takes
+// milliseconds, and the same seed always gives the same result. Recorded
+// traffic, see the replay package, is simulated just as well.
+//
+// Every server serves up to Workers requests at once at its Speed and queues
+// the rest up to QueueLimit, dropping requests beyond. A request holds its
+// balancer connection from arrival until it is served, as it would behind
+// the proxy.
+//
+// Algorithms that read the wall clock, such as peak_ewma or slow start, see
+// real time rather than virtual time and are not meaningful here.
+package simulator
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"time"
+
+	"sysdesign/loadbalancing/algorithm"
+	"sysdesign/loadbalancing/balancer"
+	"sysdesign/loadbalancing/iphash"
+	leastconnection "sysdesign/loadbalancing/least_connection"
+	"sysdesign/loadbalancing/roundrobin"
+	weightedleastconnection "sysdesign/loadbalancing/weighted_least_connection"
+	weightedroundrobin "sysdesign/loadbalancing/weighted_round_robin"
+)
+
+// Algorithms are the algorithms Compare runs when given none.
+var Algorithms = []string{
+	roundrobin.Name,
+	weightedroundrobin.Name,
+	leastconnection.Name,
+	weightedleastconnection.Name,
+	iphash.Name,
+}
+
+// Server is a simulated backend.
+type Server struct {
+	ID         string
+	Workers    int     // Requests served at once, 1 if zero
+	Speed      float64 // Work done per unit of time, a request needing d takes d/Speed; 1 if zero
+	QueueLimit int     // Requests waiting for a worker before new ones are dropped, 0 for no limit
+	Weight     int     // Balancer weight, Workers*Speed rounded if zero
+}
+
+// Config describes a simulation.
+type Config struct {
+	Algorithm string // Name accepted by algorithm.New
+	Servers   []Server
+	Workload  Workload
+	Seed      uint64 // Seeds the workload, equal seeds give equal requests
+
+	// SampleInterval is how often the load and connections of every server
+	// are recorded in Report.Series. Zero takes 200 samples over the arrivals.
+	SampleInterval time.Duration
+}
+
+// Run simulates cfg.Workload routed by cfg.Algorithm until every request was
+// served or dropped.
+//
+// Parameters:
+//   - cfg: The algorithm, servers and workload to simulate
+//
+// Returns:
+//   - *Report: The latencies, utilization, drops and fairness of the run
+//   - error: An error if the configuration is invalid
+func Run(cfg Config) (*Report, error) {
+	jobs, err := cfg.Workload.generate(cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+	return run(cfg.Algorithm, cfg.Servers, jobs, cfg.sampleInterval(jobs))
+}
+
+// Compare runs the same workload through each of the algorithms, Algorithms
+// if none are given, and returns their reports in that order.
+func Compare(cfg Config, algorithms ...string) ([]*Report, error) {
+	if len(algorithms) == 0 {
+		algorithms = Algorithms
+	}
+	jobs, err := cfg.Workload.generate(cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*Report, 0, len(algorithms))
+	for _, name := range algorithms {
+		report, err := run(name, cfg.Servers, jobs, cfg.sampleInterval(jobs))
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// sampleInterval returns SampleInterval, or the interval giving 200 samples
+// until the last of jobs arrived.
+func (cfg Config) sampleInterval(jobs []job) time.Duration {
+	if cfg.SampleInterval > 0 {
+		return cfg.SampleInterval
+	}
+	return round(max(jobs[len(jobs)-1].arrival/200, time.Microsecond))
+}
+
+// server is the state of a simulated server during a run.
+type server struct {
+	Server
+	busy  int      // Workers serving a request
+	queue []*visit // Requests waiting for a worker, oldest first
+
+	requests  int
+	dropped   int
+	maxQueue  int
+	busyArea  float64 // Integral of busy workers over time, in worker seconds
+	lastEvent time.Duration
+	lastArea  float64 // busyArea at the previous sample
+}
+
+// visit is a request on its way through a server.
+type visit struct {
+	job     *job
+	server  *server
+	backend *balancer.Backend
+	start   time.Duration // When a worker took it
+	end     time.Duration // When it was served
+}
+
+// simulation is the state of one run.
+type simulation struct {
+	balancer balancer.Balancer
+	servers  map[string]*server
+	order    []*server
+	pending  completions
+	report   *Report
+	queued   []time.Duration // Queueing delay of every served request
+	response []time.Duration // Response time of every served request
+
+	interval   time.Duration // Time between samples
+	nextSample time.Duration
+}
+
+func run(name string, specs []Server, jobs []job, interval time.Duration) (*Report, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("simulation needs at least one server")
+	}
+	s := &simulation{servers: make(map[string]*server, len(specs)), interval: interval, nextSample: interval}
+	backends := make([]balancer.Backend, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Workers <= 0 {
+			spec.Workers = 1
+		}
+		if spec.Speed <= 0 {
+			spec.Speed = 1
+		}
+		if spec.Weight <= 0 {
+			spec.Weight = max(1, int(math.Round(float64(spec.Workers)*spec.Speed)))
+		}
+		if _, ok := s.servers[spec.ID]; ok || spec.ID == "" {
+			return nil, fmt.Errorf("server IDs must be unique and not empty, got %q", spec.ID)
+		}
+		srv := &server{Server: spec}
+		s.servers[spec.ID] = srv
+		s.order = append(s.order, srv)
+		backends = append(backends, balancer.Backend{ID: spec.ID, Address: spec.ID, Weight: spec.Weight})
+	}
+
+	b, err := algorithm.New(name, backends)
+	if err != nil {
+		return nil, err
+	}
+	s.balancer = b
+	s.report = &Report{Algorithm: b.Name(), Requests: len(jobs)}
+
+	var now time.Duration
+	for i := 0; i < len(jobs) || s.pending.Len() > 0; {
+		// Completions go first on ties, freeing their worker for the arrival.
+		if s.pending.Len() > 0 && (i == len(jobs) || s.pending[0].end <= jobs[i].arrival) {
+			v := heap.Pop(&s.pending).(*visit)
+			now = v.end
+			s.sampleUntil(now)
+			s.complete(v, now)
+			continue
+		}
+		now = jobs[i].arrival
+		s.sampleUntil(now)
+		s.arrive(&jobs[i], now)
+		i++
+	}
+
+	s.finish(now)
+	return s.report, nil
+}
+
+// arrive routes j to the server the balancer picks.
+func (s *simulation) arrive(j *job, now time.Duration) {
+	backend, err := s.balancer.Pick(&balancer.Request{ClientIP: j.client})
+	if err != nil {
+		s.report.Dropped++
+		return
+	}
+	srv := s.servers[backend.ID]
+	srv.requests++
+	v := &visit{job: j, server: srv, backend: backend}
+
+	if srv.busy < srv.Workers {
+		s.start(v, now)
+		return
+	}
+	if srv.QueueLimit > 0 && len(srv.queue) >= srv.QueueLimit {
+		srv.dropped++
+		s.report.Dropped++
+		s.balancer.Release(backend)
+		return
+	}
+	srv.queue = append(srv.queue, v)
+	srv.maxQueue = max(srv.maxQueue, len(srv.queue))
+}
+
+// start hands v to a free worker of its server.
+func (s *simulation) start(v *visit, now time.Duration) {
+	srv := v.server
+	srv.account(now)
+	srv.busy++
+	v.start = now
+	v.end = now + time.Duration(float64(v.job.demand)/srv.Speed)
+	heap.Push(&s.pending, v)
+}
+
+// complete records the served request v and starts the next queued one.
+func (s *simulation) complete(v *visit, now time.Duration) {
+	srv := v.server
+	srv.account(now)
+	srv.busy--
+	s.balancer.Release(v.backend)
+	s.report.Completed++
+	s.queued = append(s.queued, v.start-v.job.arrival)
+	s.response = append(s.response, v.end-v.job.arrival)
+
+	if len(srv.queue) > 0 {
+		next := srv.queue[0]
+		srv.queue[0] = nil
+		srv.queue = srv.queue[1:]
+		s.start(next, now)
+	}
+}
+
+// account adds the busy time since the last event of srv.
+func (srv *server) account(now time.Duration) {
+	srv.busyArea += float64(srv.busy) * (now - srv.lastEvent).Seconds()
+	srv.lastEvent = now
+}
+
+// sampleUntil records the samples due up to now. Nothing changes between
+// events, so the state before the event at now holds for all of them.
+func (s *simulation) sampleUntil(now time.Duration) {
+	for ; s.nextSample <= now; s.nextSample += s.interval {
+		connections := make(map[string]int, len(s.order))
+		for _, status := range s.balancer.Snapshot() {
+			connections[status.ID] = status.Connections
+		}
+
+		sample := Sample{Time: s.nextSample, Servers: make([]ServerSample, 0, len(s.order))}
+		for _, srv := range s.order {
+			srv.account(s.nextSample)
+			load := (srv.busyArea - srv.lastArea) / (float64(srv.Workers) * s.interval.Seconds())
+			srv.lastArea = srv.busyArea
+			sample.Servers = append(sample.Servers, ServerSample{Load: load, Connections: connections[srv.ID]})
+		}
+		s.report.Series = append(s.report.Series, sample)
+	}
+}
+
+// finish fills in the report once the run ended at end.
+func (s *simulation) finish(end time.Duration) {
+	r := s.report
+	r.Duration = end
+	r.QueueDelay = Summarize(s.queued)
+	r.Response = Summarize(s.response)
+	r.QueueDelayCDF = cdf(s.queued)
+	r.ResponseCDF = cdf(s.response)
+
+	utilization := make([]float64, 0, len(s.order))
+	for _, srv := range s.order {
+		srv.account(end)
+		u := 0.0
+		if end > 0 {
+			u = srv.busyArea / (float64(srv.Workers) * end.Seconds())
+		}
+		utilization = append(utilization, u)
+		r.Servers = append(r.Servers, ServerReport{
+			ID:          srv.ID,
+			Capacity:    float64(srv.Workers) * srv.Speed,
+			Requests:    srv.requests,
+			Dropped:     srv.dropped,
+			MaxQueue:    srv.maxQueue,
+			Utilization: u,
+		})
+	}
+	r.Fairness = jain(utilization)
+}
+
+// completions is a min-heap of visits by the time they are served.
+type completions []*visit
+
+func (c completions) Len() int           { return len(c) }
+func (c completions) Less(i, j int) bool { return c[i].end < c[j].end }
+func (c completions) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c *completions) Push(x any)        { *c = append(*c, x.(*visit)) }
+func (c *completions) Pop() any {
+	old := *c
+	v := old[len(old)-1]
+	old[len(old)-1] = nil
+	*c = old[:len(old)-1]
+	return v
+}
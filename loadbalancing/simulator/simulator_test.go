@@ -0,0 +1,247 @@
+package simulator
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig(algorithm string) Config {
+	return Config{
+		Algorithm: algorithm,
+		Servers: []Server{
+			{ID: "a", Workers: 2, Speed: 1, QueueLimit: 20},
+			{ID: "b", Workers: 2, Speed: 1, QueueLimit: 20},
+			{ID: "c", Workers: 2, Speed: 2, QueueLimit: 20},
+		},
+		Workload: Workload{
+			Requests: 20000,
+			Arrivals: Poisson{Rate: 600},
+			Service:  Exponential{Mean: 10 * time.Millisecond},
+		},
+		Seed: 7,
+	}
+}
+
+func within(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance*want
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	first, err := Run(testConfig("least_connection"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := Run(testConfig("least_connection"))
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected equal reports for equal seeds, got\n%+v\n%+v", first, second)
+	}
+}
+
+func TestRunAccountsForEveryRequest(t *testing.T) {
+	cfg := testConfig("round_robin")
+	cfg.Workload.Arrivals = Poisson{Rate: 1200} // Beyond what a and b can serve
+	report, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Completed+report.Dropped != report.Requests {
+		t.Errorf("expected %d requests served or dropped, got %d + %d", report.Requests, report.Completed, report.Dropped)
+	}
+	if report.DropRate() == 0 {
+		t.Error("expected overloaded servers to drop requests")
+	}
+	routed := 0
+	for _, s := range report.Servers {
+		routed += s.Requests
+		if s.MaxQueue > 20 {
+			t.Errorf("expected the queue of %s within its limit, got %d", s.ID, s.MaxQueue)
+		}
+	}
+	if routed != report.Requests {
+		t.Errorf("expected every request routed, got %d of %d", routed, report.Requests)
+	}
+}
+
+func TestRunMatchesQueueingTheory(t *testing.T) {
+	// An M/M/1 queue at half load waits λ/(μ(μ-λ)) = 10ms on average.
+	report, err := Run(Config{
+		Algorithm: "round_robin",
+		Servers:   []Server{{ID: "a"}},
+		Workload: Workload{
+			Requests: 200000,
+			Arrivals: Poisson{Rate: 50},
+			Service:  Exponential{Mean: 10 * time.Millisecond},
+		},
+		Seed: 1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if wait := report.QueueDelay.Mean.Seconds(); !within(wait, 0.010, 0.1) {
+		t.Errorf("expected a mean wait of about 10ms, got %v", report.QueueDelay.Mean)
+	}
+	if u := report.Servers[0].Utilization; !within(u, 0.5, 0.05) {
+		t.Errorf("expected a utilization of about 0.5, got %.3f", u)
+	}
+}
+
+func TestCompareWeightsByCapacity(t *testing.T) {
+	reports, err := Compare(testConfig(""), "round_robin", "weighted_round_robin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rr, wrr := reports[0], reports[1]
+	if rr.Algorithm != "round_robin" || wrr.Algorithm != "weighted_round_robin" {
+		t.Fatalf("expected reports in the order asked, got %s, %s", rr.Algorithm, wrr.Algorithm)
+	}
+	// c has twice the capacity, so weighted round robin sends it half the requests.
+	if share := float64(wrr.Servers[2].Requests) / float64(wrr.Requests); !within(share, 0.5, 0.01) {
+		t.Errorf("expected c to get half of the requests, got %.3f", share)
+	}
+	if wrr.Fairness <= rr.Fairness {
+		t.Errorf("expected weights to even out utilization, fairness %.3f vs %.3f", wrr.Fairness, rr.Fairness)
+	}
+}
+
+func TestCompareDefaultsToEveryRequestedAlgorithm(t *testing.T) {
+	cfg := testConfig("")
+	cfg.Workload.Requests = 1000
+	reports, err := Compare(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reports) != len(Algorithms) {
+		t.Fatalf("expected %d reports, got %d", len(Algorithms), len(reports))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, reports); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range Algorithms {
+		if !strings.Contains(buf.String(), name) {
+			t.Errorf("expected the table to list %s, got\n%s", name, buf.String())
+		}
+	}
+}
+
+func TestArrivalAndServiceRates(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	const n = 100000
+
+	times := Poisson{Rate: 200}.Times(r, n)
+	if rate := n / times[n-1].Seconds(); !within(rate, 200, 0.02) {
+		t.Errorf("expected poisson arrivals at 200/s, got %.1f/s", rate)
+	}
+
+	bursty := Bursty{Rate: 100, BurstRate: 1000, Quiet: time.Second, Burst: 250 * time.Millisecond}
+	times = bursty.Times(r, n)
+	if rate := n / times[n-1].Seconds(); !within(rate, bursty.MeanRate(), 0.05) {
+		t.Errorf("expected bursty arrivals at %.1f/s, got %.1f/s", bursty.MeanRate(), rate)
+	}
+
+	for _, service := range []ServiceTime{Exponential{Mean: 10 * time.Millisecond}, Pareto{Mean: 10 * time.Millisecond, Alpha: 3}} {
+		var sum time.Duration
+		for i := 0; i < n; i++ {
+			sum += service.Sample(r)
+		}
+		if mean := (sum / n).Seconds(); !within(mean, 0.010, 0.03) {
+			t.Errorf("expected a mean service time of 10ms from %T, got %v", service, sum/n)
+		}
+	}
+}
+
+func TestJain(t *testing.T) {
+	tests := []struct {
+		xs   []float64
+		want float64
+	}{
+		{[]float64{0.5, 0.5, 0.5}, 1},
+		{[]float64{1, 0}, 0.5},
+		{[]float64{1, 0, 0, 0}, 0.25},
+		{[]float64{0, 0}, 1},
+	}
+	for _, tt := range tests {
+		if got := jain(tt.xs); !within(got, tt.want, 1e-9) {
+			t.Errorf("jain(%v) = %v, want %v", tt.xs, got, tt.want)
+		}
+	}
+}
+
+func TestRunRejectsInvalidConfigs(t *testing.T) {
+	for name, change := range map[string]func(*Config){
+		"no servers":        func(c *Config) { c.Servers = nil },
+		"duplicate servers": func(c *Config) { c.Servers[1].ID = "a" },
+		"no requests":       func(c *Config) { c.Workload.Requests = 0 },
+		"no arrivals":       func(c *Config) { c.Workload.Arrivals = nil },
+		"low client skew":   func(c *Config) { c.Workload.ClientSkew = 0.5 },
+		"zero rate":         func(c *Config) { c.Workload.Arrivals = Poisson{} },
+		"no bursts":         func(c *Config) { c.Workload.Arrivals = Bursty{Rate: 10, BurstRate: 100} },
+		"idle bursts":       func(c *Config) { c.Workload.Arrivals = Bursty{Quiet: time.Second, Burst: time.Second} },
+		"low pareto alpha":  func(c *Config) { c.Workload.Service = Pareto{Mean: time.Millisecond, Alpha: 1} },
+		"negative mean":     func(c *Config) { c.Workload.Service = Exponential{Mean: -time.Millisecond} },
+		"unknown algorithm": func(c *Config) { c.Algorithm = "does_not_exist" },
+	} {
+		cfg := testConfig("round_robin")
+		change(&cfg)
+		if _, err := Run(cfg); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestRunRecordsSeries(t *testing.T) {
+	cfg := testConfig("least_connection")
+	cfg.SampleInterval = 100 * time.Millisecond
+	report, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := int(report.Duration / cfg.SampleInterval); len(report.Series) != want {
+		t.Errorf("expected %d samples over %v, got %d", want, report.Duration, len(report.Series))
+	}
+	for i, sample := range report.Series {
+		if want := time.Duration(i+1) * cfg.SampleInterval; sample.Time != want {
+			t.Fatalf("expected sample %d at %v, got %v", i, want, sample.Time)
+		}
+		for j, s := range sample.Servers {
+			if s.Load < 0 || s.Load > 1+1e-9 {
+				t.Errorf("expected load within [0, 1], got %v for %s at %v", s.Load, report.Servers[j].ID, sample.Time)
+			}
+			if s.Connections < 0 || s.Connections > 22 {
+				t.Errorf("expected connections within workers and queue, got %d", s.Connections)
+			}
+		}
+	}
+}
+
+func TestCDF(t *testing.T) {
+	samples := make([]time.Duration, 1000)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+	quantiles := cdf(samples)
+
+	for i := 1; i < len(quantiles); i++ {
+		if quantiles[i].Fraction <= quantiles[i-1].Fraction || quantiles[i].Latency < quantiles[i-1].Latency {
+			t.Fatalf("expected an ascending distribution, got %v then %v", quantiles[i-1], quantiles[i])
+		}
+	}
+	for _, q := range quantiles {
+		if q.Fraction == 0.5 && q.Latency != 500*time.Millisecond {
+			t.Errorf("expected the median at 500ms, got %v", q.Latency)
+		}
+	}
+	if last := quantiles[len(quantiles)-1]; last.Fraction != 1 || last.Latency != time.Second {
+		t.Errorf("expected the distribution to end at the maximum, got %v", last)
+	}
+}
@@ -0,0 +1,228 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Arrivals generates when requests arrive.
+type Arrivals interface {
+	// Times returns the arrival times of n requests in ascending order,
+	// counted from the start of the simulation.
+	Times(r *rand.Rand, n int) []time.Duration
+}
+
+// Poisson arrivals come independently at a constant average rate, so the
+// gaps between them are exponentially distributed.
+type Poisson struct {
+	Rate float64 // Requests per second
+}
+
+func (p Poisson) validate() error {
+	if !(p.Rate > 0) {
+		return fmt.Errorf("poisson arrivals need a positive rate, got %g", p.Rate)
+	}
+	return nil
+}
+
+func (p Poisson) Times(r *rand.Rand, n int) []time.Duration {
+	times := make([]time.Duration, n)
+	now := 0.0
+	for i := range times {
+		now += r.ExpFloat64() / p.Rate
+		times[i] = seconds(now)
+	}
+	return times
+}
+
+// Bursty arrivals alternate between quiet periods and bursts, each of
+// exponentially distributed length, with Poisson arrivals at the rate of the
+// current period. This is a two state Markov modulated Poisson process.
+type Bursty struct {
+	Rate      float64       // Requests per second in quiet periods
+	BurstRate float64       // Requests per second during bursts
+	Quiet     time.Duration // Mean length of a quiet period
+	Burst     time.Duration // Mean length of a burst
+}
+
+func (b Bursty) validate() error {
+	if b.Rate < 0 || b.BurstRate < 0 || !(b.Rate+b.BurstRate > 0) {
+		return fmt.Errorf("bursty arrivals need rates of at least 0, not both 0, got %g and %g", b.Rate, b.BurstRate)
+	}
+	if b.Quiet <= 0 || b.Burst <= 0 {
+		return fmt.Errorf("bursty arrivals need positive quiet and burst lengths, got %v and %v", b.Quiet, b.Burst)
+	}
+	return nil
+}
+
+func (b Bursty) Times(r *rand.Rand, n int) []time.Duration {
+	times := make([]time.Duration, 0, n)
+	now, bursting := 0.0, false
+	end := r.ExpFloat64() * b.Quiet.Seconds()
+	for len(times) < n {
+		rate := b.Rate
+		if bursting {
+			rate = b.BurstRate
+		}
+		next := now + r.ExpFloat64()/rate
+		if next > end {
+			// Gaps are memoryless, so drawing anew at the switch is exact.
+			now, bursting = end, !bursting
+			mean := b.Quiet
+			if bursting {
+				mean = b.Burst
+			}
+			end = now + r.ExpFloat64()*mean.Seconds()
+			continue
+		}
+		now = next
+		times = append(times, seconds(now))
+	}
+	return times
+}
+
+// MeanRate returns the long run average arrival rate in requests per second.
+func (b Bursty) MeanRate() float64 {
+	quiet, burst := b.Quiet.Seconds(), b.Burst.Seconds()
+	return (b.Rate*quiet + b.BurstRate*burst) / (quiet + burst)
+}
+
+// ServiceTime generates how much work requests need, as the time a server of
+// speed 1 takes to serve them.
+type ServiceTime interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+// Exponential service times have a light tail: long requests are rare.
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (e Exponential) validate() error {
+	if e.Mean < 0 {
+		return fmt.Errorf("exponential service times need a mean of at least 0, got %v", e.Mean)
+	}
+	return nil
+}
+
+func (e Exponential) Sample(r *rand.Rand) time.Duration {
+	return seconds(r.ExpFloat64() * e.Mean.Seconds())
+}
+
+// Pareto service times are heavy-tailed: a few requests take far longer than
+// the rest, as with large downloads or expensive queries. The lower Alpha,
+// the heavier the tail; the mean is finite for Alpha above 1.
+type Pareto struct {
+	Mean  time.Duration
+	Alpha float64
+}
+
+func (p Pareto) validate() error {
+	if !(p.Alpha > 1) {
+		return fmt.Errorf("pareto service times need an alpha above 1, got %g", p.Alpha)
+	}
+	if p.Mean < 0 {
+		return fmt.Errorf("pareto service times need a mean of at least 0, got %v", p.Mean)
+	}
+	return nil
+}
+
+func (p Pareto) Sample(r *rand.Rand) time.Duration {
+	scale := p.Mean.Seconds() * (p.Alpha - 1) / p.Alpha
+	// 1-Float64 lies in (0, 1], which keeps the power finite.
+	return seconds(scale / math.Pow(1-r.Float64(), 1/p.Alpha))
+}
+
+// Workload describes the requests of a simulation.
+type Workload struct {
+	Requests   int         // Number of requests
+	Arrivals   Arrivals    // When they arrive
+	Service    ServiceTime // How much work they need
+	Clients    int         // Distinct client IPs sending them, 1000 if zero
+	ClientSkew float64     // Zipf exponent of client popularity, above 1; 0 makes clients equally active
+
+	// Recorded, when not empty, is simulated instead of generated requests,
+	// for example traffic recorded by the proxy; the fields above are then
+	// ignored.
+	Recorded []Recorded
+}
+
+// Recorded is a request of a recorded workload.
+type Recorded struct {
+	Arrival  time.Duration // When it arrived, counted from the start of the recording
+	ClientIP string
+	Demand   time.Duration // Service time on a server of speed 1
+}
+
+// job is one generated request.
+type job struct {
+	arrival time.Duration
+	client  string
+	demand  time.Duration // Service time on a server of speed 1
+}
+
+// generate draws the requests of w from a generator seeded with seed, so
+// every algorithm of a comparison sees the very same requests.
+func (w Workload) generate(seed uint64) ([]job, error) {
+	if len(w.Recorded) > 0 {
+		return w.replay()
+	}
+	if w.Requests <= 0 {
+		return nil, fmt.Errorf("workload needs a positive number of requests, got %d", w.Requests)
+	}
+	if w.Arrivals == nil || w.Service == nil {
+		return nil, fmt.Errorf("workload needs arrivals and service times")
+	}
+	// The arrivals and service times of this package check their parameters.
+	for _, v := range []any{w.Arrivals, w.Service} {
+		if v, ok := v.(interface{ validate() error }); ok {
+			if err := v.validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	clients := w.Clients
+	if clients <= 0 {
+		clients = 1000
+	}
+	if w.ClientSkew != 0 && w.ClientSkew <= 1 {
+		return nil, fmt.Errorf("client skew must be above 1, got %g", w.ClientSkew)
+	}
+
+	r := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	client := func() int { return r.IntN(clients) }
+	if w.ClientSkew > 0 {
+		zipf := rand.NewZipf(r, w.ClientSkew, 1, uint64(clients-1))
+		client = func() int { return int(zipf.Uint64()) }
+	}
+
+	times := w.Arrivals.Times(r, w.Requests)
+	jobs := make([]job, len(times))
+	for i, at := range times {
+		jobs[i] = job{arrival: at, client: clientIP(client()), demand: w.Service.Sample(r)}
+	}
+	return jobs, nil
+}
+
+// replay returns the recorded requests of w as jobs.
+func (w Workload) replay() ([]job, error) {
+	jobs := make([]job, len(w.Recorded))
+	for i, r := range w.Recorded {
+		if i > 0 && r.Arrival < w.Recorded[i-1].Arrival {
+			return nil, fmt.Errorf("recorded requests must be in order of arrival, request %d arrived before request %d", i, i-1)
+		}
+		jobs[i] = job{arrival: r.Arrival, client: r.ClientIP, demand: r.Demand}
+	}
+	return jobs, nil
+}
+
+// clientIP returns the address of the i-th client.
+func clientIP(i int) string {
+	return fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
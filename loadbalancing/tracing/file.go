@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient is an otlptrace.Client appending every upload to a file as one
+// line of OTLP/JSON.
+type fileClient struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer // Closed by Stop, may be nil
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// UploadTraces writes the spans as an ExportTraceServiceRequest on one line.
+func (c *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	line, err := marshalRequest(spans)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
+
+// marshalRequest encodes the spans as OTLP/JSON. That is the protobuf JSON
+// mapping except that enums are numbers and trace and span IDs are hex
+// rather than base64 strings.
+func marshalRequest(spans []*tracepb.ResourceSpans) ([]byte, error) {
+	options := protojson.MarshalOptions{UseEnumNumbers: true}
+	resourceSpans := make([]any, 0, len(spans))
+	for _, rs := range spans {
+		data, err := options.Marshal(rs)
+		if err != nil {
+			return nil, err
+		}
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		hexIDs(v)
+		resourceSpans = append(resourceSpans, v)
+	}
+	return json.Marshal(map[string]any{"resourceSpans": resourceSpans})
+}
+
+// hexIDs rewrites the base64 trace and span IDs below v as hex.
+func hexIDs(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			switch key {
+			case "traceId", "spanId", "parentSpanId":
+				if s, ok := value.(string); ok {
+					if id, err := base64.StdEncoding.DecodeString(s); err == nil {
+						v[key] = hex.EncodeToString(id)
+					}
+				}
+			default:
+				hexIDs(value)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			hexIDs(item)
+		}
+	}
+}
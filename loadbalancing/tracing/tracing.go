@@ -0,0 +1,101 @@
+// Package tracing installs the OpenTelemetry tracer provider the proxies
+// report their spans to. Every exporter writes locally, so tracing works
+// without a collector:
+//   - stdout prints every span as indented JSON.
+//   - otlp-file appends one OTLP/JSON ExportTraceServiceRequest per line to a
+//     file, the format of the OpenTelemetry Collector file exporter, which
+//     the Collector's otlpjsonfile receiver and most trace viewers read.
+//
+// Incoming W3C trace context is continued and passed on to the backends.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporter names accepted by Setup.
+const (
+	ExporterNone     = "none"
+	ExporterStdout   = "stdout"
+	ExporterOTLPFile = "otlp-file"
+)
+
+// DefaultPath is the file written by the otlp-file exporter when no path is given.
+const DefaultPath = "traces.jsonl"
+
+// Config selects where spans go.
+type Config struct {
+	Exporter    string  // ExporterNone, ExporterStdout or ExporterOTLPFile, empty means none
+	Path        string  // File of the otlp-file exporter, DefaultPath if empty
+	ServiceName string  // service.name of every span
+	SampleRatio float64 // Share of new traces recorded, 0 records all; traces started upstream follow their parent
+}
+
+// Setup installs a tracer provider exporting as configured, together with
+// the W3C trace context and baggage propagators, as the global ones.
+//
+// Parameters:
+//   - ctx: Bounds the creation of the exporter
+//   - config: The exporter and sampling settings
+//
+// Returns:
+//   - func(context.Context) error: Flushes the spans still buffered and stops exporting
+//   - error: An error if the exporter is unknown or cannot be created
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		e, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case ExporterOTLPFile:
+		path := config.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e, err := otlptrace.New(ctx, &fileClient{w: file, closer: file})
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		exporter = e
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected one of: %s, %s, %s", config.Exporter, ExporterNone, ExporterStdout, ExporterOTLPFile)
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
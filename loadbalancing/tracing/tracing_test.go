@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestOTLPFileExporter(t *testing.T) {
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterOTLPFile, Path: path, ServiceName: "lb-test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := otel.Tracer("test").Start(ctx, "child")
+	child.End()
+	parent.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("expected one OTLP/JSON request per line, got %q: %v", data, err)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != parent.SpanContext().TraceID().String() || c.ParentSpanID != p.SpanID || p.SpanID != parent.SpanContext().SpanID().String() {
+		t.Errorf("expected hex IDs linking child to parent, got %+v", spans)
+	}
+	if p.Name != "parent" || p.Kind != int(trace.SpanKindServer) {
+		t.Errorf("expected the server span parent with a numeric kind, got %+v", p)
+	}
+}
+
+func TestUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "jaeger"}); err == nil {
+		t.Error("expected an unknown exporter to be rejected")
+	}
+}
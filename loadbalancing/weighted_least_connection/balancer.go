@@ -0,0 +1,213 @@
+package weightedleastconnection
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the weighted least connection algorithm in configuration.
+const Name = "weighted_least_connection"
+
+var (
+	_ balancer.Balancer    = (*WeightedLeastConnection)(nil)
+	_ balancer.SlowStarter = (*WeightedLeastConnection)(nil)
+)
+
+// Name returns the algorithm identifier.
+func (wlc *WeightedLeastConnection) Name() string {
+	return Name
+}
+
+// Pick returns the server with the lowest connections-to-weight ratio and
+// charges it one connection. The request is only used to explain the pick.
+func (wlc *WeightedLeastConnection) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	server, err := wlc.nextServer()
+	if err != nil {
+		return nil, err
+	}
+	if req.Explained() {
+		req.Reason = fmt.Sprintf("least connections/weight=%d/%d", server.Connections-1, server.Weight)
+	}
+	return wlc.backendFor(server), nil
+}
+
+// Release frees the connection charged to the backend by Pick.
+func (wlc *WeightedLeastConnection) Release(backend *balancer.Backend) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	if server := wlc.find(backend.ID); server != nil && server.Connections > 0 {
+		wlc.releaseServer(server)
+	}
+}
+
+// AddBackend pushes a new server with no connections onto the heap, in slow
+// start if enabled.
+func (wlc *WeightedLeastConnection) AddBackend(backend balancer.Backend) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	if wlc.find(backend.ID) != nil {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+	if backend.Weight < 0 {
+		backend.Weight = 0
+	}
+
+	if wlc.backends == nil {
+		wlc.backends = make(map[string]*balancer.Backend)
+	}
+	wlc.backends[backend.ID] = &backend
+	wlc.addServer(&Server{ID: backend.ID, Weight: backend.Weight})
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID from the heap.
+func (wlc *WeightedLeastConnection) RemoveBackend(id string) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	if !wlc.removeServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain takes the server off the heap and removes it once its last
+// connection is released.
+func (wlc *WeightedLeastConnection) Drain(id string) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	if !wlc.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain pushes the draining server back onto the heap with the
+// connections it still holds.
+func (wlc *WeightedLeastConnection) Undrain(id string) error {
+	return wlc.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetWeight changes the weight of the server with the given ID through UpdateServerWeight.
+func (wlc *WeightedLeastConnection) SetWeight(id string, weight int) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	server := wlc.find(id)
+	if server == nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	wlc.updateServerWeight(server, weight)
+
+	updated := *wlc.backendFor(server)
+	updated.Weight = server.Weight
+	wlc.backends[id] = &updated
+	return nil
+}
+
+// SetHealthy takes the server off the heap while it is down and pushes it
+// back, with the connections it still holds, once it is up again.
+func (wlc *WeightedLeastConnection) SetHealthy(id string, healthy bool) error {
+	return wlc.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server off the heap while it is ejected and pushes it
+// back, with the connections it still holds, once it is readmitted.
+func (wlc *WeightedLeastConnection) SetEjected(id string, ejected bool) error {
+	return wlc.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off.
+func (wlc *WeightedLeastConnection) setCondition(id string, condition balancer.Condition, on bool) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	server := wlc.find(id)
+	if server == nil {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	wlc.conditions.Set(id, condition, on)
+	wlc.refresh(server)
+	return nil
+}
+
+// Snapshot returns every server ordered by ID.
+func (wlc *WeightedLeastConnection) Snapshot() []balancer.BackendStatus {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(wlc.servers)+len(wlc.parked))
+	for _, server := range wlc.all() {
+		statuses = append(statuses, wlc.conditions.Status(balancer.BackendStatus{
+			Backend:     *wlc.backendFor(server),
+			Connections: server.Connections,
+		}))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// find returns the server with the given ID, whether it is on the heap or
+// parked, or nil. The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) find(id string) *Server {
+	for _, server := range wlc.servers {
+		if server.ID == id {
+			return server
+		}
+	}
+	return wlc.parked[id]
+}
+
+// all returns the servers on the heap followed by the parked ones.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) all() []*Server {
+	servers := append([]*Server(nil), wlc.servers...)
+	for _, server := range wlc.parked {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// refresh pushes server onto the heap when it may be picked, starting its
+// slow start, and parks it otherwise. The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) refresh(server *Server) {
+	available := wlc.conditions.Available(server.ID)
+	_, parked := wlc.parked[server.ID]
+
+	switch {
+	case available && parked:
+		delete(wlc.parked, server.ID)
+		wlc.addServer(server)
+	case !available && !parked:
+		heap.Remove(&wlc.servers, server.index)
+		if wlc.parked == nil {
+			wlc.parked = make(map[string]*Server)
+		}
+		wlc.parked[server.ID] = server
+	}
+}
+
+// backendFor returns the backend registered for server. Servers passed to
+// WeightedLeastConnectionLoadBalancer have no backend yet, so one is created
+// using the server ID as the address. The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) backendFor(server *Server) *balancer.Backend {
+	if b, ok := wlc.backends[server.ID]; ok {
+		return b
+	}
+	if wlc.backends == nil {
+		wlc.backends = make(map[string]*balancer.Backend)
+	}
+	b := &balancer.Backend{ID: server.ID, Address: server.ID, Weight: server.Weight}
+	wlc.backends[server.ID] = b
+	return b
+}
@@ -6,10 +6,11 @@ package weightedleastconnection
 
 // Server represents a server in the load balancing pool.
 type Server struct {
-	ID          string // Unique identifier for the server
-	Connections int    // Number of active connections to this server
-	Weight      int    // Weight representing the server's capacity
-	index       int    // Index of the server in the heap (used internally)
+	ID          string  // Unique identifier for the server
+	Connections int     // Number of active connections to this server
+	Weight      int     // Weight representing the server's capacity
+	index       int     // Index of the server in the heap (used internally)
+	share       float64 // Fraction of the weight in effect during slow start, 0 counts as 1
 }
 
 // AddConnection increments the number of active connections for the server.
@@ -26,12 +27,17 @@ func (s *Server) ReleaseConnection() {
 
 // WeightedConnection calculates and returns the weighted connection ratio for the server.
 // This ratio is used to determine the server's load relative to its capacity.
+// During slow start only part of the weight counts as capacity.
 // If the weight is 0, it returns 0 to avoid division by zero.
 func (s *Server) WeightedConnection() float64 {
 	if s.Weight == 0 {
 		return 0
 	}
-	return float64(s.Connections) / float64(s.Weight)
+	weight := float64(s.Weight)
+	if s.share > 0 {
+		weight *= s.share
+	}
+	return float64(s.Connections) / weight
 }
 
 // ServerQueue is a priority queue of servers, implemented as a min-heap.
@@ -3,6 +3,9 @@ package weightedleastconnection
 import (
 	"container/heap"
 	"errors"
+	"sync"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 // WeightedLeastConnection represents a weighted least connection load balancer.
@@ -10,6 +13,12 @@ import (
 // weighted connection ratio is always at the top.
 type WeightedLeastConnection struct {
 	servers ServerQueue
+	mutex   sync.Mutex
+
+	backends   map[string]*balancer.Backend // Backend details keyed by server ID
+	parked     map[string]*Server           // Servers taken out of the heap, keyed by ID
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
+	slowStarts balancer.SlowStarts          // When servers began ramping up, keyed by ID
 }
 
 // WeightedLeastConnectionLoadBalancer creates and initializes a new WeightedLeastConnection load balancer.
@@ -40,9 +49,29 @@ func WeightedLeastConnectionLoadBalancer(servers []Server) *WeightedLeastConnect
 //   - *Server: A pointer to the selected server with the lowest weighted connection ratio.
 //   - error: An error if no servers are available.
 func (wlc *WeightedLeastConnection) NextServer() (*Server, error) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	return wlc.nextServer()
+}
+
+// SetSlowStart makes servers that are added or come back up ramp from a
+// fraction of their weight to their full weight over the configured window.
+func (wlc *WeightedLeastConnection) SetSlowStart(config balancer.SlowStart) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	wlc.slowStarts.Configure(config)
+	wlc.rescale()
+}
+
+// nextServer pops the server with the lowest weighted ratio, charges it one
+// connection and pushes it back. The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) nextServer() (*Server, error) {
 	if wlc.servers.Len() == 0 {
 		return nil, errors.New("no servers available")
 	}
+	if wlc.slowStarts.Ramping() {
+		wlc.rescale()
+	}
 
 	server := heap.Pop(&wlc.servers).(*Server)
 	server.AddConnection()
@@ -57,8 +86,110 @@ func (wlc *WeightedLeastConnection) NextServer() (*Server, error) {
 // Parameters:
 //   - server: A pointer to the Server being released.
 func (wlc *WeightedLeastConnection) ReleaseServer(server *Server) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	wlc.releaseServer(server)
+}
+
+// releaseServer frees one connection of server and restores the heap order.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) releaseServer(server *Server) {
 	server.ReleaseConnection()
-	heap.Fix(&wlc.servers, server.index)
+	if server.index >= 0 {
+		heap.Fix(&wlc.servers, server.index)
+	}
+	if server.Connections <= 0 && wlc.conditions.Has(server.ID, balancer.ConditionDraining) {
+		wlc.removeServer(server.ID)
+	}
+}
+
+// AddServer pushes a new server onto the heap, keeping the connections it
+// already has, and starts its slow start if enabled.
+// Returns an error if a server with the same ID exists.
+func (wlc *WeightedLeastConnection) AddServer(server *Server) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	if wlc.find(server.ID) != nil {
+		return errors.New("server already exists")
+	}
+	wlc.addServer(server)
+	return nil
+}
+
+// addServer pushes server onto the heap and starts its slow start.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) addServer(server *Server) {
+	wlc.slowStarts.Begin(server.ID)
+	server.share = wlc.slowStarts.Factor(server.ID)
+	heap.Push(&wlc.servers, server)
+}
+
+// rescale updates the share of its weight every server on the heap gets from
+// its slow start and restores the heap order, which the changed shares may
+// have broken. The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) rescale() {
+	for _, server := range wlc.servers {
+		server.share = wlc.slowStarts.Factor(server.ID)
+	}
+	heap.Init(&wlc.servers)
+}
+
+// RemoveServer removes the server with the given ID, whatever connections it
+// still has. Returns an error if the server is not found.
+func (wlc *WeightedLeastConnection) RemoveServer(id string) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	if !wlc.removeServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer takes the server with the given ID off the heap so it gets no
+// new connections, and removes it once ReleaseServer has brought its
+// connections to zero, at once if it has none.
+// Returns an error if the server is not found.
+func (wlc *WeightedLeastConnection) DrainServer(id string) error {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	if !wlc.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// removeServer deletes the server from the heap or the parked servers along
+// with everything recorded about it. It reports whether the server was found.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) removeServer(id string) bool {
+	server := wlc.find(id)
+	if server == nil {
+		return false
+	}
+	if server.index >= 0 {
+		heap.Remove(&wlc.servers, server.index)
+	}
+	delete(wlc.parked, id)
+	delete(wlc.backends, id)
+	wlc.conditions.Forget(id)
+	wlc.slowStarts.Forget(id)
+	return true
+}
+
+// drainServer marks the server as draining, which parks it, and removes it if
+// it has no connections left. It reports whether the server was found.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) drainServer(id string) bool {
+	server := wlc.find(id)
+	if server == nil {
+		return false
+	}
+	wlc.conditions.Set(id, balancer.ConditionDraining, true)
+	wlc.refresh(server)
+	if server.Connections <= 0 {
+		wlc.removeServer(id)
+	}
+	return true
 }
 
 // UpdateServerWeight updates the weight of a server and adjusts its position in the priority queue.
@@ -68,9 +199,19 @@ func (wlc *WeightedLeastConnection) ReleaseServer(server *Server) {
 //   - server: A pointer to the Server whose weight is being updated.
 //   - newWeight: The new weight to be assigned to the server.
 func (wlc *WeightedLeastConnection) UpdateServerWeight(server *Server, newWeight int) {
+	wlc.mutex.Lock()
+	defer wlc.mutex.Unlock()
+	wlc.updateServerWeight(server, newWeight)
+}
+
+// updateServerWeight applies newWeight to server and restores the heap order.
+// The caller must hold wlc.mutex.
+func (wlc *WeightedLeastConnection) updateServerWeight(server *Server, newWeight int) {
 	if newWeight < 0 {
 		newWeight = 0
 	}
 	server.Weight = newWeight
-	heap.Fix(&wlc.servers, server.index)
+	if server.index >= 0 {
+		heap.Fix(&wlc.servers, server.index)
+	}
 }
@@ -0,0 +1,181 @@
+package weightedroundrobin
+
+import (
+	"fmt"
+
+	"sysdesign/loadbalancing/balancer"
+	lberror "sysdesign/loadbalancing/error"
+)
+
+// Name identifies the weighted round robin algorithm in configuration.
+const Name = "weighted_round_robin"
+
+var (
+	_ balancer.Balancer    = (*WeightedRoundRobin)(nil)
+	_ balancer.SlowStarter = (*WeightedRoundRobin)(nil)
+)
+
+// Name returns the algorithm identifier.
+func (wrr *WeightedRoundRobin) Name() string {
+	return Name
+}
+
+// Pick returns the next server chosen by the weighted rotation. The request is
+// only used to explain the pick.
+func (wrr *WeightedRoundRobin) Pick(req *balancer.Request) (*balancer.Backend, error) {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	server, err := wrr.nextServer()
+	if err != nil {
+		return nil, err
+	}
+
+	if wrr.active == nil {
+		wrr.active = make(map[string]int)
+	}
+	wrr.active[server.Id]++
+	if req.Explained() {
+		req.Reason = fmt.Sprintf("weight %d of total %d", server.Weight, wrr.availableWeight())
+	}
+	return wrr.backendFor(*server), nil
+}
+
+// Release marks a pick of the backend as finished. A draining server is
+// removed with its last pick.
+func (wrr *WeightedRoundRobin) Release(backend *balancer.Backend) {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if wrr.active[backend.ID] > 0 {
+		wrr.active[backend.ID]--
+	}
+	if wrr.active[backend.ID] == 0 && wrr.conditions.Has(backend.ID, balancer.ConditionDraining) {
+		wrr.removeServer(backend.ID)
+	}
+}
+
+// AddBackend registers the backend as a server with the backend's weight.
+func (wrr *WeightedRoundRobin) AddBackend(backend balancer.Backend) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if wrr.indexOf(backend.ID) >= 0 {
+		return &lberror.DuplicateBackendError{ID: backend.ID}
+	}
+
+	if wrr.backends == nil {
+		wrr.backends = make(map[string]*balancer.Backend)
+	}
+	wrr.backends[backend.ID] = &backend
+	wrr.addServer(Server{Weight: backend.Weight, Id: backend.ID})
+	return nil
+}
+
+// RemoveBackend removes the server with the given ID and recomputes the
+// maximum and gcd weights of the remaining servers.
+func (wrr *WeightedRoundRobin) RemoveBackend(id string) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if !wrr.removeServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// SetWeight changes the weight of the server with the given ID.
+func (wrr *WeightedRoundRobin) SetWeight(id string, weight int) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if !wrr.updateWeight(id, weight) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Drain takes the server out of the rotation and removes it once its last
+// pick is released.
+func (wrr *WeightedRoundRobin) Drain(id string) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if !wrr.drainServer(id) {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	return nil
+}
+
+// Undrain puts the draining server back into the weighted rotation.
+func (wrr *WeightedRoundRobin) Undrain(id string) error {
+	return wrr.setCondition(id, balancer.ConditionDraining, false)
+}
+
+// SetHealthy takes the server out of or back into the weighted rotation.
+func (wrr *WeightedRoundRobin) SetHealthy(id string, healthy bool) error {
+	return wrr.setCondition(id, balancer.ConditionDown, !healthy)
+}
+
+// SetEjected takes the server out of or back into the weighted rotation.
+func (wrr *WeightedRoundRobin) SetEjected(id string, ejected bool) error {
+	return wrr.setCondition(id, balancer.ConditionEjected, ejected)
+}
+
+// setCondition turns a condition of the server on or off. A server that
+// becomes available again starts its slow start.
+func (wrr *WeightedRoundRobin) setCondition(id string, condition balancer.Condition, on bool) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	if wrr.indexOf(id) < 0 {
+		return &lberror.BackendNotFoundError{ID: id}
+	}
+	wasAvailable := wrr.conditions.Available(id)
+	wrr.conditions.Set(id, condition, on)
+	if !wasAvailable && wrr.conditions.Available(id) {
+		wrr.slowStarts.Begin(id)
+	}
+	return nil
+}
+
+// Snapshot returns every server in rotation order.
+func (wrr *WeightedRoundRobin) Snapshot() []balancer.BackendStatus {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+
+	statuses := make([]balancer.BackendStatus, 0, len(wrr.servers))
+	for _, server := range wrr.servers {
+		statuses = append(statuses, wrr.conditions.Status(balancer.BackendStatus{
+			Backend:     *wrr.backendFor(server),
+			Connections: wrr.active[server.Id],
+		}))
+	}
+	return statuses
+}
+
+// indexOf returns the position of the server with the given ID, or -1.
+// The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) indexOf(id string) int {
+	for i, server := range wrr.servers {
+		if server.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// backendFor returns the backend registered for server. Servers added through
+// AddServer have no backend yet, so one is created using the server ID as the
+// address. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) backendFor(server Server) *balancer.Backend {
+	if b, ok := wrr.backends[server.Id]; ok {
+		return b
+	}
+	if wrr.backends == nil {
+		wrr.backends = make(map[string]*balancer.Backend)
+	}
+	b := &balancer.Backend{ID: server.Id, Address: server.Id, Weight: server.Weight}
+	wrr.backends[server.Id] = b
+	return b
+}
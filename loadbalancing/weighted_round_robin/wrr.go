@@ -2,15 +2,33 @@ package weightedroundrobin
 
 import (
 	"errors"
+	"math"
 	"sync"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 // Server represents a backend server in the load balancer.
 type Server struct {
 	Weight int    // The weight of the server, determining its selection frequency
 	Id     string // A unique identifier for the server
+
+	smoothWeight int // Running weight of the server in Smooth mode
 }
 
+// Mode selects how servers are interleaved.
+type Mode int
+
+const (
+	// Smooth spreads the picks of every server evenly over the cycle, as
+	// nginx does: weights 5, 1, 1 give A, A, B, A, C, A, A.
+	Smooth Mode = iota
+	// Interleaved lowers a weight threshold by the gcd of the weights every
+	// pass, which sends bursts to the heaviest server: weights 5, 1, 1 give
+	// A, A, A, A, A, B, C.
+	Interleaved
+)
+
 // WeightedRoundRobin is the main struct implementing the Weighted Round Robin algorithm.
 type WeightedRoundRobin struct {
 	servers       []Server   // Slice of all registered servers
@@ -19,8 +37,19 @@ type WeightedRoundRobin struct {
 	maxWeight     int        // Maximum weight among all servers
 	currentWeight int        // Current weight in the selection algorithm
 	gcdWeight     int        // Greatest common divisor of all server weights
+	mode          Mode       // Selection algorithm, Smooth unless changed with SetMode
+
+	backends   map[string]*balancer.Backend // Backend details for servers added through AddBackend
+	active     map[string]int               // Picks not yet released, per server ID
+	conditions balancer.Conditions          // Reasons servers are left out of picks, keyed by ID
+	slowStarts balancer.SlowStarts          // When servers began ramping up, keyed by ID
 }
 
+// smoothScale multiplies weights in Smooth mode so that servers in slow start
+// can get fractions of their weight. Scaling every weight alike leaves the
+// sequence unchanged.
+const smoothScale = 1000
+
 // gcd computes the greatest common divisor of two numbers using the Euclidean algorithm.
 func gcd(a, b int) int {
 	for b != 0 {
@@ -42,8 +71,18 @@ func WeightedRoundRobinBalancer(connections int) *WeightedRoundRobin {
 func (wrr *WeightedRoundRobin) AddServer(server Server) {
 	wrr.mutex.Lock()
 	defer wrr.mutex.Unlock()
+	wrr.addServer(server)
+}
 
+// addServer appends server, starts its slow start and folds its weight into
+// maxWeight and gcdWeight. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) addServer(server Server) {
 	wrr.servers = append(wrr.servers, server)
+	wrr.slowStarts.Begin(server.Id)
+	if wrr.slowStarts.Ramping() {
+		wrr.recomputeWeights()
+		return
+	}
 
 	if len(wrr.servers) == 1 {
 		wrr.maxWeight = server.Weight
@@ -57,15 +96,89 @@ func (wrr *WeightedRoundRobin) AddServer(server Server) {
 	}
 }
 
+// RemoveServer takes the server with the given ID out of the rotation and
+// recomputes the maximum and gcd weights of the remaining servers.
+// Returns an error if the server is not found.
+func (wrr *WeightedRoundRobin) RemoveServer(id string) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+	if !wrr.removeServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// UpdateWeight changes the weight of the server with the given ID. Negative
+// weights are set to 0, which keeps the server out of the rotation.
+// Returns an error if the server is not found.
+func (wrr *WeightedRoundRobin) UpdateWeight(id string, weight int) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+	if !wrr.updateWeight(id, weight) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// DrainServer stops handing out the server with the given ID and removes it
+// once every pick of it made through Pick has been released, at once if there
+// is none. Returns an error if the server is not found.
+func (wrr *WeightedRoundRobin) DrainServer(id string) error {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+	if !wrr.drainServer(id) {
+		return errors.New("server not found")
+	}
+	return nil
+}
+
+// SetSlowStart makes servers that are added or come back up ramp from a
+// fraction of their weight to their full weight over the configured window.
+// In Interleaved mode effective weights are whole numbers, so the ramp of a
+// light server is coarse and a server of weight 1 does not ramp at all.
+func (wrr *WeightedRoundRobin) SetSlowStart(config balancer.SlowStart) {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+	wrr.slowStarts.Configure(config)
+	wrr.recomputeWeights()
+}
+
+// SetMode switches the selection algorithm. The position in the cycle of the
+// new mode starts from where that mode was last left.
+func (wrr *WeightedRoundRobin) SetMode(mode Mode) {
+	wrr.mutex.Lock()
+	defer wrr.mutex.Unlock()
+	wrr.mode = mode
+}
+
 // NextServer selects the next server based on the Weighted Round Robin algorithm.
-// It returns a pointer to the selected server and an error if no server is available.
+// It returns a copy of the selected server, which later changes to the
+// balancer do not affect, and an error if no server is available.
 func (wrr *WeightedRoundRobin) NextServer() (*Server, error) {
 	wrr.mutex.Lock()
 	defer wrr.mutex.Unlock()
+	server, err := wrr.nextServer()
+	if err != nil {
+		return nil, err
+	}
+	selected := *server
+	return &selected, nil
+}
 
+// nextServer runs one selection of the algorithm. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) nextServer() (*Server, error) {
 	if len(wrr.servers) == 0 {
 		return nil, errors.New("no server found")
 	}
+	if wrr.maxWeight > 0 && !wrr.hasHealthyServer() {
+		return nil, errors.New("no healthy server found")
+	}
+	if wrr.mode == Smooth {
+		return wrr.nextSmoothServer()
+	}
+	if wrr.slowStarts.Ramping() {
+		wrr.recomputeWeights()
+	}
 
 	for {
 		// Move to the next server, wrapping around if necessary
@@ -84,9 +197,152 @@ func (wrr *WeightedRoundRobin) NextServer() (*Server, error) {
 			}
 		}
 
-		// If the current server's weight is sufficient, select it
-		if wrr.servers[wrr.current].Weight >= wrr.currentWeight {
+		// If the current server is healthy and its weight is sufficient, select it
+		server := wrr.servers[wrr.current]
+		if wrr.conditions.Available(server.Id) && wrr.effectiveWeight(server) >= wrr.currentWeight {
 			return &wrr.servers[wrr.current], nil
 		}
 	}
 }
+
+// nextSmoothServer runs one selection of smooth weighted round robin: every
+// available server's running weight grows by its weight, the server with the
+// highest running weight is picked and its running weight drops by the total.
+// Weights changed at runtime apply from the next pick on, the running weights
+// carry over. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) nextSmoothServer() (*Server, error) {
+	total := 0
+	var best *Server
+	for i := range wrr.servers {
+		server := &wrr.servers[i]
+		if !wrr.conditions.Available(server.Id) || server.Weight <= 0 {
+			continue
+		}
+		weight := wrr.smoothWeightOf(*server)
+		server.smoothWeight += weight
+		total += weight
+		if best == nil || server.smoothWeight > best.smoothWeight {
+			best = server
+		}
+	}
+	if best == nil {
+		return nil, errors.New("bad weight")
+	}
+
+	best.smoothWeight -= total
+	return best, nil
+}
+
+// hasHealthyServer reports whether a server that is up has a positive weight,
+// without one the selection loop would never terminate.
+// The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) hasHealthyServer() bool {
+	for _, server := range wrr.servers {
+		if wrr.conditions.Available(server.Id) && server.Weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// availableWeight returns the summed weight of the servers that can be
+// picked. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) availableWeight() int {
+	total := 0
+	for _, server := range wrr.servers {
+		if wrr.conditions.Available(server.Id) && server.Weight > 0 {
+			total += server.Weight
+		}
+	}
+	return total
+}
+
+// effectiveWeight returns the weight of server in Interleaved mode, which is
+// lowered while the server is in slow start. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) effectiveWeight(server Server) int {
+	factor := wrr.slowStarts.Factor(server.Id)
+	if factor >= 1 || server.Weight <= 0 {
+		return server.Weight
+	}
+	return max(1, int(math.Round(float64(server.Weight)*factor)))
+}
+
+// smoothWeightOf returns the weight of server in Smooth mode, scaled by
+// smoothScale and lowered while the server is in slow start.
+// The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) smoothWeightOf(server Server) int {
+	factor := wrr.slowStarts.Factor(server.Id)
+	return max(1, int(math.Round(float64(server.Weight*smoothScale)*factor)))
+}
+
+// recomputeWeights recalculates maxWeight and gcdWeight from the effective
+// weights of the current servers, which is needed whenever a server leaves,
+// changes weight or is in slow start. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) recomputeWeights() {
+	wrr.maxWeight, wrr.gcdWeight = 0, 0
+	for _, server := range wrr.servers {
+		weight := wrr.effectiveWeight(server)
+		if weight > wrr.maxWeight {
+			wrr.maxWeight = weight
+		}
+		wrr.gcdWeight = gcd(wrr.gcdWeight, weight)
+	}
+	if wrr.currentWeight > wrr.maxWeight {
+		wrr.currentWeight = wrr.maxWeight
+	}
+}
+
+// removeServer deletes the server and everything recorded about it and
+// recomputes the weights. It reports whether the server was found.
+// The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) removeServer(id string) bool {
+	i := wrr.indexOf(id)
+	if i < 0 {
+		return false
+	}
+
+	wrr.servers = append(wrr.servers[:i], wrr.servers[i+1:]...)
+	if i <= wrr.current {
+		wrr.current--
+	}
+	delete(wrr.backends, id)
+	delete(wrr.active, id)
+	wrr.conditions.Forget(id)
+	wrr.slowStarts.Forget(id)
+	wrr.recomputeWeights()
+	return true
+}
+
+// updateWeight applies weight to the server and recomputes the weights. It
+// reports whether the server was found. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) updateWeight(id string, weight int) bool {
+	i := wrr.indexOf(id)
+	if i < 0 {
+		return false
+	}
+	if weight < 0 {
+		weight = 0
+	}
+
+	wrr.servers[i].Weight = weight
+	wrr.recomputeWeights()
+	if b, ok := wrr.backends[id]; ok {
+		updated := *b
+		updated.Weight = weight
+		wrr.backends[id] = &updated
+	}
+	return true
+}
+
+// drainServer marks the server as draining and removes it if it has no picks
+// left. It reports whether the server was found. The caller must hold wrr.mutex.
+func (wrr *WeightedRoundRobin) drainServer(id string) bool {
+	if wrr.indexOf(id) < 0 {
+		return false
+	}
+	wrr.conditions.Set(id, balancer.ConditionDraining, true)
+	if wrr.active[id] == 0 {
+		wrr.removeServer(id)
+	}
+	return true
+}
@@ -1,7 +1,11 @@
 package weightedroundrobin
 
 import (
+	"reflect"
 	"testing"
+	"time"
+
+	"sysdesign/loadbalancing/balancer"
 )
 
 func TestNoServers(t *testing.T) {
@@ -57,3 +61,184 @@ func TestAddServerIncreasesMaxWeight(t *testing.T) {
 		t.Errorf("expected maxWeight %d, got %d", expectedMaxWeight, wrr.maxWeight)
 	}
 }
+
+func TestRemoveBackendRecomputesWeights(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(3)
+	wrr.AddServer(Server{Weight: 4, Id: "Server 1"})
+	wrr.AddServer(Server{Weight: 6, Id: "Server 2"})
+	wrr.AddServer(Server{Weight: 3, Id: "Server 3"})
+
+	if err := wrr.RemoveBackend("Server 2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := wrr.RemoveBackend("Server 3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if wrr.maxWeight != 4 {
+		t.Errorf("expected maxWeight 4, got %d", wrr.maxWeight)
+	}
+	if wrr.gcdWeight != 4 {
+		t.Errorf("expected gcdWeight 4, got %d", wrr.gcdWeight)
+	}
+}
+
+func TestRemoveServerRecomputesWeights(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(3)
+	wrr.SetMode(Interleaved)
+	wrr.AddServer(Server{Weight: 2, Id: "Server 1"})
+	wrr.AddServer(Server{Weight: 3, Id: "Server 2"})
+	wrr.AddServer(Server{Weight: 4, Id: "Server 3"})
+
+	if err := wrr.RemoveServer("Server 2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := wrr.RemoveServer("Server 2"); err == nil {
+		t.Error("expected an error removing a missing server")
+	}
+	if wrr.maxWeight != 4 || wrr.gcdWeight != 2 {
+		t.Errorf("expected maxWeight 4 and gcdWeight 2, got %d and %d", wrr.maxWeight, wrr.gcdWeight)
+	}
+
+	got := sequence(t, wrr, 3)
+	want := []string{"Server 3", "Server 1", "Server 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestUpdateWeight(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(2)
+	wrr.AddServer(Server{Weight: 2, Id: "Server 1"})
+	wrr.AddServer(Server{Weight: 4, Id: "Server 2"})
+
+	if err := wrr.UpdateWeight("Server 2", 6); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wrr.maxWeight != 6 || wrr.gcdWeight != 2 {
+		t.Errorf("expected maxWeight 6 and gcdWeight 2, got %d and %d", wrr.maxWeight, wrr.gcdWeight)
+	}
+	if err := wrr.UpdateWeight("Server 3", 1); err == nil {
+		t.Error("expected an error updating a missing server")
+	}
+}
+
+func sequence(t *testing.T, wrr *WeightedRoundRobin, n int) []string {
+	t.Helper()
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		server, err := wrr.NextServer()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, server.Id)
+	}
+	return ids
+}
+
+func TestSmoothSequence(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(3)
+	wrr.AddServer(Server{Weight: 5, Id: "A"})
+	wrr.AddServer(Server{Weight: 1, Id: "B"})
+	wrr.AddServer(Server{Weight: 1, Id: "C"})
+
+	expected := []string{"A", "A", "B", "A", "C", "A", "A", "A", "A", "B", "A", "C", "A", "A"}
+	if got := sequence(t, wrr, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestInterleavedSequence(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(3)
+	wrr.SetMode(Interleaved)
+	wrr.AddServer(Server{Weight: 5, Id: "A"})
+	wrr.AddServer(Server{Weight: 1, Id: "B"})
+	wrr.AddServer(Server{Weight: 1, Id: "C"})
+
+	// The first cycle starts one threshold below the maximum weight.
+	expected := []string{"A", "A", "A", "A", "B", "C", "A", "A", "A", "A", "A", "B", "C"}
+	if got := sequence(t, wrr, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSmoothWeightChangeKeepsSequence(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(2)
+	wrr.AddServer(Server{Weight: 1, Id: "A"})
+	wrr.AddServer(Server{Weight: 1, Id: "B"})
+
+	if got := sequence(t, wrr, 1); got[0] != "A" {
+		t.Fatalf("expected A first, got %s", got[0])
+	}
+	if err := wrr.SetWeight("B", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// B is owed a pick from before the change and gets three of every four after it.
+	counts := map[string]int{}
+	for _, id := range sequence(t, wrr, 9) {
+		counts[id]++
+	}
+	if counts["A"] != 2 || counts["B"] != 7 {
+		t.Errorf("expected A twice and B seven times, got %v", counts)
+	}
+}
+
+func TestSmoothSkipsUnavailable(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(3)
+	wrr.AddServer(Server{Weight: 5, Id: "A"})
+	wrr.AddServer(Server{Weight: 1, Id: "B"})
+	wrr.AddServer(Server{Weight: 1, Id: "C"})
+	if err := wrr.SetHealthy("A", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"B", "C", "B", "C"}
+	if got := sequence(t, wrr, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSlowStartRampsNewServer(t *testing.T) {
+	now := time.Unix(0, 0)
+	wrr := WeightedRoundRobinBalancer(2)
+	wrr.AddServer(Server{Weight: 1, Id: "A"})
+	wrr.SetSlowStart(balancer.SlowStart{Window: 10 * time.Second, Now: func() time.Time { return now }})
+	wrr.AddServer(Server{Weight: 1, Id: "B"})
+
+	count := func() int {
+		picks := 0
+		for _, id := range sequence(t, wrr, 110) {
+			if id == "B" {
+				picks++
+			}
+		}
+		return picks
+	}
+	if got := count(); got != 10 {
+		t.Errorf("expected B to start with a tenth of A's picks, got %d of 110", got)
+	}
+
+	now = now.Add(10 * time.Second)
+	if got := count(); got != 55 {
+		t.Errorf("expected B to get its full share after the window, got %d of 110", got)
+	}
+}
+
+func TestNextServerReturnsACopy(t *testing.T) {
+	wrr := WeightedRoundRobinBalancer(2)
+	wrr.AddServer(Server{Weight: 1, Id: "Server 1"})
+	wrr.AddServer(Server{Weight: 1, Id: "Server 2"})
+
+	server, err := wrr.NextServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id := server.Id
+	if err := wrr.RemoveServer(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server.Id != id {
+		t.Errorf("expected the picked server to stay %q after removing it, got %q", id, server.Id)
+	}
+}